@@ -0,0 +1,7 @@
+package mod
+
+import fe "github.com/opesun/hypecms/modules/feeds"
+
+func init() {
+	modules["feeds"] = dyn{Hooks: fe.Hooks}
+}
\ No newline at end of file
@@ -0,0 +1,7 @@
+package mod
+
+import lo "github.com/opesun/hypecms/modules/localization"
+
+func init() {
+	modules["localization"] = dyn{Views: lo.Views, Hooks: lo.Hooks, Actions: lo.Actions}
+}
\ No newline at end of file
@@ -0,0 +1,7 @@
+package mod
+
+import me "github.com/opesun/hypecms/modules/media"
+
+func init() {
+	modules["media"] = dyn{Views: me.Views, Hooks: me.Hooks, Actions: me.Actions}
+}
\ No newline at end of file
@@ -0,0 +1,7 @@
+package mod
+
+import oc "github.com/opesun/hypecms/modules/output_cache"
+
+func init() {
+	modules["output_cache"] = dyn{Views: oc.Views, Hooks: oc.Hooks, Actions: oc.Actions}
+}
\ No newline at end of file
@@ -0,0 +1,7 @@
+package mod
+
+import re "github.com/opesun/hypecms/modules/redirects"
+
+func init() {
+	modules["redirects"] = dyn{Views: re.Views, Hooks: re.Hooks, Actions: re.Actions}
+}
\ No newline at end of file
@@ -0,0 +1,7 @@
+package mod
+
+import si "github.com/opesun/hypecms/modules/sitemap"
+
+func init() {
+	modules["sitemap"] = dyn{Views: si.Views, Hooks: si.Hooks, Actions: si.Actions}
+}
\ No newline at end of file
@@ -0,0 +1,7 @@
+package mod
+
+import tr "github.com/opesun/hypecms/modules/transfer"
+
+func init() {
+	modules["transfer"] = dyn{Views: tr.Views, Hooks: tr.Hooks, Actions: tr.Actions}
+}
\ No newline at end of file
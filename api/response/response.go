@@ -0,0 +1,195 @@
+// Package response wraps the http.ResponseWriter of a request to add conditional responses and compression.
+// Pages are buffered, so they can get an ETag and a 304 if the client already has them, files are streamed trough.
+// HTML, JSON, CSS and JS is compressed with gzip or deflate, whichever the client prefers.
+//
+// Cache-Control headers can be set per path prefix in the options, the longest matching prefix wins:
+//
+//	"Cache-control": {"/template/": "public, max-age=86400", "/shared/": "public, max-age=86400", "/": "no-cache"}
+package response
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"fmt"
+	"github.com/opesun/jsonp"
+	"hash/fnv"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+var compressible = []string{"text/html", "application/json", "text/css", "application/javascript", "application/x-javascript", "text/javascript"}
+
+type Writer struct {
+	http.ResponseWriter
+	req         *http.Request
+	buffered    bool
+	buf         bytes.Buffer
+	status      int
+	wroteHeader bool
+	closed      bool
+	comp        io.WriteCloser
+}
+
+// Wraps w, if buffered is true the whole response is kept in memory until Close.
+func New(w http.ResponseWriter, req *http.Request, buffered bool) *Writer {
+	return &Writer{ResponseWriter: w, req: req, buffered: buffered, status: http.StatusOK}
+}
+
+// Sets the Cache-Control header according to the rules in the options.
+func CacheControl(w http.ResponseWriter, opt map[string]interface{}, path string) {
+	rules, ok := jsonp.GetM(opt, "Cache-control")
+	if !ok {
+		return
+	}
+	best := ""
+	val := ""
+	for prefix, v := range rules {
+		s, ok := v.(string)
+		if ok && strings.HasPrefix(path, prefix) && len(prefix) >= len(best) {
+			best, val = prefix, s
+		}
+	}
+	if len(val) > 0 {
+		w.Header().Set("Cache-Control", val)
+	}
+}
+
+// Chooses the encoding from the Accept-Encoding header, "" means no compression.
+func encoding(accept string) string {
+	best, best_q := "", 0.0
+	for _, v := range strings.Split(accept, ",") {
+		parts := strings.Split(v, ";")
+		name := strings.ToLower(strings.TrimSpace(parts[0]))
+		if name != "gzip" && name != "deflate" {
+			continue
+		}
+		q := 1.0
+		for _, p := range parts[1:] {
+			p = strings.TrimSpace(p)
+			if strings.HasPrefix(p, "q=") {
+				q, _ = strconv.ParseFloat(p[2:], 64)
+			}
+		}
+		if q > best_q || q == best_q && name == "gzip" {
+			best, best_q = name, q
+		}
+	}
+	return best
+}
+
+func (w *Writer) shouldCompress() bool {
+	h := w.Header()
+	if w.status != http.StatusOK || len(h.Get("Content-Encoding")) > 0 || len(h.Get("Content-Range")) > 0 {
+		return false
+	}
+	ctype := h.Get("Content-Type")
+	for _, v := range compressible {
+		if strings.HasPrefix(ctype, v) {
+			return true
+		}
+	}
+	return false
+}
+
+// Sends the header, setting up the compression if needed.
+func (w *Writer) sendHeader() {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	if w.shouldCompress() {
+		h := w.Header()
+		h.Add("Vary", "Accept-Encoding")
+		switch encoding(w.req.Header.Get("Accept-Encoding")) {
+		case "gzip":
+			w.comp = gzip.NewWriter(w.ResponseWriter)
+			h.Set("Content-Encoding", "gzip")
+			h.Del("Content-Length")
+		case "deflate":
+			w.comp, _ = flate.NewWriter(w.ResponseWriter, flate.DefaultCompression)
+			h.Set("Content-Encoding", "deflate")
+			h.Del("Content-Length")
+		}
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+}
+
+// Turns off the buffering, for big downloads served by the modules (they get no ETag). Has no effect once something is written.
+func (w *Writer) Stream() {
+	if !w.wroteHeader && w.buf.Len() == 0 {
+		w.buffered = false
+	}
+}
+
+func (w *Writer) WriteHeader(status int) {
+	if w.wroteHeader {
+		return
+	}
+	w.status = status
+	if !w.buffered || w.closed {
+		w.sendHeader()
+	}
+}
+
+func (w *Writer) Write(b []byte) (int, error) {
+	if w.buffered && !w.closed {
+		return w.buf.Write(b)
+	}
+	if len(w.Header().Get("Content-Type")) == 0 {
+		w.Header().Set("Content-Type", http.DetectContentType(b))
+	}
+	w.sendHeader()
+	if w.comp != nil {
+		return w.comp.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func matches(inm, etag string) bool {
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, v := range strings.Split(inm, ",") {
+		v = strings.TrimPrefix(strings.TrimSpace(v), "W/")
+		if v == etag || v == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// Writes out the buffered page (or a 304) and finishes the compression.
+func (w *Writer) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	if w.buffered && w.status == http.StatusOK && (w.req.Method == "GET" || w.req.Method == "HEAD") {
+		h := w.Header()
+		etag := h.Get("ETag")
+		if len(etag) == 0 {
+			sum := fnv.New64a()
+			sum.Write(w.buf.Bytes())
+			etag = fmt.Sprintf(`W/"%x"`, sum.Sum64()) // Weak, the compressed and the plain versions are the same page.
+			h.Set("ETag", etag)
+		}
+		if inm := w.req.Header.Get("If-None-Match"); len(inm) > 0 && matches(inm, etag) {
+			h.Del("Content-Type")
+			h.Del("Content-Length")
+			w.status = http.StatusNotModified
+			w.sendHeader()
+			return nil
+		}
+	}
+	if w.buffered && w.buf.Len() > 0 {
+		if _, err := w.Write(w.buf.Bytes()); err != nil {
+			return err
+		}
+	}
+	w.sendHeader()
+	if w.comp != nil {
+		return w.comp.Close()
+	}
+	return nil
+}
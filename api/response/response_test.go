@@ -0,0 +1,34 @@
+package response
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestStream(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/transfer/download?name=x.zip", nil)
+	rec := httptest.NewRecorder()
+	w := New(rec, req, true)
+	w.Stream()
+	w.Header().Set("Content-Type", "application/zip")
+	w.Write([]byte("PK"))
+	if rec.Body.String() != "PK" || w.buf.Len() != 0 {
+		t.Fatal("A streamed response should not be buffered.")
+	}
+	w.Close()
+	if len(rec.Header().Get("ETag")) != 0 {
+		t.Fatal("Streamed responses get no ETag.")
+	}
+	rec = httptest.NewRecorder()
+	w = New(rec, req, true)
+	w.Write([]byte("<html>"))
+	w.Stream() // Too late.
+	if rec.Body.Len() != 0 {
+		t.Fatal("Already buffered pages should stay buffered.")
+	}
+	w.Close()
+	if rec.Body.String() != "<html>" {
+		t.Fatal(rec.Body.String())
+	}
+}
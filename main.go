@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"github.com/opesun/hypecms/api/context"
 	"github.com/opesun/hypecms/api/mod"
+	"github.com/opesun/hypecms/api/response"
 	"github.com/opesun/hypecms/api/shell"
 	"github.com/opesun/hypecms/model/main"
 	"github.com/opesun/hypecms/model/scut"
@@ -19,6 +20,7 @@ import (
 	"labix.org/v2/mgo"
 	"net/http"
 	"net/url"
+	"os"
 	"path/filepath"
 	"runtime/debug"
 	"strings"
@@ -140,6 +142,9 @@ func execFrontViews(uni *context.Uni) {
 		return hijacked
 	}
 	uni.Ev.Iterate("Front", i)
+	if _, written := uni.Dat["_written"]; written && err == nil {
+		return // A hook already wrote the whole response (eg. served a file).
+	}
 	if err == nil {
 		display.D(uni)
 	} else {
@@ -360,6 +365,12 @@ func getSite(session *mgo.Session, db *mgo.Database, w http.ResponseWriter, req
 	Put = func(a ...interface{}) {
 		io.WriteString(w, fmt.Sprint(a...)+"\n")
 	}
+	var rw *response.Writer
+	defer func() {
+		if rw != nil {
+			rw.Close() // Runs after err, so the panic message gets into the response too.
+		}
+	}()
 	defer err()
 	uni := &context.Uni{
 		Db:      db,
@@ -388,7 +399,12 @@ func getSite(session *mgo.Session, db *mgo.Database, w http.ResponseWriter, req
 	uni.SetSecret(SECRET)
 	first_p := uni.Paths[1]
 	last_p := uni.Paths[len(uni.Paths)-1]
-	if SERVE_FILES && strings.Index(last_p, ".") != -1 {
+	is_file := SERVE_FILES && isFile(ABS_PATH, req.Host, uni.Paths)
+	rw = response.New(w, req, !is_file) // Files are streamed, pages are buffered to get an ETag.
+	response.CacheControl(rw, opt, req.URL.Path)
+	w = rw
+	uni.W = rw
+	if is_file {
 		has_sfx := strings.HasSuffix(last_p, ".go")
 		if first_p == "template" || first_p == "tpl" && !has_sfx {
 			serveTemplateFile(w, req, uni)
@@ -407,6 +423,24 @@ func getSite(session *mgo.Session, db *mgo.Database, w http.ResponseWriter, req
 	runSite(uni)
 }
 
+// Tells if a path with a dot in its last segment should be served as a file. Uploads only count when they exist, so
+// /robots.txt, /sitemap.xml and the like still reach the Front hooks if there is no such file.
+func isFile(root, host string, paths []string) bool {
+	last_p := paths[len(paths)-1]
+	if strings.Index(last_p, ".") == -1 {
+		return false
+	}
+	if strings.HasSuffix(last_p, ".go") {
+		return true
+	}
+	switch paths[1] {
+	case "template", "tpl", "shared":
+		return true
+	}
+	fi, err := os.Stat(filepath.Join(root, "uploads", host, filepath.FromSlash(strings.Join(paths, "/"))))
+	return err == nil && !fi.IsDir()
+}
+
 // Since we don't include the template name into the url, only "template", we have to extract the template name from the opt here.
 // Example: xyz.com/template/style.css
 //			xyz.com/tpl/admin/style.css
@@ -422,6 +456,7 @@ func serveTemplateFile(w http.ResponseWriter, req *http.Request, uni *context.Un
 func main() {
 	fmt.Println("Server has started.")
 	handleConfigVars()
+	display.Debug = DEBUG
 	//if DEBUG {
 	//	modcheck.Check()
 	//}
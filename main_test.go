@@ -0,0 +1,42 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestIsFile(t *testing.T) {
+	root, err := ioutil.TempDir("", "hypecms")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	dir := filepath.Join(root, "uploads", "example.com", "images")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "a.png"), []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cases := map[string]bool{
+		"/robots.txt":                     false,
+		"/sitemap.xml":                    false,
+		"/sitemap-2.xml":                  false,
+		"/transfer/download/export-1.zip": false,
+		"/some/page":                      false,
+		"/images/a.png":                   true,
+		"/images/missing.png":             false,
+		"/template/style.css":             true,
+		"/tpl/admin/style.css":            true,
+		"/shared/jquery.js":               true,
+		"/modules/content/content.go":     true,
+	}
+	for p, exp := range cases {
+		if got := isFile(root, "example.com", strings.Split(p, "/")); got != exp {
+			t.Errorf("%v: expected %v, got %v", p, exp, got)
+		}
+	}
+}
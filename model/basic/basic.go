@@ -234,6 +234,13 @@ func InudOpt(db *mgo.Database, ev ifaces.Event, dat map[string]interface{}, coll
 	if err != nil {
 		return err
 	}
+	// Hooks should know which document was modified or deleted.
+	if op == "update" || op == "delete" {
+		if dat == nil {
+			dat = map[string]interface{}{}
+		}
+		dat["_id"] = bson.ObjectIdHex(id)
+	}
 	ev.Trigger(coll+"."+op, dat)
 	return nil
 }
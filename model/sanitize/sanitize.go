@@ -0,0 +1,311 @@
+// Package sanitize cleans user submitted HTML with an allowlist of tags, attributes and URL schemes.
+// Tags not on the list are dropped but their text is kept, except for script, style and the like, which go with their content.
+// Text is escaped, comments are removed, and the tags left open are closed at the end, so the result can't break the page around it.
+package sanitize
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"sort"
+	"strings"
+)
+
+type Policy struct {
+	Tags    map[string][]string // Allowed tags with their allowed attributes.
+	Schemes []string            // Allowed schemes of the URLs in href, src and cite attributes. Relative URLs are always allowed.
+}
+
+// Enough for the usual rich text editors.
+var Default = &Policy{
+	Tags: map[string][]string{
+		"a": {"href", "title"}, "img": {"src", "alt", "title", "width", "height"},
+		"p": {}, "br": {}, "hr": {}, "div": {}, "span": {},
+		"b": {}, "strong": {}, "i": {}, "em": {}, "u": {}, "s": {}, "strike": {}, "sub": {}, "sup": {}, "small": {},
+		"h1": {}, "h2": {}, "h3": {}, "h4": {}, "h5": {}, "h6": {},
+		"ul": {}, "ol": {}, "li": {}, "dl": {}, "dt": {}, "dd": {},
+		"blockquote": {"cite"}, "q": {"cite"}, "code": {}, "pre": {},
+		"table": {}, "thead": {}, "tbody": {}, "tr": {}, "th": {"colspan", "rowspan"}, "td": {"colspan", "rowspan"},
+	},
+	Schemes: []string{"http", "https", "mailto"},
+}
+
+var (
+	void = map[string]bool{"br": true, "hr": true, "img": true, "wbr": true}
+	// Disallowed tags which are removed together with their content.
+	dropped   = map[string]bool{"script": true, "style": true, "iframe": true, "object": true, "embed": true, "noscript": true, "textarea": true, "title": true, "template": true, "svg": true, "math": true}
+	url_attrs = map[string]bool{"href": true, "src": true, "cite": true}
+)
+
+// Builds a policy from the options. true means the default policy, a map replaces the tags and/or the schemes of it:
+//
+//	{"tags": {"p": [], "a": ["href", "title"]}, "schemes": ["http", "https"]}
+//
+// nil and false mean no sanitizing at all, the returned policy is nil then.
+func FromConfig(i interface{}) (*Policy, error) {
+	switch c := i.(type) {
+	case nil:
+		return nil, nil
+	case bool:
+		if c {
+			return Default, nil
+		}
+		return nil, nil
+	case map[string]interface{}:
+		p := &Policy{Tags: Default.Tags, Schemes: Default.Schemes}
+		if t, has := c["tags"]; has {
+			tags, ok := t.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("Sanitizer tags must be a map.")
+			}
+			p.Tags = map[string][]string{}
+			for tag, v := range tags {
+				attrs, err := stringList(v)
+				if err != nil {
+					return nil, fmt.Errorf("Attributes of tag %v: %v", tag, err)
+				}
+				p.Tags[strings.ToLower(tag)] = attrs
+			}
+		}
+		if s, has := c["schemes"]; has {
+			schemes, err := stringList(s)
+			if err != nil {
+				return nil, fmt.Errorf("Sanitizer schemes: %v", err)
+			}
+			p.Schemes = schemes
+		}
+		return p, nil
+	}
+	return nil, fmt.Errorf("Sanitizer config must be a bool or a map.")
+}
+
+func stringList(i interface{}) ([]string, error) {
+	sl, ok := i.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("not a list")
+	}
+	ret := []string{}
+	for _, v := range sl {
+		s, ok := v.(string)
+		if !ok {
+			return nil, fmt.Errorf("%v is not a string", v)
+		}
+		ret = append(ret, strings.ToLower(s))
+	}
+	return ret, nil
+}
+
+// Sanitizes s with the default policy.
+func Sanitize(s string) string {
+	return Default.Sanitize(s)
+}
+
+func has(sl []string, s string) bool {
+	for _, v := range sl {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+type attr struct {
+	name, val string
+}
+
+type tag struct {
+	name    string
+	closing bool
+	self    bool // Ends with "/>".
+	attrs   []attr
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f'
+}
+
+func isAlnum(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
+
+// Parses the tag at the beginning of s, returns the rest of s after it. ok is false if s does not start with a proper tag,
+// the "<" is taken as text then.
+func parseTag(s string) (t tag, rest string, ok bool) {
+	i := 1
+	if i < len(s) && s[i] == '/' {
+		t.closing = true
+		i++
+	}
+	beg := i
+	for i < len(s) && isAlnum(s[i]) {
+		i++
+	}
+	if i == beg || !(s[beg] >= 'a' && s[beg] <= 'z' || s[beg] >= 'A' && s[beg] <= 'Z') {
+		return t, s, false
+	}
+	t.name = strings.ToLower(s[beg:i])
+	for i < len(s) {
+		c := s[i]
+		switch {
+		case c == '>':
+			return t, s[i+1:], true
+		case isSpace(c):
+			i++
+			continue
+		case c == '/':
+			t.self = true
+			i++
+			continue
+		}
+		t.self = false
+		beg := i
+		for i < len(s) && !isSpace(s[i]) && s[i] != '=' && s[i] != '>' && s[i] != '/' {
+			i++
+		}
+		a := attr{name: strings.ToLower(s[beg:i])}
+		for i < len(s) && isSpace(s[i]) {
+			i++
+		}
+		if i < len(s) && s[i] == '=' {
+			i++
+			for i < len(s) && isSpace(s[i]) {
+				i++
+			}
+			if i < len(s) && (s[i] == '"' || s[i] == '\'') {
+				end := strings.IndexByte(s[i+1:], s[i])
+				if end == -1 {
+					return t, s, false
+				}
+				a.val = s[i+1 : i+1+end]
+				i += end + 2
+			} else {
+				beg := i
+				for i < len(s) && !isSpace(s[i]) && s[i] != '>' {
+					i++
+				}
+				a.val = s[beg:i]
+			}
+		}
+		t.attrs = append(t.attrs, a)
+	}
+	return t, s, false
+}
+
+// Relative URLs are fine, absolute ones must have an allowed scheme.
+// Browsers ignore whitespace and control characters in the scheme, so "java&#9;script:" is javascript too.
+func (p *Policy) urlAllowed(u string) bool {
+	clean := strings.Map(func(r rune) rune {
+		if r <= ' ' || r == 0x7f {
+			return -1
+		}
+		return r
+	}, u)
+	colon := strings.IndexByte(clean, ':')
+	if colon == -1 || strings.IndexAny(clean[:colon], "/?#") != -1 {
+		return true
+	}
+	return has(p.Schemes, strings.ToLower(clean[:colon]))
+}
+
+func (p *Policy) render(t tag) string {
+	allowed := p.Tags[t.name]
+	seen := map[string]bool{}
+	attrs := []string{}
+	for _, a := range t.attrs {
+		if !has(allowed, a.name) || seen[a.name] {
+			continue
+		}
+		val := html.UnescapeString(a.val)
+		if url_attrs[a.name] && !p.urlAllowed(val) {
+			continue
+		}
+		seen[a.name] = true
+		attrs = append(attrs, " "+a.name+`="`+html.EscapeString(val)+`"`)
+	}
+	sort.Strings(attrs)
+	if void[t.name] {
+		return "<" + t.name + strings.Join(attrs, "") + " />"
+	}
+	return "<" + t.name + strings.Join(attrs, "") + ">"
+}
+
+var text_escaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+// Entities are decoded first so the valid ones are kept while a stray & gets escaped.
+func escapeText(s string) string {
+	return text_escaper.Replace(html.UnescapeString(s))
+}
+
+// Returns the rest of s after the closing tag of name, or "" if there is none.
+func skipElement(s, name string) string {
+	i := strings.Index(strings.ToLower(s), "</"+name)
+	if i == -1 {
+		return ""
+	}
+	end := strings.IndexByte(s[i:], '>')
+	if end == -1 {
+		return ""
+	}
+	return s[i+end+1:]
+}
+
+func (p *Policy) Sanitize(s string) string {
+	var out bytes.Buffer
+	open := []string{}
+	for len(s) > 0 {
+		i := strings.IndexByte(s, '<')
+		if i == -1 {
+			out.WriteString(escapeText(s))
+			break
+		}
+		out.WriteString(escapeText(s[:i]))
+		s = s[i:]
+		if strings.HasPrefix(s, "<!--") {
+			end := strings.Index(s[4:], "-->")
+			if end == -1 {
+				break
+			}
+			s = s[4+end+3:]
+			continue
+		}
+		t, rest, ok := parseTag(s)
+		if !ok {
+			out.WriteString("&lt;")
+			s = s[1:]
+			continue
+		}
+		s = rest
+		_, allowed := p.Tags[t.name]
+		switch {
+		case !allowed:
+			if dropped[t.name] && !t.closing && !t.self {
+				s = skipElement(s, t.name)
+			}
+		case t.closing:
+			for j := len(open) - 1; j >= 0; j-- {
+				if open[j] != t.name {
+					continue
+				}
+				for k := len(open) - 1; k >= j; k-- {
+					out.WriteString("</" + open[k] + ">")
+				}
+				open = open[:j]
+				break
+			}
+		default:
+			out.WriteString(p.render(t))
+			if void[t.name] {
+				continue
+			}
+			if t.self {
+				out.WriteString("</" + t.name + ">")
+				continue
+			}
+			open = append(open, t.name)
+		}
+	}
+	for i := len(open) - 1; i >= 0; i-- {
+		out.WriteString("</" + open[i] + ">")
+	}
+	return out.String()
+}
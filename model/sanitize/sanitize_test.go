@@ -0,0 +1,55 @@
+package sanitize
+
+import (
+	"testing"
+)
+
+func TestSanitize(t *testing.T) {
+	cases := [][2]string{
+		{`<p>Hello <b>world</b></p>`, `<p>Hello <b>world</b></p>`},
+		{`<p onclick="x()">a</p>`, `<p>a</p>`},
+		{`<script>alert(1)</script>ok`, `ok`},
+		{`<SCRIPT src=x></SCRIPT >ok`, `ok`},
+		{`<a href="javascript:alert(1)">x</a>`, `<a>x</a>`},
+		{`<a href="java&#9;script:alert(1)">x</a>`, `<a>x</a>`},
+		{`<a href="/page?a=1&amp;b=2" title='t"'>x</a>`, `<a href="/page?a=1&amp;b=2" title="t&#34;">x</a>`},
+		{`<a href=http://example.com>x</a>`, `<a href="http://example.com">x</a>`},
+		{`<img src="data:image/png;base64,xx" alt=pic>`, `<img alt="pic" />`},
+		{`<p><em>open`, `<p><em>open</em></p>`},
+		{`<b><i>x</b></i>`, `<b><i>x</i></b>`},
+		{`</div>stray`, `stray`},
+		{`a < b && c > d &amp; &copy;`, `a &lt; b &amp;&amp; c &gt; d &amp; ©`},
+		{`<font color=red>text</font>`, `text`},
+		{`x<!-- hidden -->y`, `xy`},
+		{`<p title="unclosed>x`, `&lt;p title="unclosed&gt;x`},
+		{`<br/><b/>`, `<br /><b></b>`},
+	}
+	for _, v := range cases {
+		if out := Sanitize(v[0]); out != v[1] {
+			t.Errorf("%v: got %v, expected %v", v[0], out, v[1])
+		}
+	}
+}
+
+func TestFromConfig(t *testing.T) {
+	p, err := FromConfig(map[string]interface{}{
+		"tags":    map[string]interface{}{"a": []interface{}{"href"}},
+		"schemes": []interface{}{"https"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := p.Sanitize(`<p><a href="http://a.com" title="t">x</a> <a href="https://b.com">y</a></p>`)
+	if out != `<a>x</a> <a href="https://b.com">y</a>` {
+		t.Fatal(out)
+	}
+	if p, err = FromConfig(nil); p != nil || err != nil {
+		t.Fatal(p, err)
+	}
+	if p, _ = FromConfig(true); p != Default {
+		t.Fatal(p)
+	}
+	if _, err = FromConfig("x"); err == nil {
+		t.Fatal("Should not accept a string.")
+	}
+}
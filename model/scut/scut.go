@@ -5,10 +5,12 @@ package scut
 import (
 	"fmt"
 	"github.com/opesun/numcon"
+	"hash/fnv"
 	"io/ioutil"
 	"labix.org/v2/mgo/bson"
 	"path/filepath"
 	"sort"
+	"strconv"
 	"strings"
 )
 
@@ -253,3 +255,11 @@ func OnlyAdmin(dat map[string]interface{}) {
 		panic("Only an admin can do this operation.")
 	}
 }
+
+// A short hash of the original string representation of the options, changes every time the options are saved.
+// Handy for keying caches which depend on the options.
+func OptVersion(opt_str string) string {
+	h := fnv.New64a()
+	h.Write([]byte(opt_str))
+	return strconv.FormatUint(h.Sum64(), 16)
+}
@@ -78,9 +78,9 @@ func (a *A) saveDraft() error {
 	if allows != nil {
 		return allows
 	}
-	rules, has_rules := jsonp.GetM(uni.Opt, "Modules.content.types."+typ+".rules")
-	if !has_rules {
-		return fmt.Errorf("Can't find rules of content type %v.", typ)
+	rules, _, err := content_model.TypeRules(content_type_opt) // Drafts are not validated, and repeatable groups are not saved in them yet.
+	if err != nil {
+		return err
 	}
 	draft_id, err := content_model.SaveDraft(uni.Db, rules, map[string][]string(post))
 	// Handle redirect.
@@ -113,11 +113,11 @@ func (a *A) insert() error {
 	if prep_err != nil {
 		return prep_err
 	}
-	rule, hasrule := jsonp.Get(uni.Opt, "Modules.content.types."+typ+".rules")
-	if !hasrule {
-		return fmt.Errorf("Can't find content type rules " + typ)
+	type_opt, has := jsonp.GetM(uni.Opt, "Modules.content.types."+typ)
+	if !has {
+		return fmt.Errorf("Can't find content type " + typ)
 	}
-	id, err := content_model.Insert(uni.Db, uni.Ev, rule.(map[string]interface{}), uni.Req.Form, uid)
+	id, err := content_model.Insert(uni.Db, uni.Ev, uni.Opt, type_opt, uni.Req.Form, uid)
 	if err != nil {
 		return err
 	}
@@ -144,11 +144,11 @@ func (a *A) update() error {
 	if prep_err != nil {
 		return prep_err
 	}
-	rule, hasrule := jsonp.Get(uni.Opt, "Modules.content.types."+typ+".rules")
-	if !hasrule {
-		return fmt.Errorf("Can't find content type rules " + typ)
+	type_opt, has := jsonp.GetM(uni.Opt, "Modules.content.types."+typ)
+	if !has {
+		return fmt.Errorf("Can't find content type " + typ)
 	}
-	err := content_model.Update(uni.Db, uni.Ev, rule.(map[string]interface{}), uni.Req.Form, uid)
+	err := content_model.Update(uni.Db, uni.Ev, uni.Opt, type_opt, uni.Req.Form, uid)
 	if err != nil {
 		return err
 	}
@@ -186,7 +186,16 @@ func (a *A) Delete() error {
 	if !has {
 		return fmt.Errorf("No id sent from form when deleting content.")
 	}
-	return content_model.Delete(uni.Db, uni.Ev, id, uid)[0] // HACK for now.
+	return content_model.Delete(uni.Db, uni.Ev, uni.Opt, id, uid)[0] // HACK for now.
+}
+
+// Rebuilds the search index and the fulltext fields of all contents.
+func (a *A) RegenerateFulltext() error {
+	uni := a.uni
+	if scut.Ulev(uni.Dat["_user"]) < 300 {
+		return fmt.Errorf("Only an admin can regenerate the search index.")
+	}
+	return content_model.RegenerateFulltext(uni.Db, uni.Opt)
 }
 
 // Return values: content type, general (fatal) error, puzzle error
@@ -278,8 +287,20 @@ func (a *A) DeleteComment() error {
 	return content_model.DeleteComment(uni.Db, uni.Ev, uni.Req.Form, uid.(bson.ObjectId))
 }
 
+// Approves a comment waiting in the moderation queue.
 func (a *A) MoveToFinal() error {
-	return nil
+	return content_model.MoveToFinalWE(a.uni.Db, a.uni.Ev, a.uni.Req.Form)
+}
+
+// Moves the comments embedded into contents to their own collection.
+func (a *A) MigrateComments() error {
+	uni := a.uni
+	if scut.Ulev(uni.Dat["_user"]) < 300 {
+		return fmt.Errorf("Only an admin can migrate comments.")
+	}
+	moved, err := content_model.MigrateComments(uni.Db)
+	uni.Dat["_cont"] = map[string]interface{}{"moved": moved}
+	return err
 }
 
 func (a *A) PullTags() error {
@@ -300,26 +321,67 @@ func (a *A) DeleteTag() error {
 		return fmt.Errorf("Only an admin can delete a tag.")
 	}
 	tag_id := uni.Req.Form["tag_id"][0]
-	return content_model.DeleteTag(uni.Db, tag_id)
+	return content_model.DeleteTag(uni.Db, uni.Opt, tag_id)
+}
+
+func (a *A) SetTagParent() error {
+	uni := a.uni
+	if scut.Ulev(uni.Dat["_user"]) < 300 {
+		return fmt.Errorf("Only an admin can move a tag.")
+	}
+	tag_id := uni.Req.Form["tag_id"][0]
+	var parent_id string
+	if par, has := uni.Req.Form["parent_id"]; has {
+		parent_id = par[0]
+	}
+	return content_model.SetTagParent(uni.Db, uni.Opt, tag_id, parent_id)
+}
+
+func (a *A) RenameTag() error {
+	uni := a.uni
+	if scut.Ulev(uni.Dat["_user"]) < 300 {
+		return fmt.Errorf("Only an admin can rename a tag.")
+	}
+	tag_id := uni.Req.Form["tag_id"][0]
+	name := uni.Req.Form["name"][0]
+	return content_model.RenameTag(uni.Db, uni.Opt, tag_id, name)
+}
+
+func (a *A) MergeTags() error {
+	uni := a.uni
+	if scut.Ulev(uni.Dat["_user"]) < 300 {
+		return fmt.Errorf("Only an admin can merge tags.")
+	}
+	tag_id := uni.Req.Form["tag_id"][0]
+	into_id := uni.Req.Form["into_id"][0]
+	return content_model.MergeTags(uni.Db, uni.Opt, tag_id, into_id)
 }
 
 func (a *A) SaveTypeConfig() error {
 	uni := a.uni
-	// id := scut.CreateOptCopy(uni.Db)
-	return fmt.Errorf(not_impl)
-	return content_model.SaveTypeConfig(uni.Db, uni.Req.Form)
+	return content_model.SaveTypeConfig(uni.Db, uni.Opt, uni.Req.Form)
+}
+
+func (a *A) NewType() error {
+	uni := a.uni
+	return content_model.NewType(uni.Db, uni.Opt, uni.Req.Form)
+}
+
+// Only types without contents can be deleted.
+func (a *A) DeleteType() error {
+	uni := a.uni
+	return content_model.DeleteType(uni.Db, uni.Opt, uni.Req.Form)
 }
 
 // TODO: Ugly name.
 func (a *A) SavePersonalTypeConfig() error {
 	uni := a.uni
-	return fmt.Errorf(not_impl) // Temp.
 	user_id_i, has := jsonp.Get(uni.Dat, "_user._id")
 	if !has {
 		return fmt.Errorf("Can't find user id.")
 	}
 	user_id := user_id_i.(bson.ObjectId)
-	return content_model.SavePersonalTypeConfig(uni.Db, uni.Req.Form, user_id)
+	return content_model.SavePersonalTypeConfig(uni.Db, uni.Opt, uni.Req.Form, user_id)
 }
 
 type A struct {
@@ -10,22 +10,25 @@ import (
 	"github.com/opesun/hypecms/modules/content/model"
 	"github.com/opesun/hypecms/modules/display/model"
 	"github.com/opesun/jsonp"
+	"github.com/opesun/numcon"
 	"github.com/opesun/resolver"
 	"github.com/opesun/routep"
 	"labix.org/v2/mgo/bson"
+	"sort"
 	"strings"
 )
 
 type m map[string]interface{}
 
+// Lists the contents having the given tag or any of its descendant tags.
 func (h *H) tagView(urimap map[string]string) (error, bool) {
 	uni := h.uni
 	fieldname := "slug" // This should not be hardcoded.
 	specific := len(urimap) == 2
 	var search_value string
-	var children_query map[string]interface{}
+	q := map[string]interface{}{}
 	if specific {
-		children_query["type"] = urimap["first"]
+		q["type"] = urimap["first"]
 		search_value = urimap["second"]
 	} else {
 		search_value = urimap["first"]
@@ -34,6 +37,12 @@ func (h *H) tagView(urimap map[string]string) (error, bool) {
 	if err != nil {
 		return nil, false
 	}
+	tag_id := tag["_id"].(bson.ObjectId)
+	tag_ids, err := content_model.TagAndDescendants(uni.Db, tag_id)
+	if err != nil {
+		return err, false
+	}
+	q["_tags"] = map[string]interface{}{"$in": tag_ids}
 	pnq := uni.P + "?" + uni.Req.URL.RawQuery
 	query := map[string]interface{}{
 		"ex": map[string]interface{}{
@@ -41,15 +50,18 @@ func (h *H) tagView(urimap map[string]string) (error, bool) {
 		},
 		"so": "-created",
 		"c":  "contents",
-		"q": map[string]interface{}{
-			"_tags": tag["_id"],
-		},
-		"p": "page",
-		"l": 20,
+		"q":  q,
+		"p":  "page",
+		"l":  20,
 	}
 	cl := display_model.RunQuery(uni.Db, "content_list", query, uni.Req.Form, pnq)
 	uni.Dat["content_list"] = cl["content_list"]
 	uni.Dat["content_list_navi"] = cl["content_list_navi"]
+	resolver.ResolveOne(uni.Db, tag, nil) // Ancestors for breadcrumbs.
+	uni.Dat["tag"] = tag
+	var children []interface{}
+	uni.Db.C(content_model.Tag_cname).Find(m{content_model.Parent_fieldname: tag_id}).Sort("name").All(&children)
+	uni.Dat["tag_children"] = basic.Convert(children)
 	uni.Dat["_points"] = []string{"tag"}
 	return nil, true
 }
@@ -101,39 +113,155 @@ func (h *H) contentView(content_map map[string]string) (error, bool) {
 	if !found {
 		return nil, false
 	}
+	return h.showContent(content), true
+}
+
+// Displays a page found by its full path, eg. /about/team/joe.
+func (h *H) pageView() (error, bool) {
+	uni := h.uni
+	content, found := content_model.FindByPath(uni.Db, strings.Trim(uni.P, "/"))
+	if !found {
+		return nil, false
+	}
+	return h.showContent(content), true
+}
+
+func (h *H) userLangs() []string {
+	langs, _ := jsonp.Get(h.uni.Dat, "_user.languages")
+	switch l := langs.(type) {
+	case []string:
+		return l
+	case []interface{}:
+		return jsonp.ToStringSlice(l)
+	}
+	return nil
+}
+
+// Swaps the content to the translation matching the languages of the user, unless a language is explicitly asked for with ?lang=xx.
+// Sets the alternate language links too.
+func (h *H) translate(content map[string]interface{}) (map[string]interface{}, error) {
+	uni := h.uni
+	variants, err := content_model.Translations(uni.Db, content)
+	if err != nil {
+		return nil, err
+	}
+	default_lang := content_model.Languages(uni.Opt)[0]
+	langs := h.userLangs()
+	if l, has := uni.Req.Form["lang"]; has && len(l[0]) > 0 {
+		langs = []string{l[0]}
+	}
+	content = content_model.PickTranslation(variants, content, langs, default_lang)
+	uni.Dat["translations"] = content_model.AlternateLinks(variants, content, default_lang)
+	return content, nil
+}
+
+func (h *H) showContent(content map[string]interface{}) error {
+	uni := h.uni
+	content, err := h.translate(content)
+	if err != nil {
+		return err
+	}
 	dont_query := map[string]interface{}{"password": 0}
 	resolver.ResolveOne(uni.Db, content, dont_query)
+	err = content_model.ReverseLookups(uni.Db, uni.Opt, content, 50)
+	if err != nil {
+		return err
+	}
+	if _, is_page := content[content_model.Path_fieldname]; is_page {
+		uni.Dat["breadcrumbs"] = content_model.Breadcrumbs(uni.Db, content)
+	}
+	h.comments(content)
+	uni.Dat["seo"] = content_model.Seo(uni.Opt, uni.Req.Host, content)
+	if typ, ok := content["type"].(string); ok {
+		type_opt, _ := jsonp.GetM(uni.Opt, "Modules.content.types."+typ)
+		uni.Dat["formats"] = content_model.FieldFormats(type_opt)
+	}
 	uni.Dat["_points"] = []string{"content"}
 	uni.Dat["content"] = content
-	return nil, true
+	return nil
+}
+
+// Loads one page of the approved comments of a content.
+// The page size can be set per type with "comments_per_page".
+func (h *H) comments(content map[string]interface{}) {
+	uni := h.uni
+	content_id, ok := content["_id"].(bson.ObjectId)
+	if !ok {
+		return
+	}
+	limit := 50
+	typ, _ := content["type"].(string)
+	if l, has := jsonp.Get(uni.Opt, "Modules.content.types."+typ+".comments_per_page"); has {
+		limit = numcon.IntP(l)
+	}
+	pnq := uni.P + "?" + uni.Req.URL.RawQuery
+	query := content_model.CommentsQuery(content_id, limit)
+	cl := display_model.RunQuery(uni.Db, "comments", query, uni.Req.Form, pnq)
+	content["comments"] = cl["comments"]
+	uni.Dat["comments_navi"] = cl["comments_navi"]
+	comment_rule, _ := jsonp.GetM(uni.Opt, "Modules.content.types."+typ+".comment_rules")
+	uni.Dat["comments_html"] = content_model.RendersHtml(comment_rule, "comment_content")
 }
 
 func (h *H) contentSearch() error {
 	uni := h.uni
-	q := map[string]interface{}{}
 	search_sl, has := uni.Req.Form["search"]
 	var search_term string
 	if has && len(search_sl[0]) > 0 {
 		search_term = search_sl[0]
-		q["$and"] = content_model.GenerateQuery(search_term)
 		uni.Dat["search"] = search_sl[0]
 	}
-	query := map[string]interface{}{
-		"ex": map[string]interface{}{
-		"content": 300,
-		},
-		"so": "-created",
-		"c":  "contents",
-		"q":  q,
-		"p":  "page",
-		"l":  20,
-	}
-	pnq := uni.P + "?" + uni.Req.URL.RawQuery
-	cl := display_model.RunQuery(uni.Db, "content_list", query, uni.Req.Form, pnq)
-	uni.Dat["content_list"] = cl["content_list"]
 	uni.Dat["search_term"] = search_term
-	uni.Dat["content_list_navi"] = cl["content_list_navi"]
 	uni.Dat["_points"] = []string{"content-search"}
+	filter, err := content_model.FacetFilter(uni.Req.Form)
+	if err != nil {
+		return err
+	}
+	var facets content_model.Facets
+	if len(search_term) == 0 {
+		query := map[string]interface{}{
+			"ex": map[string]interface{}{
+				"content": 300,
+			},
+			"so": "-created",
+			"c":  "contents",
+			"q":  filter,
+			"p":  "page",
+			"l":  20,
+		}
+		pnq := uni.P + "?" + uni.Req.URL.RawQuery
+		cl := display_model.RunQuery(uni.Db, "content_list", query, uni.Req.Form, pnq)
+		uni.Dat["content_list"] = cl["content_list"]
+		uni.Dat["content_list_navi"] = cl["content_list_navi"]
+		facets, err = content_model.CountFacets(uni.Db, content_model.Cname, filter)
+	} else {
+		limit := 20
+		pnq := uni.P + "?" + uni.Req.URL.RawQuery
+		navi := display_model.Paging(0, "page", uni.Req.Form, pnq, limit) // Only to get the skip, the count is not known yet.
+		sq := &content_model.SearchQuery{
+			Text:   search_term,
+			Filter: filter,
+			Skip:   navi.Skip,
+			Limit:  limit,
+		}
+		var res []interface{}
+		var count int
+		res, count, err = content_model.Search(uni.Db, uni.Opt, sq)
+		if err != nil {
+			return err
+		}
+		resolver.ResolveAll(uni.Db, res, map[string]interface{}{"password": 0})
+		// Shown when the match is not in the text, eg. only in the tags, and there is no snippet.
+		display_model.CreateExcerpts(res, map[string]interface{}{"content": 300})
+		uni.Dat["content_list"] = res
+		uni.Dat["content_list_navi"] = display_model.Paging(count, "page", uni.Req.Form, pnq, limit)
+		facets, err = content_model.SearchFacets(uni.Db, uni.Opt, sq)
+	}
+	if err != nil {
+		return err
+	}
+	facets.SetUrls(uni.P, uni.Req.URL.Query())
+	uni.Dat["content_list_facets"] = facets
 	return nil
 }
 
@@ -158,6 +286,10 @@ func (h *H) Front() (bool, error) {
 	if content_search_err == nil {
 		return true, h.contentSearch()
 	}
+	err, hijack := h.pageView()
+	if err != nil || hijack {
+		return true, err
+	}
 	content_map, content_err := routep.Comp("/{slug}", uni.P)
 	if content_err == nil && len(content_map["slug"]) > 0 {
 		err, hijack := h.contentView(content_map)
@@ -194,7 +326,17 @@ func (v *V) Index() error {
 	for i, _ := range types {
 		visible_types = append(visible_types, i)
 	}
-	q := m{"type": m{"$in": visible_types}}
+	q, err := content_model.FacetFilter(uni.Req.Form)
+	if err != nil {
+		return err
+	}
+	if typ, has := q["type"]; has {
+		if _, visible := types[typ.(string)]; !visible {
+			return fmt.Errorf("Unkown content type %v.", typ)
+		}
+	} else {
+		q["type"] = m{"$in": visible_types}
+	}
 	search_sl, has := uni.Req.Form["search"]
 	if has && len(search_sl[0]) > 0 {
 		q["$and"] = content_model.GenerateQuery(search_sl[0])
@@ -204,6 +346,12 @@ func (v *V) Index() error {
 	var res []interface{}
 	uni.Db.C("contents").Find(q).Sort("-created").Skip(paging_inf.Skip).Limit(10).All(&res)
 	uni.Dat["paging"] = paging_inf
+	facets, err := content_model.CountFacets(uni.Db, "contents", q)
+	if err != nil {
+		return err
+	}
+	facets.SetUrls(uni.P, uni.Req.URL.Query())
+	uni.Dat["facets"] = facets
 	res = basic.Convert(res).([]interface{})
 	content_model.HaveUpToDateDrafts(uni.Db, res)
 	uni.Dat["latest"] = res
@@ -215,7 +363,9 @@ func (v *V) Index() error {
 func (v *V) Tags() error {
 	uni := v.uni
 	var res []interface{}
-	uni.Db.C("tags").Find(nil).All(&res)
+	uni.Db.C("tags").Find(nil).Sort("name").All(&res)
+	res = basic.Convert(res).([]interface{})
+	resolver.ResolveAll(uni.Db, res, map[string]interface{}{"name": 1, "slug": 1})
 	uni.Dat["latest"] = res
 	uni.Dat["_points"] = []string{"content/tags"}
 	return nil
@@ -246,7 +396,7 @@ func (v *V) Comments() error {
 	uni := v.uni
 	query := map[string]interface{}{
 		"so": "-created",
-		"c":  "comments",
+		"c":  content_model.Comment_cname,
 		"q": map[string]interface{}{},
 		"p": "page",
 		"l": 20,
@@ -260,16 +410,43 @@ func (v *V) Comments() error {
 }
 
 // Both everyone and personal.
+func indentJSON(i interface{}) string {
+	if i == nil {
+		return ""
+	}
+	marsh, err := json.MarshalIndent(i, "", "    ")
+	if err != nil {
+		return ""
+	}
+	return string(marsh)
+}
+
 func (v *V) TypeConfig() error {
 	uni := v.uni
 	typ := uni.Req.Form["type"][0]
-	op, ok := jsonp.Get(uni.Opt, "Modules.content.types."+typ)
+	op, ok := jsonp.GetM(uni.Opt, "Modules.content.types."+typ)
 	if !ok {
 		return fmt.Errorf("Can not find content type " + typ + " in options.")
 	}
 	uni.Dat["type"] = typ
-	uni.Dat["type_options"], _ = json.MarshalIndent(op, "", "    ")
+	uni.Dat["type_options"] = indentJSON(op)
 	uni.Dat["op"] = op
+	// The JSON parts of the form.
+	for _, key := range []string{"rules", "fields", "comment_rules", "actions"} {
+		uni.Dat[key] = indentJSON(op[key])
+	}
+	nvf := []string{}
+	if nv, ok := op["non_versioned_fields"].(map[string]interface{}); ok {
+		for i, _ := range nv {
+			nvf = append(nvf, i)
+		}
+	}
+	sort.Strings(nvf)
+	uni.Dat["non_versioned_fields"] = strings.Join(nvf, ", ")
+	uni.Dat["queries"] = indentJSON(content_model.TypeQueries(uni.Opt, typ))
+	count, _ := uni.Db.C(content_model.Cname).Find(m{"type": typ}).Count()
+	uni.Dat["content_count"] = count
+	uni.Dat["seo_enabled"] = content_model.HasSeo(op)
 	user_type_op, _ := jsonp.Get(uni.Dat["_user"], "content_options."+typ)
 	uni.Dat["user_type_op"] = user_type_op
 	return nil
@@ -307,12 +484,75 @@ func (v *V) editContent(typ, id string) (interface{}, error) {
 			return nil, err
 		}
 		uni.Dat["timeline"] = timeline
+		uni.Ev.Trigger("contents.editForm", bson.ObjectIdHex(id))
+		err = v.translationsOf(indb.(map[string]interface{}))
+		if err != nil {
+			return nil, err
+		}
 	} else {
 		uni.Dat["op"] = "insert"
+		uni.Dat["languages"] = content_model.Languages(uni.Opt)
+		if orig, has := uni.Req.Form["translation_of"]; has && len(orig[0]) > 0 {
+			return v.newTranslation(orig[0])
+		}
 	}
 	return context.Convert(indb), nil
 }
 
+// Lists the existing translations of a content and the languages it is not translated to yet.
+func (v *V) translationsOf(content map[string]interface{}) error {
+	uni := v.uni
+	langs := content_model.Languages(uni.Opt)
+	variants, err := content_model.Translations(uni.Db, content)
+	if err != nil {
+		return err
+	}
+	translated := map[string]bool{}
+	translations := []interface{}{}
+	for _, t := range variants {
+		l, _ := t[content_model.Lang_fieldname].(string)
+		if len(l) == 0 {
+			l = langs[0]
+		}
+		translated[l] = true
+		if t["_id"] != content["_id"] {
+			translations = append(translations, t)
+		}
+	}
+	missing := []string{}
+	for _, l := range langs {
+		if !translated[l] {
+			missing = append(missing, l)
+		}
+	}
+	uni.Dat["languages"] = langs
+	uni.Dat["lang"] = content[content_model.Lang_fieldname]
+	uni.Dat["translations"] = translations
+	uni.Dat["missing_languages"] = missing
+	return nil
+}
+
+// Prefills the insert form with the fields of the original content, so the editor only has to translate them.
+func (v *V) newTranslation(orig_id string) (interface{}, error) {
+	uni := v.uni
+	var orig interface{}
+	err := uni.Db.C(content_model.Cname).Find(m{"_id": patterns.ToIdWithCare(orig_id)}).One(&orig)
+	if err != nil {
+		return nil, fmt.Errorf("Can't find the content to be translated.")
+	}
+	orig = basic.Convert(orig)
+	om := orig.(map[string]interface{})
+	for _, v := range []string{"_id", "slug", content_model.Path_fieldname, content_model.Lang_fieldname, content_model.Translation_group_fieldname} {
+		delete(om, v)
+	}
+	resolver.ResolveOne(uni.Db, orig, nil)
+	uni.Dat["translation_of"] = orig_id
+	if l, has := uni.Req.Form["lang"]; has {
+		uni.Dat["lang"] = l[0]
+	}
+	return context.Convert(orig), nil
+}
+
 func (v *V) editDraft(typ, id string) (interface{}, error) {
 	hasid := len(id) > 0
 	uni := v.uni
@@ -341,6 +581,7 @@ func (v *V) editDraft(typ, id string) (interface{}, error) {
 			return nil, err
 		}
 		uni.Dat["timeline"] = timeline
+		uni.Ev.Trigger("contents.editForm", bson.ObjectIdHex(id))
 		uni.Dat["draft"] = built
 		return d, nil
 	}
@@ -392,9 +633,13 @@ func (v *V) Edit() error {
 	uni := v.uni
 	typ := uni.Req.Form["type"][0]
 	rtyp := realType(typ)
-	rules, hasr := jsonp.GetM(uni.Opt, "Modules.content.types."+rtyp+".rules")
-	if !hasr {
-		return fmt.Errorf("Can't find rules of " + rtyp)
+	type_opt, has := jsonp.GetM(uni.Opt, "Modules.content.types."+rtyp)
+	if !has {
+		return fmt.Errorf("Can't find content type " + rtyp)
+	}
+	rules, typed, err := content_model.TypeRules(type_opt)
+	if err != nil {
+		return err
 	}
 	uni.Dat["content_type"] = rtyp
 	uni.Dat["type"] = rtyp
@@ -404,7 +649,6 @@ func (v *V) Edit() error {
 	}
 	hasid := len(id) > 0 // Corrigate routep.Comp because it sets a map key with an empty value...
 	var field_dat interface{}
-	var err error
 	subt := subType(typ)
 	switch subt {
 	case "content":
@@ -422,10 +666,17 @@ func (v *V) Edit() error {
 	if err != nil {
 		return err
 	}
-	fields, err := scut.RulesToFields(rules, field_dat)
+	// Typed fields come first in their defined order, the keys only present in the old style rules after them.
+	dat, _ := field_dat.(map[string]interface{})
+	fields := content_model.FieldsToForm(typed, dat)
+	for _, v := range typed {
+		delete(rules, v.Key())
+	}
+	untyped, err := scut.RulesToFields(rules, field_dat)
 	if err != nil {
 		return err
 	}
+	fields = append(fields, untyped...)
 	uni.Dat["fields"] = fields
 	return nil
 }
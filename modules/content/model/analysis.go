@@ -0,0 +1,139 @@
+package content_model
+
+// Text analysis for the search: tokenizing, stop words and stemming, per language.
+// The stemmers are intentionally light, suffix stripping ones. They are not linguistically perfect, but
+// since the same stemmer runs on both the indexed text and the search terms it is good enough for matching.
+
+import (
+	"github.com/opesun/slugify"
+	"strings"
+	"unicode"
+)
+
+type Analyzer struct {
+	StopWords map[string]struct{}
+	Stem      func(string) string
+}
+
+var analyzers = map[string]*Analyzer{
+	"en": &Analyzer{wordSet(en_stop), stemEn},
+	"de": &Analyzer{wordSet(de_stop), stemDe},
+	"hu": &Analyzer{wordSet(hu_stop), stemHu},
+}
+
+// Other modules can register analyzers for additional languages.
+func RegisterAnalyzer(lang string, a *Analyzer) {
+	analyzers[lang] = a
+}
+
+// Falls back to english if the language is unknown.
+func AnalyzerOf(lang string) *Analyzer {
+	if a, has := analyzers[lang]; has {
+		return a
+	}
+	return analyzers["en"]
+}
+
+func wordSet(s string) map[string]struct{} {
+	ret := map[string]struct{}{}
+	for _, v := range Tokenize(s) {
+		ret[v] = struct{}{}
+	}
+	return ret
+}
+
+// Splits text at every non letter, non digit character, then lowercases and slugifies (strips accents) the words.
+func Tokenize(s string) []string {
+	words := strings.FieldsFunc(s, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	ret := []string{}
+	for _, v := range words {
+		w := strings.Trim(slugify.S(strings.ToLower(v)), "-")
+		if len(w) > 0 {
+			ret = append(ret, w)
+		}
+	}
+	return ret
+}
+
+// Tokenizes, drops stop words and too short words, stems the rest.
+func (a *Analyzer) Terms(s string) []string {
+	ret := []string{}
+	for _, v := range Tokenize(s) {
+		if t, ok := a.Term(v); ok {
+			ret = append(ret, t)
+		}
+	}
+	return ret
+}
+
+// Analyzes a single, already tokenized word. Returns false if the word should not be indexed.
+func (a *Analyzer) Term(word string) (string, bool) {
+	if len(word) < 2 {
+		return "", false
+	}
+	if _, stop := a.StopWords[word]; stop {
+		return "", false
+	}
+	if a.Stem == nil {
+		return word, true
+	}
+	return a.Stem(word), true
+}
+
+// Strips the first matching suffix from the list (longest ones should come first), while leaving at least min_stem characters.
+func stripSuffix(w string, suffixes []string, min_stem int) string {
+	for _, v := range suffixes {
+		if strings.HasSuffix(w, v) && len(w)-len(v) >= min_stem {
+			return w[:len(w)-len(v)]
+		}
+	}
+	return w
+}
+
+var en_suffixes = []string{"fulness", "iveness", "ations", "ation", "ments", "ment", "ness", "ings", "ing", "edly", "ies", "ied", "ers", "ly", "ed", "er", "es", "s"}
+
+func stemEn(w string) string {
+	if strings.HasSuffix(w, "ss") {
+		return w
+	}
+	st := stripSuffix(w, en_suffixes, 3)
+	if st != w && (strings.HasSuffix(w, "ies") || strings.HasSuffix(w, "ied")) {
+		return st + "y"
+	}
+	// "running" -> "runn" -> "run"
+	if n := len(st); n > 3 && st[n-1] == st[n-2] && !strings.ContainsRune("lsz", rune(st[n-1])) {
+		return st[:n-1]
+	}
+	return st
+}
+
+var de_suffixes = []string{"ungen", "heiten", "keiten", "ung", "heit", "keit", "lich", "isch", "ern", "em", "en", "er", "es", "e", "s", "n"}
+
+func stemDe(w string) string {
+	return stripSuffix(w, de_suffixes, 3)
+}
+
+// Accents are already stripped by the tokenizer, so "ból" is "bol" here.
+var hu_suffixes = []string{"ban", "ben", "bol", "rol", "tol", "nak", "nek", "val", "vel", "hoz", "hez", "kent", "ert", "ig", "ba", "be", "ra", "re", "ok", "ek", "ak", "at", "et", "ot", "k", "t"}
+
+func stemHu(w string) string {
+	return stripSuffix(w, hu_suffixes, 3)
+}
+
+const en_stop = `a about above after again against all am an and any are as at be because been before being below between both but by
+can could did do does doing down during each few for from further had has have having he her here hers herself him himself his how
+i if in into is it its itself just me more most my myself no nor not now of off on once only or other our ours ourselves out over own
+same she should so some such than that the their theirs them themselves then there these they this those through to too under until
+up very was we were what when where which while who whom why will with would you your yours yourself yourselves`
+
+const de_stop = `aber alle allem allen aller alles als also am an ander andere anderem anderen anderer anderes auch auf aus bei bin bis bist da
+damit dann das dass dem den denn der des dich die dies diese diesem diesen dieser dieses dir doch dort du durch ein eine einem einen
+einer eines er es etwas euch euer eure für hat hatte hier hin hinter ich ihm ihn ihr ihre im in ist ja jede jedem jeden jeder jedes
+kein keine man mein meine mich mir mit nach nicht noch nun nur ob oder ohne sehr sein seine sich sie sind so über um und uns unser
+unter vom von vor war waren was weil wenn wer wie wir wird wo zu zum zur`
+
+const hu_stop = `a az egy es is hogy nem de meg mar csak mint ha vagy volt van lesz lett ez azt ezt itt ott igen mert mig majd most pedig
+sem sok kell fel le be ki el ra ilyen olyan ami aki amely amelyek minden mindig nagyon meg mi te o mi ti ok en ugy igy akkor azonban
+illetve valamint szerint utan elott kozott alatt felett nelkul`
@@ -10,6 +10,10 @@ import (
 	"time"
 )
 
+// Every comment lives in its own document in this collection, the content only holds the comment_count.
+// Comments awaiting moderation are in the same collection with "in_moderation" set to true.
+const Comment_cname = "comments"
+
 // Only called when doing update or delete.
 // At inserting, user.OkayToDoAction is sufficient.
 // This needs additional action: you can only update or delete a given comment only if it's yours (under a certain level).
@@ -39,41 +43,18 @@ func CanModifyComment(db *mgo.Database, inp map[string][]string, correction_leve
 	return nil
 }
 
-// To be able to list all comments chronologically we insert it to a virtual collection named "comments", where there will be only a link.
-// "_id" equals to "comment_id" in the content comment array.
-func insertToVirtual(db *mgo.Database, content_id, comment_id, author bson.ObjectId, typ string, in_moderation bool) error {
-	comment_link := map[string]interface{}{
-		"_contents_parent": content_id,
-		"_users_author":    author,
-		"created":          time.Now().Unix(),
-		"content_type":		typ,
-		"in_moderation":	in_moderation,
-	}
-	if in_moderation {
-		comment_link["_comments_moderation"] = comment_id
-	} else {
-		comment_link["comment_id"] = comment_id
-	}
-	return db.C("comments").Insert(comment_link)
-}
-
-// Places a comment into its final place - the comment array field of a given content.
-func insertToFinal(db *mgo.Database, comment map[string]interface{}, comment_id, content_id bson.ObjectId) error {
-	comment["comment_id"] = comment_id
-	q := bson.M{"_id": content_id}
-	upd := bson.M{
-		"$inc": bson.M{
-			"comment_count": 1,
-		},
-		"$push": bson.M{
-			"comments": comment,
+func incCommentCount(db *mgo.Database, content_id bson.ObjectId, by int) error {
+	q := m{"_id": content_id}
+	upd := m{
+		"$inc": m{
+			"comment_count": by,
 		},
 	}
-	return db.C("contents").Update(q, upd)
+	return db.C(Cname).Update(q, upd)
 }
 
 // MoveToFinal with extract.
-func MoveToFinalWE(db *mgo.Database, inp map[string][]string) error {
+func MoveToFinalWE(db *mgo.Database, ev ifaces.Event, inp map[string][]string) error {
 	r := map[string]interface{}{
 		"comment_id": "must",
 	}
@@ -82,44 +63,33 @@ func MoveToFinalWE(db *mgo.Database, inp map[string][]string) error {
 		return err
 	}
 	comment_id := basic.ToIdWithCare(dat["comment_id"])
-	return MoveToFinal(db, comment_id)
+	return MoveToFinal(db, ev, comment_id)
 }
 
 // Moves a comment to its final destination (into the valid comments) from moderation queue.
-func MoveToFinal(db *mgo.Database, comment_id bson.ObjectId) error {
+func MoveToFinal(db *mgo.Database, ev ifaces.Event, comment_id bson.ObjectId) error {
 	var comm interface{}
-	q := m{"_id": comment_id}
-	err := db.C("comments_moderation").Find(q).One(&comm)
+	q := m{"_id": comment_id, "in_moderation": true}
+	err := db.C(Comment_cname).Find(q).One(&comm)
 	if err != nil {
 		return err
 	}
 	comment := basic.Convert(comm).(map[string]interface{})
-	comment["comment_id"] = comment["_id"]
-	delete(comment, "comment_id")
-	content_id := comment["_contents_parent"].(bson.ObjectId)
-	q2 := m{"_id": content_id}
-	upd2 := m{
-		"$inc": m{
-			"comment_count": 1,
-		},
-		"$push": m{
-			"comments": comment,
-		},
-	}
-	err = db.C("contents").Update(q2, upd2)
-	if err != nil {
-		return err
-	}
 	upd := m{
 		"$set": m{
 			"in_moderation": false,
 		},
 	}
-	err = db.C("comments").Update(q, upd)
+	err = db.C(Comment_cname).Update(q, upd)
+	if err != nil {
+		return err
+	}
+	err = incCommentCount(db, comment["_contents_parent"].(bson.ObjectId), 1)
 	if err != nil {
 		return err
 	}
-	return db.C("comments_moderation").Remove(q)
+	ev.Trigger("comments.final", comment)
+	return nil
 }
 
 // Maybe we should just delete the comment in this case?
@@ -128,68 +98,77 @@ func MoveToModeration(db *mgo.Database, content_id, comment_id bson.ObjectId) er
 	return fmt.Errorf("Not implemented yet.")
 }
 
-// Puts comment coming from UI into moderation queue.
-func insertModeration(db *mgo.Database, comment map[string]interface{}, comment_id, content_id bson.ObjectId, typ string) error {
-	comment["_id"] = comment_id
-	comment["_contents_parent"] = content_id
-	comment["content_type"] = typ
-	return db.C("comments_moderation").Insert(comment)
-}
-
 // Apart from rule, there is one mandatory field which must come from the UI: "content_id"
 // moderate_first should be read as "moderate first if it is a valid, spam protection passed comment"
 // Spam protection happens outside of this anyway.
 func InsertComment(db *mgo.Database, ev ifaces.Event, rule map[string]interface{}, inp map[string][]string, user_id bson.ObjectId, typ string, moderate_first bool) error {
+	pols, err := ruleSanitizers(rule)
+	if err != nil {
+		return err
+	}
+	rule = stripSanitize(rule)
 	dat, err := extract.New(rule).Extract(inp)
 	if err != nil {
 		return err
 	}
-	dat["type"] = typ
+	applySanitizers(pols, dat)
 	basic.DateAndAuthor(rule, dat, user_id, false)
 	ids, err := basic.ExtractIds(inp, []string{"content_id"})
 	if err != nil {
 		return err
 	}
 	content_id := bson.ObjectIdHex(ids[0])
-	comment_id := bson.NewObjectId()
-	if moderate_first {
-		err = insertModeration(db, dat, comment_id, content_id, typ)
-	} else {
-		err = insertToFinal(db, dat, comment_id, content_id)
+	dat["_id"] = bson.NewObjectId()
+	dat["_contents_parent"] = content_id
+	dat["content_type"] = typ
+	dat["in_moderation"] = moderate_first
+	if _, has := dat[basic.Created]; !has {
+		dat[basic.Created] = time.Now().Unix()
+	}
+	err = db.C(Comment_cname).Insert(dat)
+	if err != nil {
+		return err
 	}
-	// This will be made optional, a facebook style app does not need it, only a bloglike site.
-	if err == nil {
-		err = insertToVirtual(db, content_id, comment_id, user_id, typ, moderate_first)
+	if !moderate_first {
+		err = incCommentCount(db, content_id, 1)
+		if err != nil {
+			return err
+		}
 	}
-	return err
+	ev.Trigger("comments.insert", dat)
+	return nil
 }
 
 // Apart from rule, there are two mandatory field which must come from the UI: "content_id" and "comment_id"
 func UpdateComment(db *mgo.Database, ev ifaces.Event, rule map[string]interface{}, inp map[string][]string, user_id bson.ObjectId) error {
+	pols, err := ruleSanitizers(rule)
+	if err != nil {
+		return err
+	}
+	rule = stripSanitize(rule)
 	dat, err := extract.New(rule).Extract(inp)
 	if err != nil {
 		return err
 	}
+	applySanitizers(pols, dat)
 	basic.DateAndAuthor(rule, dat, user_id, true)
 	ids, err := basic.ExtractIds(inp, []string{"content_id", "comment_id"})
 	if err != nil {
 		return err
 	}
-	comment_id := bson.ObjectIdHex(ids[1])
-	q := bson.M{
-		"_id": bson.ObjectIdHex(ids[0]),
-		"comments.comment_id": comment_id,
+	q := m{
+		"_id":              bson.ObjectIdHex(ids[1]),
+		"_contents_parent": bson.ObjectIdHex(ids[0]),
 	}
-	upd := bson.M{
-		"$set": bson.M{
-			"comments.$": dat,
-		},
+	upd := m{
+		"$set": dat,
 	}
-	err = db.C("contents").Update(q, upd)
+	err = db.C(Comment_cname).Update(q, upd)
 	if err != nil {
 		return err
 	}
-	return db.C("comments").Remove(m{"_id": comment_id})
+	ev.Trigger("comments.update", map[string]interface{}(q))
+	return nil
 }
 
 // Two mandatory fields must come from UI: "content_id" and "comment_id"
@@ -198,60 +177,160 @@ func DeleteComment(db *mgo.Database, ev ifaces.Event, inp map[string][]string, u
 	if err != nil {
 		return err
 	}
-	q := bson.M{
-		"_id": bson.ObjectIdHex(ids[0]),
-		"comments.comment_id": bson.ObjectIdHex(ids[1]),
+	comment, err := findComment(db, ids[0], ids[1])
+	if err != nil {
+		return err
 	}
-	upd := bson.M{
-		"$inc": bson.M{
-			"comment_count": -1,
-		},
-		"$pull": bson.M{
-			"comments": bson.M{
-				"comment_id": bson.ObjectIdHex(ids[1]),
-			},
+	err = db.C(Comment_cname).Remove(m{"_id": comment["_id"]})
+	if err != nil {
+		return err
+	}
+	if in_mod, _ := comment["in_moderation"].(bool); !in_mod {
+		err = incCommentCount(db, bson.ObjectIdHex(ids[0]), -1)
+		if err != nil {
+			return err
+		}
+	}
+	ev.Trigger("comments.delete", comment)
+	return nil
+}
+
+// Called when a content is deleted, its comments would be orphans otherwise.
+func deleteCommentsOf(db *mgo.Database, content_id bson.ObjectId) error {
+	_, err := db.C(Comment_cname).RemoveAll(m{"_contents_parent": content_id})
+	return err
+}
+
+// Returns a display query (see display_model.RunQueries) which lists the approved comments of a given content, oldest first.
+func CommentsQuery(content_id bson.ObjectId, limit int) map[string]interface{} {
+	return map[string]interface{}{
+		"c": Comment_cname,
+		"q": map[string]interface{}{
+			"_contents_parent": content_id,
+			"in_moderation":    false,
 		},
+		"so": "created",
+		"p":  "comments-page",
+		"l":  limit,
+		"r":  map[string]interface{}{"password": 0},
 	}
-	return db.C("contents").Update(q, upd)
 }
 
-func findComment(db *mgo.Database, content_id, comment_id string) (map[string]interface{}, error) {
-	var v interface{}
-	q := bson.M{
-		"_id": bson.ObjectIdHex(content_id),
-		//"comments.comment_id": bson.ObjectIdHex(comment_id),	
+func EnsureCommentIndexes(db *mgo.Database) error {
+	err := db.C(Comment_cname).EnsureIndex(mgo.Index{Key: []string{"_contents_parent", "in_moderation", "created"}})
+	if err != nil {
+		return err
 	}
-	find_err := db.C("contents").Find(q).One(&v)
-	if find_err != nil {
-		return nil, find_err
+	return db.C(Comment_cname).EnsureIndex(mgo.Index{Key: []string{"-created"}})
+}
+
+// Turns an embedded comment into a document of the comments collection, returns its id.
+// Comments inserted directly have a "comment_id", the ones approved from moderation kept their "_id" instead.
+func migratedComment(comment map[string]interface{}, content_id bson.ObjectId, content_type interface{}) bson.ObjectId {
+	comment_id, ok := comment["comment_id"].(bson.ObjectId)
+	if !ok {
+		comment_id, ok = comment["_id"].(bson.ObjectId)
 	}
-	if v == nil {
-		return nil, fmt.Errorf("Can't find content with id %v.", content_id)
+	if !ok {
+		comment_id = bson.NewObjectId()
 	}
-	v = basic.Convert(v)
-	comments_i, has := v.(map[string]interface{})["comments"]
-	if !has {
-		return nil, fmt.Errorf("No comments in given content.")
+	delete(comment, "comment_id")
+	delete(comment, "_id")
+	delete(comment, "type")
+	comment["_contents_parent"] = content_id
+	comment["content_type"] = content_type
+	comment["in_moderation"] = false
+	return comment_id
+}
+
+// Moves comments embedded into contents (and the ones waiting in the old comments_moderation collection) into the comments collection.
+// Safe to run more than once. Returns the number of comments moved.
+func MigrateComments(db *mgo.Database) (int, error) {
+	// Old style link documents, they pointed to the embedded comments or to comments_moderation.
+	old_links := m{
+		"$or": []interface{}{
+			m{"comment_id": m{"$exists": true}},
+			m{"_comments_moderation": m{"$exists": true}},
+		},
 	}
-	comments, ok := comments_i.([]interface{})
-	if !ok {
-		return nil, fmt.Errorf("comments member is not a slice in content %v", content_id)
+	_, err := db.C(Comment_cname).RemoveAll(old_links)
+	if err != nil {
+		return 0, err
+	}
+	moved := 0
+	touched := map[bson.ObjectId]struct{}{}
+	var content interface{}
+	iter := db.C(Cname).Find(m{"comments": m{"$exists": true}}).Select(m{"_id": 1, "type": 1, "comments": 1}).Iter()
+	for iter.Next(&content) {
+		con := basic.Convert(content).(map[string]interface{})
+		content_id := con["_id"].(bson.ObjectId)
+		comments, _ := con["comments"].([]interface{})
+		for _, v := range comments {
+			comment, ok := v.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			comment_id := migratedComment(comment, content_id, con["type"])
+			_, err = db.C(Comment_cname).UpsertId(comment_id, comment)
+			if err != nil {
+				return moved, err
+			}
+			moved++
+		}
+		touched[content_id] = struct{}{}
+	}
+	if err = iter.Err(); err != nil {
+		return moved, err
+	}
+	var mod_comm interface{}
+	iter = db.C("comments_moderation").Find(nil).Iter()
+	for iter.Next(&mod_comm) {
+		comment := basic.Convert(mod_comm).(map[string]interface{})
+		comment_id := comment["_id"].(bson.ObjectId)
+		delete(comment, "_id")
+		delete(comment, "type")
+		comment["in_moderation"] = true
+		_, err = db.C(Comment_cname).UpsertId(comment_id, comment)
+		if err != nil {
+			return moved, err
+		}
+		moved++
+	}
+	if err = iter.Err(); err != nil {
+		return moved, err
+	}
+	_, err = db.C("comments_moderation").RemoveAll(nil)
+	if err != nil {
+		return moved, err
 	}
-	// TODO: there must be a better way.
-	for _, v_i := range comments {
-		v, is_map := v_i.(map[string]interface{})
-		if !is_map {
-			continue
+	for content_id, _ := range touched {
+		count, err := db.C(Comment_cname).Find(m{"_contents_parent": content_id, "in_moderation": false}).Count()
+		if err != nil {
+			return moved, err
 		}
-		if val_i, has := v["comment_id"]; has {
-			if val_id, ok := val_i.(bson.ObjectId); ok {
-				if val_id.Hex() == comment_id {
-					return v, nil
-				}
-			}
+		upd := m{
+			"$set":   m{"comment_count": count},
+			"$unset": m{"comments": 1},
+		}
+		err = db.C(Cname).Update(m{"_id": content_id}, upd)
+		if err != nil {
+			return moved, err
 		}
 	}
-	return nil, fmt.Errorf("Comment not found.")
+	return moved, EnsureCommentIndexes(db)
+}
+
+func findComment(db *mgo.Database, content_id, comment_id string) (map[string]interface{}, error) {
+	var v interface{}
+	q := m{
+		"_id":              bson.ObjectIdHex(comment_id),
+		"_contents_parent": bson.ObjectIdHex(content_id),
+	}
+	err := db.C(Comment_cname).Find(q).One(&v)
+	if err != nil {
+		return nil, fmt.Errorf("Comment not found.")
+	}
+	return basic.Convert(v).(map[string]interface{}), nil
 }
 
 func findCommentAuthor(db *mgo.Database, content_id, comment_id string) (bson.ObjectId, error) {
@@ -259,9 +338,9 @@ func findCommentAuthor(db *mgo.Database, content_id, comment_id string) (bson.Ob
 	if err != nil {
 		return "", err
 	}
-	author, has := comment["created_by"]
+	author, has := comment[basic.Created_by]
 	if !has {
-		return "", fmt.Errorf("Given content has no author.")
+		return "", fmt.Errorf("Given comment has no author.")
 	}
 	return author.(bson.ObjectId), nil
 }
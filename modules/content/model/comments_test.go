@@ -0,0 +1,49 @@
+package content_model
+
+import (
+	"labix.org/v2/mgo/bson"
+	"testing"
+)
+
+func TestMigratedComment(t *testing.T) {
+	content_id := bson.NewObjectId()
+	// Inserted directly into the content.
+	direct_id := bson.NewObjectId()
+	direct := map[string]interface{}{"comment_id": direct_id, "type": "blog", "comment_content": "first"}
+	if id := migratedComment(direct, content_id, "blog"); id != direct_id {
+		t.Fatal(id)
+	}
+	// Approved from the moderation queue, kept its _id.
+	approved_id := bson.NewObjectId()
+	approved := map[string]interface{}{"_id": approved_id, "_contents_parent": content_id, "comment_content": "second"}
+	if id := migratedComment(approved, content_id, "blog"); id != approved_id {
+		t.Fatal(id)
+	}
+	for _, v := range []map[string]interface{}{direct, approved} {
+		for _, key := range []string{"_id", "comment_id", "type"} {
+			if _, has := v[key]; has {
+				t.Fatal(key, "should be removed:", v)
+			}
+		}
+		if v["_contents_parent"] != content_id || v["content_type"] != "blog" || v["in_moderation"] != false {
+			t.Fatal(v)
+		}
+	}
+	if id := migratedComment(map[string]interface{}{}, content_id, "blog"); !id.Valid() {
+		t.Fatal("Comments without an id should get a new one.")
+	}
+}
+
+func TestRendersHtml(t *testing.T) {
+	if RendersHtml(DefaultTypeOptions()["comment_rules"].(m), "comment_content") {
+		t.Fatal("Comments must be plain text by default.")
+	}
+	rule := map[string]interface{}{"comment_content": map[string]interface{}{"must": 1, "sanitize": true}}
+	if !RendersHtml(rule, "comment_content") {
+		t.Fatal("Comment rule opted in to HTML.")
+	}
+	rule["comment_content"] = map[string]interface{}{"sanitize": false}
+	if RendersHtml(rule, "comment_content") || RendersHtml(nil, "comment_content") {
+		t.Fatal("No policy, no HTML.")
+	}
+}
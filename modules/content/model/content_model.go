@@ -147,15 +147,12 @@ func filterTooShort(s []string, min_len int) []string {
 	return ret
 }
 
-// Very simple way of building a fulltext field - split it by spaces, then slug each string.
+// Very simple way of building a fulltext field - tokenize (see analysis.go), then drop the duplicates and the short words.
+// Used by the prefix searching of the admin listings, the real search goes trough the Indexer.
 func simpleFulltext(non_split []string) []string {
-	split := []string{}
-	for _, v := range non_split {
-		split = append(split, strings.Split(v, " ")...)
-	}
 	slugified := []string{}
-	for _, v := range split {
-		slugified = append(slugified, strings.Trim(slugify.S(v), ",.:;"))
+	for _, v := range non_split {
+		slugified = append(slugified, Tokenize(v)...)
 	}
 	slugified = filterDupes(slugified)
 	return filterTooShort(slugified, 3)
@@ -183,11 +180,6 @@ func saveFulltext(db *mgo.Database, id bson.ObjectId) error {
 	return db.C("contents").Update(m{"_id": id}, m{"$set": m{"fulltext": fulltext}})
 }
 
-// Mostly for in-development use. Iterates trough all contents in the contents collection and regenerates their fulltext field.
-func RegenerateFulltext(db *mgo.Database) error {
-	return fmt.Errorf(not_impl)
-}
-
 
 func GenerateKeywords(s string) []string {
 	split := strings.Split(s, " ")
@@ -224,15 +216,21 @@ func mergeMaps(a, b map[string]interface{}) {
 	}
 }
 
-func Insert(db *mgo.Database, ev ifaces.Event, rule map[string]interface{}, dat map[string][]string, user_id bson.ObjectId) (bson.ObjectId, error) {
-	return insert(db, ev, rule, dat, user_id, nil)
+// opt is the whole option document, the search index settings are read from it.
+// type_opt is the options of the content type ("Modules.content.types.<type>"), see TypeRules.
+func Insert(db *mgo.Database, ev ifaces.Event, opt, type_opt map[string]interface{}, dat map[string][]string, user_id bson.ObjectId) (bson.ObjectId, error) {
+	return insert(db, ev, opt, type_opt, dat, user_id, nil)
 }
 
-func InsertWithFix(db *mgo.Database, ev ifaces.Event, rule map[string]interface{}, dat map[string][]string, user_id bson.ObjectId, fixvals map[string]interface{}) (bson.ObjectId, error) {
-	return insert(db, ev, rule, dat, user_id, fixvals)
+func InsertWithFix(db *mgo.Database, ev ifaces.Event, opt, type_opt map[string]interface{}, dat map[string][]string, user_id bson.ObjectId, fixvals map[string]interface{}) (bson.ObjectId, error) {
+	return insert(db, ev, opt, type_opt, dat, user_id, fixvals)
 }
 
-func insert(db *mgo.Database, ev ifaces.Event, rule map[string]interface{}, dat map[string][]string, user_id bson.ObjectId, fixvals map[string]interface{}) (bson.ObjectId, error) {
+func insert(db *mgo.Database, ev ifaces.Event, opt, type_opt map[string]interface{}, dat map[string][]string, user_id bson.ObjectId, fixvals map[string]interface{}) (bson.ObjectId, error) {
+	rule, fields, err := TypeRules(type_opt)
+	if err != nil {
+		return "", err
+	}
 	// Could check for id here, alert if we found one.
 	rule["type"] = "must"
 	rule["draft_id"] = "must" // Can be draft, or version.
@@ -240,35 +238,68 @@ func insert(db *mgo.Database, ev ifaces.Event, rule map[string]interface{}, dat
 	if extr_err != nil {
 		return "", extr_err
 	}
+	old_rule, _ := type_opt["rules"].(map[string]interface{})
+	pols, err := ruleSanitizers(old_rule)
+	if err != nil {
+		return "", err
+	}
+	applySanitizers(pols, ins_dat)
 	typ := ins_dat["type"].(string)
+	err = ValidateFields(db, fields, dat, ins_dat)
+	if err != nil {
+		return "", err
+	}
+	err = checkCanonical(ins_dat)
+	if err != nil {
+		return "", err
+	}
+	err = setLanguage(db, "", dat, ins_dat)
+	if err != nil {
+		return "", err
+	}
 	basic.DateAndAuthor(rule, ins_dat, user_id, false)
 	_, has_tags := ins_dat[Tag_fieldname_displayed]
 	if has_tags {
 		addTags(db, ins_dat, "", "insert", typ)
 	}
 	basic.Slug(rule, ins_dat)
+	if IsTree(type_opt) {
+		err = setPath(db, "", ins_dat)
+		if err != nil {
+			return "", err
+		}
+	}
 	mergeMaps(ins_dat, fixvals)
-	err := basic.InudVersion(db, ev, ins_dat, "contents", "insert", "")
+	err = basic.InudVersion(db, ev, ins_dat, "contents", "insert", "")
 	if err != nil {
 		return "", err
 	}
 	ret_id := ins_dat["_id"].(bson.ObjectId)
+	err = closeGroup(db, ret_id)
+	if err != nil {
+		return "", err
+	}
 	_, has_fulltext := rule["fulltext"]
 	if has_fulltext {
 		saveFulltext(db, ret_id)
 	}
+	indexSaved(db, opt, ret_id)
 	return ret_id, nil
 }
 
-func Update(db *mgo.Database, ev ifaces.Event, rule map[string]interface{}, dat map[string][]string, user_id bson.ObjectId) error {
-	return update(db, ev, rule, dat, user_id, nil)
+func Update(db *mgo.Database, ev ifaces.Event, opt, type_opt map[string]interface{}, dat map[string][]string, user_id bson.ObjectId) error {
+	return update(db, ev, opt, type_opt, dat, user_id, nil)
 }
 
-func UpdateWithFix(db *mgo.Database, ev ifaces.Event, rule map[string]interface{}, dat map[string][]string, user_id bson.ObjectId, fixvals map[string]interface{}) error {
-	return update(db, ev, rule, dat, user_id, fixvals)
+func UpdateWithFix(db *mgo.Database, ev ifaces.Event, opt, type_opt map[string]interface{}, dat map[string][]string, user_id bson.ObjectId, fixvals map[string]interface{}) error {
+	return update(db, ev, opt, type_opt, dat, user_id, fixvals)
 }
 
-func update(db *mgo.Database, ev ifaces.Event, rule map[string]interface{}, dat map[string][]string, user_id bson.ObjectId, fixvals map[string]interface{}) error {
+func update(db *mgo.Database, ev ifaces.Event, opt, type_opt map[string]interface{}, dat map[string][]string, user_id bson.ObjectId, fixvals map[string]interface{}) error {
+	rule, fields, err := TypeRules(type_opt)
+	if err != nil {
+		return err
+	}
 	rule["id"] = "must"
 	rule["type"] = "must"
 	rule["draft_id"] = "must"
@@ -276,8 +307,30 @@ func update(db *mgo.Database, ev ifaces.Event, rule map[string]interface{}, dat
 	if extr_err != nil {
 		return extr_err
 	}
+	old_rule, _ := type_opt["rules"].(map[string]interface{})
+	pols, err := ruleSanitizers(old_rule)
+	if err != nil {
+		return err
+	}
+	applySanitizers(pols, upd_dat)
 	id := upd_dat["id"].(string)
 	typ := upd_dat["type"].(string)
+	err = ValidateFields(db, fields, dat, upd_dat)
+	if err != nil {
+		return err
+	}
+	err = checkCanonical(upd_dat)
+	if err != nil {
+		return err
+	}
+	err = checkRefCycles(db, fields, basic.ToIdWithCare(id), upd_dat)
+	if err != nil {
+		return err
+	}
+	err = setLanguage(db, basic.ToIdWithCare(id), dat, upd_dat)
+	if err != nil {
+		return err
+	}
 	basic.DateAndAuthor(rule, upd_dat, user_id, true)
 	upd_dat["type"] = typ
 	_, has_tags := upd_dat[Tag_fieldname_displayed]
@@ -285,23 +338,55 @@ func update(db *mgo.Database, ev ifaces.Event, rule map[string]interface{}, dat
 		addTags(db, upd_dat, id, "update", typ)
 	}
 	basic.Slug(rule, upd_dat)
+	var old_slug string
+	if old := find(db, id); old != nil {
+		old_slug, _ = old["slug"].(string)
+	}
+	var old_path string
+	if IsTree(type_opt) {
+		if page, err := findPageInfo(db, basic.ToIdWithCare(id)); err == nil {
+			old_path = page.Path
+		}
+		err = setPath(db, basic.ToIdWithCare(id), upd_dat)
+		if err != nil {
+			return err
+		}
+	}
 	mergeMaps(upd_dat, fixvals)
-	err := basic.InudVersion(db, ev, upd_dat, Cname, "update", id)
+	err = basic.InudVersion(db, ev, upd_dat, Cname, "update", id)
 	if err != nil {
 		return err
 	}
+	if IsTree(type_opt) {
+		err = movePage(db, ev, basic.ToIdWithCare(id), old_path)
+		if err != nil {
+			return err
+		}
+	}
+	if new_slug, has := upd_dat["slug"].(string); has {
+		ev.Trigger("contents.slugChanged", basic.ToIdWithCare(id), old_slug, new_slug)
+	}
 	_, has_fulltext := rule["fulltext"]
 	id_bson := bson.ObjectIdHex(basic.StripId(id))
 	if has_fulltext {
 		saveFulltext(db, id_bson)
 	}
+	indexSaved(db, opt, id_bson)
 	return nil
 }
 
-func Delete(db *mgo.Database, ev ifaces.Event, id []string, user_id bson.ObjectId) []error {
+// Deletes the contents with the given ids, together with the contents cascading from them (see relations.go).
+// Removes all deleted contents from the search index too.
+func Delete(db *mgo.Database, ev ifaces.Event, opt map[string]interface{}, id []string, user_id bson.ObjectId) []error {
 	var errs []error
 	for _, v := range id {
-		errs = append(errs, basic.Inud(db, ev, nil, Cname, "delete", v))
+		deleted, err := deleteWithRelations(db, ev, opt, basic.ToIdWithCare(v))
+		for _, x := range deleted {
+			if ierr := RemoveFromIndex(db, opt, x); ierr != nil && err == nil {
+				err = ierr
+			}
+		}
+		errs = append(errs, err)
 	}
 	return errs
 }
@@ -337,63 +422,44 @@ func FindContent(db *mgo.Database, keys []string, val string) (map[string]interf
 	return basic.Convert(v).(map[string]interface{}), true
 }
 
-func SaveTypeConfig(db *mgo.Database, inp map[string][]string) error {
-	rule := map[string]interface{}{
-		"type":        "must",
-		"safe_delete": "must",
-	}
-	_, err := extract.New(rule).Extract(inp) // _ = dat
-	if err != nil {
-		return err
-	}
-	// TODO: finish.
-	return nil
-}
-
-func SavePersonalTypeConfig(db *mgo.Database, inp map[string][]string, user_id bson.ObjectId) error {
-	return nil
-}
-
 func Install(db *mgo.Database, id bson.ObjectId) error {
+	blog := DefaultTypeOptions()
+	blog["actions"] = m{
+		"insert_comment": m{
+			"auth": m{
+				"min_lev":        0,
+				"no_puzzles_lev": 2,
+				"hot_reg":        2,
+			},
+		},
+	}
 	content_options := m{
 		"actions": m{
 			"insert_comment": m{
 				"auth": false,
 			},
-		},
-		"types": m{
-			"blog": m{
-				"actions": m{
-					"insert_comment": m{
-						"auth": m{
-							"min_lev": 0,
-							"no_puzzles_lev": 2,
-							"hot_reg": 2,
-						},
-					},
-				},
-				"comment_rules": m{
-					basic.Created:     false,
-					basic.Created_by:  false,
-					"comment_content": 1,
-				},
-				"rules": m{
-					"title":                 1,
-					"slug":                  1,
-					"content":               1,
-					Tag_fieldname_displayed: 1,
-					"fulltext":              false,
-					basic.Created:           false,
-					basic.Created_by:        false,
-					basic.Last_modified:     false,
-					basic.Last_modified_by:  false,
-				},
-				"non_versioned_fields": m{
-					"comments": 1,
+			"move_to_final": m{
+				"auth": m{
+					"min_lev": 200,
 				},
 			},
 		},
+		"languages": []string{"en"},
+		"search": m{
+			"indexer": "mongo",
+			"lang":    "en",
+			"weights": m{
+				"title":                 5,
+				Tag_fieldname_displayed: 3,
+				"content":               1,
+			},
+		},
+		"types": m{
+			"blog": blog,
+		},
 	}
+	blog_query := DefaultTypeQuery("blog")
+	blog_query["ex"] = m{"content": 300}
 	q := m{"_id": id}
 	upd := m{
 		"$addToSet": m{
@@ -401,18 +467,25 @@ func Install(db *mgo.Database, id bson.ObjectId) error {
 		},
 		"$set": m{
 			"Modules.content": content_options,
-			"Display-points.index.queries.blog": m{
-				"ex": map[string]interface{}{
-				"content": 300,
-				},
-				"c":  Cname,
-				"l":  10,
-				"q":  m{"type": "blog"},
-				"so": "-created",
-				"p":  "page",
-			},
+			"Display-points.index.queries.blog": blog_query,
 		},
 	}
+	err := EnsureCommentIndexes(db)
+	if err != nil {
+		return err
+	}
+	err = db.C(Search_cname).EnsureIndex(mgo.Index{Key: []string{"terms.t"}})
+	if err != nil {
+		return err
+	}
+	err = EnsurePageIndexes(db)
+	if err != nil {
+		return err
+	}
+	err = db.C(Cname).EnsureIndex(mgo.Index{Key: []string{Translation_group_fieldname, Lang_fieldname}})
+	if err != nil {
+		return err
+	}
 	return db.C("options").Update(q, upd)
 }
 
@@ -0,0 +1,217 @@
+package content_model
+
+import (
+	"fmt"
+	"github.com/opesun/hypecms/model/basic"
+	"labix.org/v2/mgo"
+	"labix.org/v2/mgo/bson"
+	"net/url"
+	"time"
+)
+
+// Query string keys of the facet filters.
+// type=blog, tag=<tag id>, author=<user id>, month=2013-04, from=2013-01-01, to=2013-03-31
+var Facet_keys = []string{"type", "tag", "author", "month", "from", "to"}
+
+type FacetValue struct {
+	Value    string // The value used in the query string.
+	Label    string
+	Count    int
+	Selected bool
+	Url      string // Selects the value, or deselects it if already selected.
+}
+
+// Facet name (type, tag, author, month) -> values ordered by count.
+type Facets map[string][]*FacetValue
+
+func parseDay(s string) (time.Time, error) {
+	return time.Parse("2006-01-02", s)
+}
+
+// Builds the query conditions out of the facet filters found in the query string.
+// The conditions work both on the contents and on the search index collection.
+func FacetFilter(get map[string][]string) (map[string]interface{}, error) {
+	q := map[string]interface{}{}
+	first := func(key string) string {
+		if v, has := get[key]; has && len(v) > 0 {
+			return v[0]
+		}
+		return ""
+	}
+	if typ := first("type"); len(typ) > 0 {
+		q["type"] = typ
+	}
+	if tag := first("tag"); len(tag) > 0 {
+		if !bson.IsObjectIdHex(tag) {
+			return nil, fmt.Errorf("Tag filter is not a valid id.")
+		}
+		q[Tag_fieldname] = bson.ObjectIdHex(tag)
+	}
+	if author := first("author"); len(author) > 0 {
+		if !bson.IsObjectIdHex(author) {
+			return nil, fmt.Errorf("Author filter is not a valid id.")
+		}
+		q[basic.Created_by] = bson.ObjectIdHex(author)
+	}
+	created := map[string]interface{}{}
+	if month := first("month"); len(month) > 0 {
+		t, err := time.Parse("2006-01", month)
+		if err != nil {
+			return nil, fmt.Errorf("Month filter must look like 2013-04.")
+		}
+		created["$gte"] = t.Unix()
+		created["$lt"] = t.AddDate(0, 1, 0).Unix()
+	}
+	if from := first("from"); len(from) > 0 {
+		t, err := parseDay(from)
+		if err != nil {
+			return nil, fmt.Errorf("From filter must look like 2013-04-25.")
+		}
+		if prev, has := created["$gte"].(int64); !has || t.Unix() > prev {
+			created["$gte"] = t.Unix()
+		}
+	}
+	if to := first("to"); len(to) > 0 {
+		t, err := parseDay(to)
+		if err != nil {
+			return nil, fmt.Errorf("To filter must look like 2013-04-25.")
+		}
+		end := t.AddDate(0, 0, 1).Unix() // Inclusive.
+		if prev, has := created["$lt"].(int64); !has || end < prev {
+			created["$lt"] = end
+		}
+	}
+	if len(created) > 0 {
+		q[basic.Created] = created
+	}
+	return q, nil
+}
+
+type facetCount struct {
+	Id    interface{} `bson:"_id"`
+	Count int         `bson:"count"`
+}
+
+func countBy(db *mgo.Database, coll string, match map[string]interface{}, unwind string, group_by interface{}, limit int) ([]facetCount, error) {
+	pipe := []m{{"$match": match}}
+	if len(unwind) > 0 {
+		pipe = append(pipe, m{"$unwind": "$" + unwind})
+	}
+	pipe = append(pipe,
+		m{"$group": m{"_id": group_by, "count": m{"$sum": 1}}},
+		m{"$sort": bson.D{{Name: "count", Value: -1}, {Name: "_id", Value: 1}}},
+		m{"$limit": limit},
+	)
+	var res []facetCount
+	err := db.C(coll).Pipe(pipe).All(&res)
+	return res, err
+}
+
+// Fetches the display name of documents by their id.
+func namesOf(db *mgo.Database, coll string, ids []bson.ObjectId) map[bson.ObjectId]string {
+	ret := map[bson.ObjectId]string{}
+	var docs []struct {
+		Id   bson.ObjectId `bson:"_id"`
+		Name string        `bson:"name"`
+	}
+	db.C(coll).Find(m{"_id": m{"$in": ids}}).Select(m{"name": 1}).All(&docs)
+	for _, v := range docs {
+		ret[v.Id] = v.Name
+	}
+	return ret
+}
+
+func idFacet(db *mgo.Database, counts []facetCount, name_coll string) []*FacetValue {
+	ids := []bson.ObjectId{}
+	for _, v := range counts {
+		if id, ok := v.Id.(bson.ObjectId); ok {
+			ids = append(ids, id)
+		}
+	}
+	names := namesOf(db, name_coll, ids)
+	ret := []*FacetValue{}
+	for _, v := range counts {
+		id, ok := v.Id.(bson.ObjectId)
+		if !ok {
+			continue
+		}
+		label, has := names[id]
+		if !has {
+			label = id.Hex()
+		}
+		ret = append(ret, &FacetValue{Value: id.Hex(), Label: label, Count: v.Count})
+	}
+	return ret
+}
+
+// Counts the documents matching the given query in coll by type, tags, author and created month.
+// coll can be the contents or the search index collection, both have the needed fields.
+func CountFacets(db *mgo.Database, coll string, match map[string]interface{}) (Facets, error) {
+	f := Facets{}
+	types, err := countBy(db, coll, match, "", "$type", 50)
+	if err != nil {
+		return nil, err
+	}
+	f["type"] = []*FacetValue{}
+	for _, v := range types {
+		if typ, ok := v.Id.(string); ok {
+			f["type"] = append(f["type"], &FacetValue{Value: typ, Label: typ, Count: v.Count})
+		}
+	}
+	tags, err := countBy(db, coll, match, Tag_fieldname, "$"+Tag_fieldname, 50)
+	if err != nil {
+		return nil, err
+	}
+	f["tag"] = idFacet(db, tags, Tag_cname)
+	authors, err := countBy(db, coll, match, "", "$"+basic.Created_by, 50)
+	if err != nil {
+		return nil, err
+	}
+	f["author"] = idFacet(db, authors, "users")
+	// created is a unix timestamp, it must be converted to a date before grouping.
+	date := m{"$add": []interface{}{time.Unix(0, 0), m{"$multiply": []interface{}{"$" + basic.Created, 1000}}}}
+	month_match := m{}
+	for i, v := range match {
+		month_match[i] = v
+	}
+	if _, has := month_match[basic.Created]; !has {
+		month_match[basic.Created] = m{"$exists": true}
+	}
+	months, err := countBy(db, coll, month_match, "", m{"y": m{"$year": date}, "m": m{"$month": date}}, 120)
+	if err != nil {
+		return nil, err
+	}
+	f["month"] = []*FacetValue{}
+	for _, v := range months {
+		ym, ok := v.Id.(bson.M)
+		if !ok {
+			continue
+		}
+		y, _ := ym["y"].(int)
+		mo, _ := ym["m"].(int)
+		val := fmt.Sprintf("%04d-%02d", y, mo)
+		f["month"] = append(f["month"], &FacetValue{Value: val, Label: val, Count: v.Count})
+	}
+	return f, nil
+}
+
+// Marks the currently selected values and sets the links which toggle them.
+// path is the path of the current page, get is the current query string.
+func (f Facets) SetUrls(path string, get url.Values) {
+	for name, values := range f {
+		for _, v := range values {
+			q := url.Values{}
+			for i, val := range get {
+				q[i] = val
+			}
+			q.Del("page")
+			v.Selected = get.Get(name) == v.Value
+			if v.Selected {
+				q.Del(name)
+			} else {
+				q.Set(name, v.Value)
+			}
+			v.Url = path + "?" + q.Encode()
+		}
+	}
+}
@@ -0,0 +1,528 @@
+package content_model
+
+import (
+	"fmt"
+	"github.com/opesun/hypecms/model/basic"
+	"github.com/opesun/hypecms/model/sanitize"
+	"github.com/opesun/hypecms/modules/display/model"
+	"github.com/opesun/hypecms/modules/media/model"
+	"labix.org/v2/mgo"
+	"labix.org/v2/mgo/bson"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Field types usable in "Modules.content.types.<type>.fields".
+const (
+	Text      = "text"
+	Rich_text = "richtext" // HTML, edited with a wysiwyg editor.
+	Markdown  = "markdown"
+	Number    = "number"
+	Date      = "date"
+	Boolean   = "boolean"
+	Select    = "select"
+	Reference = "reference" // Id of an other content.
+	Media     = "media"
+	Group     = "group" // Repeatable group of subfields.
+)
+
+var field_types = map[string]struct{}{
+	Text: {}, Rich_text: {}, Markdown: {}, Number: {}, Date: {}, Boolean: {}, Select: {}, Reference: {}, Media: {}, Group: {},
+}
+
+// Accepted date formats, the first one is used when displaying.
+var Date_formats = []string{"2006-01-02 15:04", "2006-01-02"}
+
+// A field of a content type. Example:
+//
+//	"fields": [
+//		{"name": "title", "type": "text", "required": true, "max_length": 120},
+//		{"name": "price", "type": "number", "min": 0},
+//		{"name": "color", "type": "select", "options": ["red", "green"]},
+//		{"name": "related", "type": "reference", "content_type": "blog", "multiple": true},
+//		{"name": "question", "type": "reference", "content_type": "question", "on_delete": "cascade", "reverse": "answers"},
+//		{"name": "cover", "type": "media"},
+//		{"name": "links", "type": "group", "fields": [{"name": "url", "type": "text"}, {"name": "label", "type": "text"}]},
+//		{"name": "body", "type": "text", "format": "adoc"},
+//		{"name": "intro", "type": "richtext", "sanitize": {"tags": {"p": [], "a": ["href"]}}}
+//	]
+//
+// Fields of the old "rules" map still work, they are treated as untyped text.
+type Field struct {
+	Name        string
+	Type        string
+	Label       string
+	Required    bool
+	Multiple    bool     // Reference and media fields can hold more than one id.
+	Options     []string // Of select.
+	ContentType string   // Restricts references to the given content type.
+	OnDelete    string   // What happens to the referencing content when the referenced one is deleted, see relations.go.
+	Reverse     string   // Name of the reverse lookup, the referencing contents are loaded into the referenced one under this key.
+	MaxLength   int
+	Min, Max    *float64
+	Fields      []*Field         // Subfields of a group.
+	Format      string           // Markup of text fields, one of the formats registered in display_model. Markdown fields are "md", rich text ones "html".
+	Sanitizer   *sanitize.Policy // HTML allowlist applied to the value when saved, see the sanitize package. Rich text and "html" fields get the default one.
+}
+
+// Name of the field in the document. References and media fields get the prefix the resolver expects.
+func (f *Field) Key() string {
+	switch f.Type {
+	case Reference:
+		if !strings.HasPrefix(f.Name, "_contents_") {
+			return "_contents_" + f.Name
+		}
+	case Media:
+		if !strings.HasPrefix(f.Name, media_model.Field_prefix) {
+			return media_model.Field_prefix + f.Name
+		}
+	}
+	return f.Name
+}
+
+func parseField(i interface{}) (*Field, error) {
+	fm, ok := i.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("Field definition is not a map.")
+	}
+	f := &Field{}
+	f.Name, _ = fm["name"].(string)
+	if len(f.Name) == 0 {
+		return nil, fmt.Errorf("Field has no name.")
+	}
+	f.Type, _ = fm["type"].(string)
+	if len(f.Type) == 0 {
+		f.Type = Text
+	}
+	if _, known := field_types[f.Type]; !known {
+		return nil, fmt.Errorf("Field %v has unkown type %v.", f.Name, f.Type)
+	}
+	f.Label, _ = fm["label"].(string)
+	if len(f.Label) == 0 {
+		f.Label = strings.Replace(f.Name, "_", " ", -1)
+	}
+	f.Required, _ = fm["required"].(bool)
+	f.Multiple, _ = fm["multiple"].(bool)
+	f.ContentType, _ = fm["content_type"].(string)
+	f.Reverse, _ = fm["reverse"].(string)
+	f.OnDelete, _ = fm["on_delete"].(string)
+	switch f.OnDelete {
+	case "":
+		f.OnDelete = Restrict
+	case Restrict, Cascade:
+	default:
+		return nil, fmt.Errorf("Field %v has unkown delete policy %v.", f.Name, f.OnDelete)
+	}
+	if ml, ok := toFloat(fm["max_length"]); ok {
+		f.MaxLength = int(ml)
+	}
+	if min, ok := toFloat(fm["min"]); ok {
+		f.Min = &min
+	}
+	if max, ok := toFloat(fm["max"]); ok {
+		f.Max = &max
+	}
+	if opts, ok := fm["options"].([]interface{}); ok {
+		for _, v := range opts {
+			f.Options = append(f.Options, fmt.Sprint(v))
+		}
+	}
+	f.Format, _ = fm["format"].(string)
+	if len(f.Format) == 0 {
+		switch f.Type {
+		case Markdown:
+			f.Format = "md"
+		case Rich_text:
+			f.Format = "html"
+		}
+	}
+	if len(f.Format) > 0 && !display_model.ValidFormat(f.Format) {
+		return nil, fmt.Errorf("Field %v has unkown format %v.", f.Name, f.Format)
+	}
+	if conf, has := fm["sanitize"]; has {
+		pol, err := sanitize.FromConfig(conf)
+		if err != nil {
+			return nil, fmt.Errorf("Field %v: %v", f.Name, err)
+		}
+		f.Sanitizer = pol
+	} else if f.Format == "html" {
+		f.Sanitizer = sanitize.Default
+	}
+	if f.Type == Select && len(f.Options) == 0 {
+		return nil, fmt.Errorf("Select field %v has no options.", f.Name)
+	}
+	if f.Type == Group {
+		subs, err := ParseFields(fm["fields"])
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range subs {
+			if v.Type == Group {
+				return nil, fmt.Errorf("Group %v can't contain an other group.", f.Name)
+			}
+		}
+		f.Fields = subs
+	}
+	return f, nil
+}
+
+// Parses the field definitions of a content type. Nil input means no fields.
+func ParseFields(i interface{}) ([]*Field, error) {
+	if i == nil {
+		return nil, nil
+	}
+	sl, ok := i.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("Fields must be a list.")
+	}
+	ret := []*Field{}
+	names := map[string]struct{}{}
+	for _, v := range sl {
+		f, err := parseField(v)
+		if err != nil {
+			return nil, err
+		}
+		if _, dupe := names[f.Name]; dupe {
+			return nil, fmt.Errorf("Field %v is defined twice.", f.Name)
+		}
+		names[f.Name] = struct{}{}
+		ret = append(ret, f)
+	}
+	return ret, nil
+}
+
+// Returns the typed fields of a content type, including the implicit ones of page trees (see pages.go) and the SEO fields (see seo.go).
+func TypeFields(type_opt map[string]interface{}) ([]*Field, error) {
+	fields, err := ParseFields(type_opt["fields"])
+	if err != nil {
+		return nil, err
+	}
+	implicit := []*Field{}
+	if IsTree(type_opt) {
+		implicit = append(implicit, treeFields()...)
+	}
+	if HasSeo(type_opt) {
+		implicit = append(implicit, seoFields()...)
+	}
+	for _, v := range implicit {
+		defined := false
+		for _, f := range fields {
+			defined = defined || f.Key() == v.Key()
+		}
+		if !defined {
+			fields = append(fields, v)
+		}
+	}
+	return fields, nil
+}
+
+// Formats of the fields of a content type which have one, by the name of the field. The display module renders them with the field builtin.
+func FieldFormats(type_opt map[string]interface{}) map[string]interface{} {
+	ret := map[string]interface{}{}
+	fields, err := TypeFields(type_opt)
+	if err != nil {
+		return ret
+	}
+	for _, v := range fields {
+		if len(v.Format) > 0 {
+			ret[v.Key()] = v.Format
+		}
+	}
+	return ret
+}
+
+// Returns the extraction rule and the typed fields of a content type, taken from the options of the type.
+// The rule is a copy, so the callers can add their own keys to it. The "sanitize" keys of the old rules are left out, see sanitize.go.
+func TypeRules(type_opt map[string]interface{}) (map[string]interface{}, []*Field, error) {
+	old_rule, has_rule := type_opt["rules"].(map[string]interface{})
+	if _, err := ruleSanitizers(old_rule); err != nil {
+		return nil, nil, err
+	}
+	rule := stripSanitize(old_rule)
+	fields, err := TypeFields(type_opt)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !has_rule && fields == nil {
+		return nil, nil, fmt.Errorf("Content type has neither rules nor fields.")
+	}
+	for _, v := range fields {
+		if v.Type == Group { // Groups are read from the form directly, see groupRows.
+			continue
+		}
+		rule[v.Key()] = 1
+	}
+	return rule, fields, nil
+}
+
+func parseBool(s string) bool {
+	switch strings.ToLower(s) {
+	case "1", "on", "true", "yes":
+		return true
+	}
+	return false
+}
+
+func parseDate(s string) (int64, error) {
+	for _, v := range Date_formats {
+		t, err := time.ParseInLocation(v, s, time.Local)
+		if err == nil {
+			return t.Unix(), nil
+		}
+	}
+	return 0, fmt.Errorf("must look like %v", Date_formats[0])
+}
+
+func parseContentRefs(db *mgo.Database, f *Field, s string) (interface{}, error) {
+	ids := []bson.ObjectId{}
+	for _, v := range strings.Split(s, ",") {
+		v = strings.TrimSpace(v)
+		if len(v) == 0 {
+			continue
+		}
+		if len(v) >= 38 {
+			v = basic.StripId(v)
+		}
+		if !bson.IsObjectIdHex(v) {
+			return nil, fmt.Errorf("%v is not a valid content id", v)
+		}
+		ids = append(ids, bson.ObjectIdHex(v))
+	}
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	if len(ids) > 1 && !f.Multiple {
+		return nil, fmt.Errorf("only one content can be referenced")
+	}
+	q := m{"_id": m{"$in": ids}}
+	if len(f.ContentType) > 0 {
+		q["type"] = f.ContentType
+	}
+	count, err := db.C(Cname).Find(q).Count()
+	if err != nil {
+		return nil, err
+	}
+	if count != len(ids) {
+		return nil, fmt.Errorf("references nonexisting content")
+	}
+	if f.Multiple {
+		return ids, nil
+	}
+	return ids[0], nil
+}
+
+// Converts one string value coming from a form to the type of the field.
+// An empty string is returned as nil (except for booleans) so required fields can be checked easily.
+func convertValue(db *mgo.Database, f *Field, s string) (interface{}, error) {
+	if f.Type == Boolean {
+		return parseBool(s), nil
+	}
+	if len(strings.TrimSpace(s)) == 0 {
+		return nil, nil
+	}
+	switch f.Type {
+	case Text, Rich_text, Markdown:
+		if f.Sanitizer != nil {
+			s = f.Sanitizer.Sanitize(s)
+		}
+		if f.MaxLength > 0 && len([]rune(s)) > f.MaxLength {
+			return nil, fmt.Errorf("can be at most %v characters long", f.MaxLength)
+		}
+		return s, nil
+	case Number:
+		n, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+		if err != nil {
+			return nil, fmt.Errorf("is not a number")
+		}
+		if f.Min != nil && n < *f.Min {
+			return nil, fmt.Errorf("must be at least %v", *f.Min)
+		}
+		if f.Max != nil && n > *f.Max {
+			return nil, fmt.Errorf("must be at most %v", *f.Max)
+		}
+		return n, nil
+	case Date:
+		return parseDate(strings.TrimSpace(s))
+	case Select:
+		for _, v := range f.Options {
+			if v == s {
+				return s, nil
+			}
+		}
+		return nil, fmt.Errorf("has no option %v", s)
+	case Reference:
+		return parseContentRefs(db, f, s)
+	case Media:
+		ref, err := media_model.ParseRefs(db, f.Key(), s)
+		if err != nil {
+			return nil, err
+		}
+		if ids, many := ref.([]bson.ObjectId); many && !f.Multiple {
+			return nil, fmt.Errorf("only one media can be selected, got %v", len(ids))
+		}
+		return ref, nil
+	}
+	return nil, fmt.Errorf("has unkown type %v", f.Type)
+}
+
+// A group comes from the form as parallel lists: links.url=a&links.label=A&links.url=b&links.label=B.
+// Rows with all subfields empty are dropped, so the empty row of the form can be left as it is.
+func groupRows(db *mgo.Database, f *Field, form map[string][]string) ([]interface{}, error) {
+	n := 0
+	for _, v := range f.Fields {
+		if l := len(form[f.Name+"."+v.Name]); l > n {
+			n = l
+		}
+	}
+	rows := []interface{}{}
+	for i := 0; i < n; i++ {
+		row := map[string]interface{}{}
+		empty := true
+		for _, v := range f.Fields {
+			var s string
+			if vals := form[f.Name+"."+v.Name]; i < len(vals) {
+				s = vals[i]
+			}
+			if len(strings.TrimSpace(s)) > 0 {
+				empty = false
+			}
+			val, err := convertValue(db, v, s)
+			if err != nil {
+				return nil, fmt.Errorf("Field %v, row %v: %v %v.", f.Label, i+1, v.Label, err)
+			}
+			row[v.Key()] = val
+		}
+		if empty {
+			continue
+		}
+		for _, v := range f.Fields {
+			if v.Required && row[v.Key()] == nil {
+				return nil, fmt.Errorf("Field %v, row %v: %v is required.", f.Label, i+1, v.Label)
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// Validates the extracted data against the typed fields and converts the values to their proper types.
+// form is the raw input, groups are read from there.
+func ValidateFields(db *mgo.Database, fields []*Field, form map[string][]string, dat map[string]interface{}) error {
+	for _, f := range fields {
+		if f.Type == Group {
+			rows, err := groupRows(db, f, form)
+			if err != nil {
+				return err
+			}
+			if f.Required && len(rows) == 0 {
+				return fmt.Errorf("Field %v needs at least one row.", f.Label)
+			}
+			dat[f.Key()] = rows
+			continue
+		}
+		s, _ := dat[f.Key()].(string)
+		val, err := convertValue(db, f, s)
+		if err != nil {
+			return fmt.Errorf("Field %v %v.", f.Label, err)
+		}
+		if f.Required && val == nil {
+			return fmt.Errorf("Field %v is required.", f.Label)
+		}
+		dat[f.Key()] = val
+	}
+	return nil
+}
+
+// Converts a stored value back to the string displayed in an input.
+func formValue(f *Field, val interface{}) interface{} {
+	switch v := val.(type) {
+	case nil:
+		return ""
+	case bson.ObjectId:
+		return v.Hex()
+	case map[string]interface{}: // Resolved reference.
+		if id, ok := v["_id"].(bson.ObjectId); ok {
+			return id.Hex()
+		}
+	case []bson.ObjectId:
+		s := []string{}
+		for _, id := range v {
+			s = append(s, id.Hex())
+		}
+		return strings.Join(s, ",")
+	case []interface{}:
+		if f.Type == Group {
+			return v
+		}
+		s := []string{}
+		for _, x := range v {
+			s = append(s, fmt.Sprint(formValue(f, x)))
+		}
+		return strings.Join(s, ",")
+	}
+	if f.Type == Date {
+		if n, ok := toFloat(val); ok {
+			return time.Unix(int64(n), 0).Format(Date_formats[0])
+		}
+	}
+	if f.Type == Number {
+		if n, ok := toFloat(val); ok {
+			return strconv.FormatFloat(n, 'f', -1, 64)
+		}
+	}
+	return val
+}
+
+func formItem(f *Field, val interface{}) map[string]interface{} {
+	item := map[string]interface{}{
+		"typed":    true,
+		"key":      f.Key(),
+		"name":     f.Name,
+		"label":    f.Label,
+		"type":     f.Type,
+		"required": f.Required,
+		"multiple": f.Multiple,
+		"options":  f.Options,
+		"value":    formValue(f, val),
+		f.Type:     true,
+	}
+	item["input_name"] = item["key"]
+	if f.Type == Boolean {
+		b, _ := val.(bool)
+		item["value"] = b
+	}
+	return item
+}
+
+// Creates form items for the edit form out of the typed fields, in their defined order.
+// Groups get a "rows" list, each row is a list of subfield items. One empty row is always appended, so a new row can be filled in.
+func FieldsToForm(fields []*Field, dat map[string]interface{}) []map[string]interface{} {
+	ret := []map[string]interface{}{}
+	for _, f := range fields {
+		var val interface{}
+		if dat != nil {
+			val = dat[f.Key()]
+		}
+		item := formItem(f, val)
+		if f.Type == Group {
+			rows := []interface{}{}
+			existing, _ := val.([]interface{})
+			for _, r := range append(existing, nil) {
+				rm, _ := r.(map[string]interface{})
+				row := []interface{}{}
+				for _, sub := range f.Fields {
+					sitem := formItem(sub, rm[sub.Key()])
+					sitem["input_name"] = f.Name + "." + sub.Name
+					sitem["in_group"] = true
+					row = append(row, sitem)
+				}
+				rows = append(rows, row)
+			}
+			item["rows"] = rows
+			item["value"] = nil
+		}
+		ret = append(ret, item)
+	}
+	return ret
+}
@@ -0,0 +1,225 @@
+package content_model
+
+import (
+	"fmt"
+	ifaces "github.com/opesun/hypecms/interfaces"
+	"github.com/opesun/hypecms/model/basic"
+	"github.com/opesun/jsonp"
+	"labix.org/v2/mgo"
+	"labix.org/v2/mgo/bson"
+	"sort"
+	"strings"
+)
+
+// Contents of types having "tree": true in their options form a page tree.
+// Every page has a path built from the slugs of its ancestors and its own slug, eg. "about/team/joe", and it is accessible at /about/team/joe.
+const (
+	Page_parent_fieldname    = "_contents_parent"
+	Page_ancestors_fieldname = "_contents_ancestors" // Root first.
+	Path_fieldname           = "path"
+)
+
+func IsTree(type_opt map[string]interface{}) bool {
+	tree, _ := type_opt["tree"].(bool)
+	return tree
+}
+
+// Fields every page has, they can be overridden by defining them in "fields".
+func treeFields() []*Field {
+	return []*Field{
+		{Name: "slug", Type: Text, Label: "slug"}, // Generated from the title if empty.
+		{Name: "parent", Type: Reference, Label: "parent page", OnDelete: Restrict, Reverse: "children"},
+		{Name: "menu_order", Type: Number, Label: "menu order"},
+	}
+}
+
+// Names of the content types forming page trees.
+func TreeTypes(opt map[string]interface{}) []string {
+	types, _ := jsonp.GetM(opt, "Modules.content.types")
+	ret := []string{}
+	for i, v := range types {
+		if tm, ok := v.(map[string]interface{}); ok && IsTree(tm) {
+			ret = append(ret, i)
+		}
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+type pageInfo struct {
+	Path      string          `bson:"path"`
+	Ancestors []bson.ObjectId `bson:"_contents_ancestors"`
+}
+
+func findPageInfo(db *mgo.Database, id bson.ObjectId) (*pageInfo, error) {
+	p := &pageInfo{}
+	err := db.C(Cname).Find(m{"_id": id}).Select(m{Path_fieldname: 1, Page_ancestors_fieldname: 1}).One(p)
+	return p, err
+}
+
+// Computes the path and the ancestors of a page from the data about to be saved, and checks if the path is free.
+// id is empty when inserting.
+func setPath(db *mgo.Database, id bson.ObjectId, dat map[string]interface{}) error {
+	slug, _ := dat["slug"].(string)
+	if len(slug) == 0 {
+		return fmt.Errorf("Pages must have a slug.")
+	}
+	if strings.Contains(slug, "/") {
+		return fmt.Errorf("Slug can't contain a slash.")
+	}
+	path := slug
+	ancestors := []bson.ObjectId{}
+	if parent_id, has := dat[Page_parent_fieldname].(bson.ObjectId); has {
+		parent, err := findPageInfo(db, parent_id)
+		if err != nil {
+			return fmt.Errorf("Can't find parent page.")
+		}
+		if len(parent.Path) == 0 {
+			return fmt.Errorf("Parent content is not a page.")
+		}
+		path = parent.Path + "/" + slug
+		ancestors = append(parent.Ancestors, parent_id)
+	}
+	q := m{Path_fieldname: path}
+	if len(id) > 0 {
+		q["_id"] = m{"$ne": id}
+	}
+	count, err := db.C(Cname).Find(q).Count()
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return fmt.Errorf("Path /%v is already taken.", path)
+	}
+	dat[Path_fieldname] = path
+	dat[Page_ancestors_fieldname] = ancestors
+	return nil
+}
+
+// Updates the paths and ancestors of the descendants of a page after it was moved or renamed.
+// Triggers "contents.pathChanged" with the old and the new path for all of them, the redirects module saves redirects this way.
+func movePage(db *mgo.Database, ev ifaces.Event, id bson.ObjectId, old_path string) error {
+	page, err := findPageInfo(db, id)
+	if err != nil {
+		return err
+	}
+	if len(old_path) == 0 || old_path == page.Path {
+		return nil
+	}
+	ev.Trigger("contents.pathChanged", old_path, page.Path)
+	var descendants []struct {
+		Id        bson.ObjectId   `bson:"_id"`
+		Path      string          `bson:"path"`
+		Ancestors []bson.ObjectId `bson:"_contents_ancestors"`
+	}
+	err = db.C(Cname).Find(m{Page_ancestors_fieldname: id}).All(&descendants)
+	if err != nil {
+		return err
+	}
+	for _, v := range descendants {
+		if !strings.HasPrefix(v.Path, old_path+"/") {
+			continue
+		}
+		new_path := page.Path + v.Path[len(old_path):]
+		ancestors := append(append([]bson.ObjectId{}, page.Ancestors...), id)
+		for i, a := range v.Ancestors {
+			if a == id {
+				ancestors = append(ancestors, v.Ancestors[i+1:]...)
+				break
+			}
+		}
+		err = db.C(Cname).Update(m{"_id": v.Id}, m{"$set": m{Path_fieldname: new_path, Page_ancestors_fieldname: ancestors}})
+		if err != nil {
+			return err
+		}
+		ev.Trigger("contents.pathChanged", v.Path, new_path)
+	}
+	return nil
+}
+
+// Finds a page by its path, path has no leading or trailing slashes.
+func FindByPath(db *mgo.Database, path string) (map[string]interface{}, bool) {
+	if len(path) == 0 {
+		return nil, false
+	}
+	var v interface{}
+	err := db.C(Cname).Find(m{Path_fieldname: path}).One(&v)
+	if err != nil {
+		return nil, false
+	}
+	return basic.Convert(v).(map[string]interface{}), true
+}
+
+// The ancestors of a page, root first, for breadcrumbs.
+func Breadcrumbs(db *mgo.Database, content map[string]interface{}) []interface{} {
+	ids, _ := content[Page_ancestors_fieldname].([]interface{})
+	if len(ids) == 0 {
+		return nil
+	}
+	if _, resolved := ids[0].(map[string]interface{}); resolved {
+		return ids
+	}
+	var res []interface{}
+	db.C(Cname).Find(m{"_id": m{"$in": ids}}).Select(m{"title": 1, Path_fieldname: 1}).All(&res)
+	by_id := map[interface{}]interface{}{}
+	for _, v := range res {
+		doc := basic.Convert(v).(map[string]interface{})
+		by_id[doc["_id"]] = doc
+	}
+	ret := []interface{}{}
+	for _, v := range ids {
+		if doc, has := by_id[v]; has {
+			ret = append(ret, doc)
+		}
+	}
+	return ret
+}
+
+// Builds the menu out of the page trees. Every item has "title", "path", "active" and "children".
+// An item is active if it is the current page or an ancestor of it.
+func Menu(db *mgo.Database, opt map[string]interface{}, current_path string) ([]interface{}, error) {
+	types := TreeTypes(opt)
+	if len(types) == 0 {
+		return nil, nil
+	}
+	var pages []struct {
+		Id     bson.ObjectId `bson:"_id"`
+		Title  string        `bson:"title"`
+		Path   string        `bson:"path"`
+		Parent bson.ObjectId `bson:"_contents_parent"`
+	}
+	err := db.C(Cname).Find(m{"type": m{"$in": types}, Path_fieldname: m{"$exists": true}}).
+		Select(m{"title": 1, Path_fieldname: 1, Page_parent_fieldname: 1}).
+		Sort("menu_order", "title").Limit(1000).All(&pages)
+	if err != nil {
+		return nil, err
+	}
+	items := map[bson.ObjectId]map[string]interface{}{}
+	for _, v := range pages {
+		items[v.Id] = map[string]interface{}{
+			"_id":      v.Id,
+			"title":    v.Title,
+			"path":     v.Path,
+			"active":   current_path == v.Path || strings.HasPrefix(current_path, v.Path+"/"),
+			"children": []interface{}{},
+		}
+	}
+	menu := []interface{}{}
+	for _, v := range pages {
+		item := items[v.Id]
+		if parent, has := items[v.Parent]; has {
+			parent["children"] = append(parent["children"].([]interface{}), item)
+		} else {
+			menu = append(menu, item)
+		}
+	}
+	return menu, nil
+}
+
+func EnsurePageIndexes(db *mgo.Database) error {
+	err := db.C(Cname).EnsureIndex(mgo.Index{Key: []string{Path_fieldname}, Unique: true, Sparse: true})
+	if err != nil {
+		return err
+	}
+	return db.C(Cname).EnsureIndex(mgo.Index{Key: []string{Page_ancestors_fieldname}})
+}
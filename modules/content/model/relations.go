@@ -0,0 +1,217 @@
+package content_model
+
+import (
+	"fmt"
+	ifaces "github.com/opesun/hypecms/interfaces"
+	"github.com/opesun/hypecms/model/basic"
+	"github.com/opesun/jsonp"
+	"labix.org/v2/mgo"
+	"labix.org/v2/mgo/bson"
+	"sort"
+)
+
+// Delete policies of reference fields.
+const (
+	Restrict = "restrict" // The referenced content can't be deleted while something references it. This is the default.
+	Cascade  = "cascade"  // The referencing contents are deleted together with the referenced one.
+)
+
+// A reference field of a content type, seen from the referenced side.
+type Relation struct {
+	Type  string // Type of the referencing contents.
+	Path  string // Key of the reference in the referencing contents, "group.key" for references inside repeatable groups.
+	Field *Field
+}
+
+// Returns all reference fields which can point to contents of typ. Fields without "content_type" can point to anything.
+func RelationsTo(opt map[string]interface{}, typ string) ([]Relation, error) {
+	types, _ := jsonp.GetM(opt, "Modules.content.types")
+	names := []string{}
+	for i, _ := range types {
+		names = append(names, i)
+	}
+	sort.Strings(names)
+	rels := []Relation{}
+	add := func(t, path string, f *Field) {
+		if f.Type == Reference && (f.ContentType == "" || f.ContentType == typ) {
+			rels = append(rels, Relation{Type: t, Path: path, Field: f})
+		}
+	}
+	for _, t := range names {
+		tm, _ := types[t].(map[string]interface{})
+		fields, err := TypeFields(tm)
+		if err != nil {
+			return nil, fmt.Errorf("Content type %v: %v", t, err)
+		}
+		for _, f := range fields {
+			add(t, f.Key(), f)
+			for _, sub := range f.Fields {
+				add(t, f.Key()+"."+sub.Key(), sub)
+			}
+		}
+	}
+	return rels, nil
+}
+
+// Query matching the contents referencing id trough rel. Works with one and many valued references too.
+func (rel Relation) query(id bson.ObjectId) map[string]interface{} {
+	return m{"type": rel.Type, rel.Path: id}
+}
+
+// Returns the newest contents referencing id trough rel, eg. the answers of a question.
+func Children(db *mgo.Database, rel Relation, id bson.ObjectId, limit int) ([]interface{}, error) {
+	var res []interface{}
+	err := db.C(Cname).Find(rel.query(id)).Sort("-" + basic.Created).Limit(limit).All(&res)
+	if err != nil {
+		return nil, err
+	}
+	return basic.Convert(res).([]interface{}), nil
+}
+
+// Loads the referencing contents into content for every relation having a "reverse" name.
+func ReverseLookups(db *mgo.Database, opt map[string]interface{}, content map[string]interface{}, limit int) error {
+	id, ok := content["_id"].(bson.ObjectId)
+	if !ok {
+		return nil
+	}
+	typ, _ := content["type"].(string)
+	rels, err := RelationsTo(opt, typ)
+	if err != nil {
+		return err
+	}
+	for _, rel := range rels {
+		if len(rel.Field.Reverse) == 0 {
+			continue
+		}
+		children, err := Children(db, rel, id, limit)
+		if err != nil {
+			return err
+		}
+		content[rel.Field.Reverse] = children
+	}
+	return nil
+}
+
+// Collects the contents which must be deleted together with id because of cascading relations.
+// order gets the ids leaves first: every content comes after the ones cascading from it (cycles aside).
+func cascadeSet(db *mgo.Database, opt map[string]interface{}, id bson.ObjectId, set map[bson.ObjectId]string, order *[]bson.ObjectId) error {
+	typ, err := TypeOf(db, id)
+	if err != nil {
+		return err
+	}
+	set[id] = typ
+	rels, err := RelationsTo(opt, typ)
+	if err != nil {
+		return err
+	}
+	for _, rel := range rels {
+		if rel.Field.OnDelete != Cascade {
+			continue
+		}
+		var children []struct {
+			Id bson.ObjectId `bson:"_id"`
+		}
+		err := db.C(Cname).Find(rel.query(id)).Select(m{"_id": 1}).All(&children)
+		if err != nil {
+			return err
+		}
+		for _, v := range children {
+			if _, has := set[v.Id]; has { // References can form cycles.
+				continue
+			}
+			err := cascadeSet(db, opt, v.Id, set, order)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	*order = append(*order, id)
+	return nil
+}
+
+// Returns an error if anything outside of the set references a content in the set trough a restricting relation.
+func checkRestrict(db *mgo.Database, opt map[string]interface{}, set map[bson.ObjectId]string) error {
+	ids := []bson.ObjectId{}
+	for i, _ := range set {
+		ids = append(ids, i)
+	}
+	for id, typ := range set {
+		rels, err := RelationsTo(opt, typ)
+		if err != nil {
+			return err
+		}
+		for _, rel := range rels {
+			if rel.Field.OnDelete != Restrict {
+				continue
+			}
+			q := rel.query(id)
+			q["_id"] = m{"$nin": ids}
+			count, err := db.C(Cname).Find(q).Count()
+			if err != nil {
+				return err
+			}
+			if count > 0 {
+				return fmt.Errorf("Can't delete content %v, %v %v content(s) reference it trough field %v.", id.Hex(), count, rel.Type, rel.Field.Label)
+			}
+		}
+	}
+	return nil
+}
+
+// Deletes a content together with the ones cascading from it, after checking that no restricting relation is violated.
+// The leaves go first, so if something fails midway, no remaining content references a deleted one trough a cascading relation,
+// and the delete can be simply retried. Returns the ids of the deleted contents, all of them if there was no error.
+func deleteWithRelations(db *mgo.Database, ev ifaces.Event, opt map[string]interface{}, id bson.ObjectId) ([]bson.ObjectId, error) {
+	set := map[bson.ObjectId]string{}
+	order := []bson.ObjectId{}
+	err := cascadeSet(db, opt, id, set, &order)
+	if err != nil {
+		return nil, err
+	}
+	err = checkRestrict(db, opt, set)
+	if err != nil {
+		return nil, err
+	}
+	deleted := []bson.ObjectId{}
+	for _, v := range order {
+		err := basic.Inud(db, ev, nil, Cname, "delete", v.Hex())
+		if err != nil {
+			return deleted, partialDelete(id, len(deleted), len(order), err)
+		}
+		deleted = append(deleted, v)
+		err = deleteCommentsOf(db, v)
+		if err != nil {
+			return deleted, partialDelete(id, len(deleted), len(order), err)
+		}
+	}
+	return deleted, nil
+}
+
+func partialDelete(id bson.ObjectId, deleted, all int, err error) error {
+	if deleted == 0 {
+		return err
+	}
+	return fmt.Errorf("Deleting content %v stopped after %v of the %v contents cascading from it, the rest is left intact: %v", id.Hex(), deleted, all, err)
+}
+
+// Makes sure single valued references to the same type (parent-child hierarchies) don't form a cycle, eg. a page can't be the parent of its own parent.
+func checkRefCycles(db *mgo.Database, fields []*Field, id bson.ObjectId, dat map[string]interface{}) error {
+	for _, f := range fields {
+		if f.Type != Reference || f.Multiple {
+			continue
+		}
+		cur, ok := dat[f.Key()].(bson.ObjectId)
+		for depth := 0; ok && depth < 100; depth++ {
+			if cur == id {
+				return fmt.Errorf("Field %v would create a cycle: the content would become its own ancestor.", f.Label)
+			}
+			var doc bson.M
+			err := db.C(Cname).Find(m{"_id": cur}).Select(m{f.Key(): 1}).One(&doc)
+			if err != nil {
+				break
+			}
+			cur, ok = doc[f.Key()].(bson.ObjectId)
+		}
+	}
+	return nil
+}
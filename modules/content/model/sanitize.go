@@ -0,0 +1,88 @@
+package content_model
+
+import (
+	"fmt"
+	"github.com/opesun/hypecms/model/sanitize"
+)
+
+// Fields of extract rules (the old "rules" of the content types and the "comment_rules") can be sanitized too, with a "sanitize" key
+// in the rule of the field, taking the same values as the one of the typed fields:
+//
+//	"comment_rules": {"content": {"must": 1, "sanitize": true}, "name": 1}
+//
+// Returns the policies by field name.
+func ruleSanitizers(rule map[string]interface{}) (map[string]*sanitize.Policy, error) {
+	ret := map[string]*sanitize.Policy{}
+	for i, v := range rule {
+		vm, ok := v.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		conf, has := vm["sanitize"]
+		if !has {
+			continue
+		}
+		pol, err := sanitize.FromConfig(conf)
+		if err != nil {
+			return nil, fmt.Errorf("Field %v: %v", i, err)
+		}
+		if pol != nil {
+			ret[i] = pol
+		}
+	}
+	return ret, nil
+}
+
+// Tells if field opted in to HTML with a "sanitize" key in rule. Such values are sanitized when saved and can be rendered as HTML,
+// the others must be displayed as plain (escaped) text.
+func RendersHtml(rule map[string]interface{}, field string) bool {
+	pols, err := ruleSanitizers(rule)
+	return err == nil && pols[field] != nil
+}
+
+// Returns a copy of rule without the "sanitize" keys, extract does not know about them.
+func stripSanitize(rule map[string]interface{}) map[string]interface{} {
+	ret := map[string]interface{}{}
+	for i, v := range rule {
+		ret[i] = v
+		vm, ok := v.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if _, has := vm["sanitize"]; !has {
+			continue
+		}
+		stripped := map[string]interface{}{}
+		for j, w := range vm {
+			if j != "sanitize" {
+				stripped[j] = w
+			}
+		}
+		if len(stripped) == 0 {
+			ret[i] = 1
+		} else {
+			ret[i] = stripped
+		}
+	}
+	return ret
+}
+
+// Sanitizes the extracted values which have a policy.
+func applySanitizers(pols map[string]*sanitize.Policy, dat map[string]interface{}) {
+	for i, pol := range pols {
+		switch v := dat[i].(type) {
+		case string:
+			dat[i] = pol.Sanitize(v)
+		case []interface{}:
+			for j, s := range v {
+				if str, ok := s.(string); ok {
+					v[j] = pol.Sanitize(str)
+				}
+			}
+		case []string:
+			for j, s := range v {
+				v[j] = pol.Sanitize(s)
+			}
+		}
+	}
+}
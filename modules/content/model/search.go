@@ -0,0 +1,625 @@
+package content_model
+
+import (
+	"fmt"
+	"github.com/opesun/hypecms/model/basic"
+	"github.com/opesun/jsonp"
+	"github.com/opesun/resolver"
+	"html"
+	"html/template"
+	"labix.org/v2/mgo"
+	"labix.org/v2/mgo/bson"
+	"sort"
+	"unicode"
+)
+
+const Search_cname = "search_index"
+
+// Search related options of a content type.
+// They are read from "Modules.content.search", and can be overridden per type in "Modules.content.types.<type>.search".
+// Example: {"indexer": "mongo", "lang": "en", "weights": {"title": 5, "tags": 3}, "default_weight": 1, "snippet_length": 200}
+type SearchOpts struct {
+	Lang          string
+	Weights       map[string]float64
+	DefaultWeight float64
+	SnippetLength int
+}
+
+func (s *SearchOpts) Weight(field string) float64 {
+	if w, has := s.Weights[field]; has {
+		return w
+	}
+	return s.DefaultWeight
+}
+
+type SearchQuery struct {
+	Text          string
+	Lang          string                 // Analyze the query only in this language.
+	Langs         []string               // Languages the contents were indexed in, the query is analyzed with each of them. Search fills it, see queryLangs.
+	Filter        map[string]interface{} // Extra conditions on the indexed "type", "_tags", "_users_created_by" and "created" fields.
+	Skip          int
+	Limit         int
+	SnippetLength int
+}
+
+type Hit struct {
+	Id      bson.ObjectId
+	Score   float64
+	Snippet string // HTML, already escaped.
+}
+
+// Anything which can index and search contents.
+type Indexer interface {
+	Index(content map[string]interface{}, opts *SearchOpts) error
+	Remove(id bson.ObjectId) error
+	// Returns one page of hits ordered by relevance and the number of all matching contents.
+	Search(q *SearchQuery) ([]Hit, int, error)
+	// Facet counts (see facets.go) of all the contents matching the query.
+	Facets(q *SearchQuery) (Facets, error)
+	Clear() error
+}
+
+var indexers = map[string]func(*mgo.Database) Indexer{
+	"mongo": func(db *mgo.Database) Indexer {
+		return &MongoIndexer{db}
+	},
+}
+
+// Other modules can plug in their own search backends with this, and then select them with the "Modules.content.search.indexer" option.
+func RegisterIndexer(name string, constructor func(*mgo.Database) Indexer) {
+	indexers[name] = constructor
+}
+
+func NewIndexer(db *mgo.Database, opt map[string]interface{}) (Indexer, error) {
+	name, has := jsonp.GetStr(opt, "Modules.content.search.indexer")
+	if !has {
+		name = "mongo"
+	}
+	constructor, has := indexers[name]
+	if !has {
+		return nil, fmt.Errorf("Unkown search indexer %v.", name)
+	}
+	return constructor(db), nil
+}
+
+func toFloat(i interface{}) (float64, bool) {
+	switch val := i.(type) {
+	case float64:
+		return val, true
+	case int:
+		return float64(val), true
+	case int64:
+		return float64(val), true
+	}
+	return 0, false
+}
+
+func mergeSearchOpts(s *SearchOpts, o map[string]interface{}) {
+	if lang, ok := o["lang"].(string); ok {
+		s.Lang = lang
+	}
+	if dw, ok := toFloat(o["default_weight"]); ok {
+		s.DefaultWeight = dw
+	}
+	if sl, ok := toFloat(o["snippet_length"]); ok {
+		s.SnippetLength = int(sl)
+	}
+	if weights, ok := o["weights"].(map[string]interface{}); ok {
+		for i, v := range weights {
+			if w, ok := toFloat(v); ok {
+				s.Weights[i] = w
+			}
+		}
+	}
+}
+
+// opt is the whole option document.
+func SearchOptions(opt map[string]interface{}, typ string) *SearchOpts {
+	s := &SearchOpts{
+		Lang:          "en",
+		Weights:       map[string]float64{"title": 5, "tags": 3},
+		DefaultWeight: 1,
+		SnippetLength: 200,
+	}
+	if general, has := jsonp.GetM(opt, "Modules.content.search"); has {
+		mergeSearchOpts(s, general)
+	}
+	if len(typ) > 0 {
+		if per_type, has := jsonp.GetM(opt, "Modules.content.types."+typ+".search"); has {
+			mergeSearchOpts(s, per_type)
+		}
+	}
+	return s
+}
+
+var not_indexed = map[string]struct{}{
+	"slug":        {},
+	"type":        {},
+	"fulltext":    {},
+	"draft_id":    {},
+	"pointing_to": {},
+	"root":        {},
+	"id":          {},
+}
+
+// Collects the text of every indexable field of a content. Tags must be already resolved.
+func indexedFields(content map[string]interface{}) map[string]string {
+	ret := map[string]string{}
+	for i, v := range content {
+		if _, skip := not_indexed[i]; skip {
+			continue
+		}
+		if i == Tag_fieldname {
+			tags, ok := v.([]interface{})
+			if !ok {
+				continue
+			}
+			names := ""
+			for _, t := range tags {
+				if tag, ok := t.(map[string]interface{}); ok {
+					if name, ok := tag["name"].(string); ok {
+						names += name + " "
+					}
+				}
+			}
+			ret[Tag_fieldname_displayed] = names
+			continue
+		}
+		if str, ok := v.(string); ok && len(i) > 0 && i[0] != '_' {
+			ret[i] = str
+		}
+	}
+	return ret
+}
+
+// Tags can come in resolved or as plain ids.
+func tagIds(tags interface{}) []bson.ObjectId {
+	ret := []bson.ObjectId{}
+	sl, ok := tags.([]interface{})
+	if !ok {
+		return ret
+	}
+	for _, v := range sl {
+		switch val := v.(type) {
+		case bson.ObjectId:
+			ret = append(ret, val)
+		case map[string]interface{}:
+			if id, ok := val["_id"].(bson.ObjectId); ok {
+				ret = append(ret, id)
+			}
+		}
+	}
+	return ret
+}
+
+// The default indexer, stores an inverted-ish document per content in the search_index collection:
+// {"_id": content id, "terms": [{"t": term, "w": weight}], "text": {field: text}, "lang", "type", "_tags", "_users_created_by", "created"}
+type MongoIndexer struct {
+	db *mgo.Database
+}
+
+func (mi *MongoIndexer) Index(content map[string]interface{}, opts *SearchOpts) error {
+	id, ok := content["_id"].(bson.ObjectId)
+	if !ok {
+		return fmt.Errorf("Can't index content without id.")
+	}
+	an := AnalyzerOf(opts.Lang)
+	fields := indexedFields(content)
+	weights := map[string]float64{}
+	for field, text := range fields {
+		w := opts.Weight(field)
+		if w <= 0 {
+			continue
+		}
+		for _, t := range an.Terms(text) {
+			weights[t] += w
+		}
+	}
+	terms := []interface{}{}
+	for t, w := range weights {
+		terms = append(terms, m{"t": t, "w": w})
+	}
+	doc := m{
+		"terms":          terms,
+		"text":           fields,
+		"lang":           opts.Lang,
+		"type":           content["type"],
+		Tag_fieldname:    tagIds(content[Tag_fieldname]),
+		basic.Created_by: content[basic.Created_by],
+		basic.Created:    content[basic.Created],
+	}
+	_, err := mi.db.C(Search_cname).UpsertId(id, doc)
+	return err
+}
+
+func (mi *MongoIndexer) Remove(id bson.ObjectId) error {
+	err := mi.db.C(Search_cname).RemoveId(id)
+	if err == mgo.ErrNotFound {
+		return nil
+	}
+	return err
+}
+
+func (mi *MongoIndexer) Clear() error {
+	_, err := mi.db.C(Search_cname).RemoveAll(nil)
+	if err != nil {
+		return err
+	}
+	return mi.db.C(Search_cname).EnsureIndex(mgo.Index{Key: []string{"terms.t"}})
+}
+
+// Terms of the query, deduplicated.
+func queryTerms(an *Analyzer, text string) []string {
+	return filterDupes(an.Terms(text))
+}
+
+// Terms of the query by language, analyzed the same way as the contents indexed in that language were.
+// Languages without terms (eg. the query consists of their stop words only) are left out.
+func analyzeQuery(q *SearchQuery) map[string][]string {
+	langs := q.Langs
+	if len(langs) == 0 {
+		langs = []string{q.Lang}
+	}
+	ret := map[string][]string{}
+	for _, v := range langs {
+		if terms := queryTerms(AnalyzerOf(v), q.Text); len(terms) > 0 {
+			ret[v] = terms
+		}
+	}
+	return ret
+}
+
+// The terms of a language only match the contents indexed in that language.
+func termsMatch(terms map[string][]string) m {
+	langs := []string{}
+	for i, _ := range terms {
+		langs = append(langs, i)
+	}
+	if len(langs) == 1 {
+		return m{"terms.t": m{"$in": terms[langs[0]]}}
+	}
+	sort.Strings(langs)
+	or := []m{}
+	for _, v := range langs {
+		or = append(or, m{"lang": v, "terms.t": m{"$in": terms[v]}})
+	}
+	return m{"$or": or}
+}
+
+func searchMatch(q *SearchQuery, terms map[string][]string) m {
+	match := termsMatch(terms)
+	for i, v := range q.Filter {
+		match[i] = v
+	}
+	return match
+}
+
+func (mi *MongoIndexer) Facets(q *SearchQuery) (Facets, error) {
+	terms := analyzeQuery(q)
+	if len(terms) == 0 {
+		return Facets{}, nil
+	}
+	return CountFacets(mi.db, Search_cname, searchMatch(q, terms))
+}
+
+func (mi *MongoIndexer) Search(q *SearchQuery) ([]Hit, int, error) {
+	terms := analyzeQuery(q)
+	if len(terms) == 0 {
+		return nil, 0, nil
+	}
+	match := searchMatch(q, terms)
+	count, err := mi.db.C(Search_cname).Find(match).Count()
+	if err != nil {
+		return nil, 0, err
+	}
+	limit := q.Limit
+	if limit <= 0 {
+		limit = 20
+	}
+	// Contents matching more of the terms come first, then the ones with higher weights.
+	pipe := []m{
+		{"$match": match},
+		{"$unwind": "$terms"},
+		{"$match": termsMatch(terms)},
+		{"$group": m{
+			"_id":     "$_id",
+			"score":   m{"$sum": "$terms.w"},
+			"matched": m{"$sum": 1},
+			"created": m{"$first": "$" + basic.Created},
+		}},
+		{"$sort": bson.D{{Name: "matched", Value: -1}, {Name: "score", Value: -1}, {Name: "created", Value: -1}}},
+		{"$skip": q.Skip},
+		{"$limit": limit},
+	}
+	var res []struct {
+		Id    bson.ObjectId `bson:"_id"`
+		Score float64       `bson:"score"`
+	}
+	err = mi.db.C(Search_cname).Pipe(pipe).All(&res)
+	if err != nil {
+		return nil, 0, err
+	}
+	ids := []bson.ObjectId{}
+	for _, v := range res {
+		ids = append(ids, v.Id)
+	}
+	var docs []struct {
+		Id   bson.ObjectId     `bson:"_id"`
+		Text map[string]string `bson:"text"`
+		Lang string            `bson:"lang"`
+	}
+	err = mi.db.C(Search_cname).Find(m{"_id": m{"$in": ids}}).Select(m{"text": 1, "lang": 1}).All(&docs)
+	if err != nil {
+		return nil, 0, err
+	}
+	texts := map[bson.ObjectId]map[string]string{}
+	langs := map[bson.ObjectId]string{}
+	for _, v := range docs {
+		texts[v.Id] = v.Text
+		langs[v.Id] = v.Lang
+	}
+	term_sets := map[string]map[string]struct{}{}
+	for lang, ts := range terms {
+		term_sets[lang] = map[string]struct{}{}
+		for _, v := range ts {
+			term_sets[lang][v] = struct{}{}
+		}
+	}
+	hits := []Hit{}
+	for _, v := range res {
+		lang := langs[v.Id]
+		term_set, has := term_sets[lang]
+		if !has { // Only one language was searched, the content was matched without looking at its language.
+			for i, x := range term_sets {
+				lang, term_set = i, x
+			}
+		}
+		hits = append(hits, Hit{
+			Id:      v.Id,
+			Score:   v.Score,
+			Snippet: bestSnippet(texts[v.Id], AnalyzerOf(lang), term_set, q.SnippetLength),
+		})
+	}
+	return hits, count, nil
+}
+
+// Chooses the field with the most matches and creates a snippet out of it.
+func bestSnippet(fields map[string]string, an *Analyzer, terms map[string]struct{}, length int) string {
+	keys := []string{}
+	for i, _ := range fields {
+		keys = append(keys, i)
+	}
+	sort.Strings(keys)
+	best, best_count := "", -1
+	for _, v := range keys {
+		c := 0
+		for _, w := range Tokenize(fields[v]) {
+			if t, ok := an.Term(w); ok {
+				if _, hit := terms[t]; hit {
+					c++
+				}
+			}
+		}
+		if c > best_count || (c == best_count && len(fields[v]) > len(fields[best])) {
+			best, best_count = v, c
+		}
+	}
+	return Highlight(fields[best], an, terms, length)
+}
+
+type span struct {
+	from, to int
+	hit      bool
+}
+
+// Splits text into words, marking the ones which match any of the (already analyzed) terms.
+func wordSpans(text string, an *Analyzer, terms map[string]struct{}) []span {
+	spans := []span{}
+	start := -1
+	for i, r := range text + " " {
+		in_word := unicode.IsLetter(r) || unicode.IsDigit(r)
+		if in_word && start == -1 {
+			start = i
+		} else if !in_word && start != -1 {
+			sp := span{from: start, to: i}
+			for _, w := range Tokenize(text[start:i]) {
+				if t, ok := an.Term(w); ok {
+					if _, hit := terms[t]; hit {
+						sp.hit = true
+					}
+				}
+			}
+			spans = append(spans, sp)
+			start = -1
+		}
+	}
+	return spans
+}
+
+// Cuts roughly length bytes of text around the first match, html escapes it and wraps the matching words into <b> tags.
+func Highlight(text string, an *Analyzer, terms map[string]struct{}, length int) string {
+	spans := wordSpans(text, an, terms)
+	if len(spans) == 0 {
+		return ""
+	}
+	first := 0
+	for i, v := range spans {
+		if v.hit {
+			first = i
+			break
+		}
+	}
+	from := first
+	for from > 0 && spans[first].from-spans[from-1].from < length/3 {
+		from--
+	}
+	to := from
+	for to < len(spans)-1 && spans[to+1].to-spans[from].from <= length {
+		to++
+	}
+	ret := ""
+	if from > 0 {
+		ret = "… "
+	}
+	pos := spans[from].from
+	for _, v := range spans[from : to+1] {
+		ret += html.EscapeString(text[pos:v.from])
+		if v.hit {
+			ret += "<b>" + html.EscapeString(text[v.from:v.to]) + "</b>"
+		} else {
+			ret += html.EscapeString(text[v.from:v.to])
+		}
+		pos = v.to
+	}
+	if to < len(spans)-1 {
+		ret += " …"
+	}
+	return ret
+}
+
+// Reads a content from the database, resolves the tags (their names are indexed too) and passes it to the indexer.
+func IndexContent(db *mgo.Database, ix Indexer, opt map[string]interface{}, id bson.ObjectId) error {
+	var res interface{}
+	err := db.C(Cname).Find(m{"_id": id}).One(&res)
+	if err != nil {
+		return err
+	}
+	content := basic.Convert(res).(map[string]interface{})
+	resolver.ResolveOne(db, content, m{"name": 1, "slug": 1})
+	content = basic.Convert(content).(map[string]interface{})
+	typ, _ := content["type"].(string)
+	return ix.Index(content, SearchOptions(opt, typ))
+}
+
+// Convenience function for the controllers.
+func IndexContentById(db *mgo.Database, opt map[string]interface{}, id bson.ObjectId) error {
+	ix, err := NewIndexer(db, opt)
+	if err != nil {
+		return err
+	}
+	return IndexContent(db, ix, opt, id)
+}
+
+// Indexes a content after insert or update. The content is saved already, so a failure is only logged: the callers must not
+// think the save failed (and retry it). RegenerateFulltext brings the index up to date later.
+func indexSaved(db *mgo.Database, opt map[string]interface{}, id bson.ObjectId) {
+	if err := IndexContentById(db, opt, id); err != nil {
+		fmt.Println("Can't index content "+id.Hex()+":", err)
+	}
+}
+
+func RemoveFromIndex(db *mgo.Database, opt map[string]interface{}, id bson.ObjectId) error {
+	ix, err := NewIndexer(db, opt)
+	if err != nil {
+		return err
+	}
+	return ix.Remove(id)
+}
+
+// Languages the contents matching the query may be indexed in: the one of the type if the query is filtered to a type,
+// otherwise the general one and the ones of all types.
+func queryLangs(opt map[string]interface{}, q *SearchQuery) []string {
+	if len(q.Lang) > 0 {
+		return []string{q.Lang}
+	}
+	if typ, ok := q.Filter["type"].(string); ok {
+		return []string{SearchOptions(opt, typ).Lang}
+	}
+	langs := []string{SearchOptions(opt, "").Lang}
+	types, _ := jsonp.GetM(opt, "Modules.content.types")
+	for typ, _ := range types {
+		lang := SearchOptions(opt, typ).Lang
+		has := false
+		for _, v := range langs {
+			if v == lang {
+				has = true
+				break
+			}
+		}
+		if !has {
+			langs = append(langs, lang)
+		}
+	}
+	sort.Strings(langs)
+	return langs
+}
+
+// Runs a search and returns the found contents in order of relevance, with a "snippet" field on each, and the number of all matches.
+func Search(db *mgo.Database, opt map[string]interface{}, q *SearchQuery) ([]interface{}, int, error) {
+	ix, err := NewIndexer(db, opt)
+	if err != nil {
+		return nil, 0, err
+	}
+	general := SearchOptions(opt, "")
+	q.Langs = queryLangs(opt, q)
+	if q.SnippetLength == 0 {
+		q.SnippetLength = general.SnippetLength
+	}
+	hits, count, err := ix.Search(q)
+	if err != nil {
+		return nil, 0, err
+	}
+	ids := []bson.ObjectId{}
+	for _, v := range hits {
+		ids = append(ids, v.Id)
+	}
+	var res []interface{}
+	err = db.C(Cname).Find(m{"_id": m{"$in": ids}}).All(&res)
+	if err != nil {
+		return nil, 0, err
+	}
+	res = basic.Convert(res).([]interface{})
+	by_id := map[bson.ObjectId]map[string]interface{}{}
+	for _, v := range res {
+		doc := v.(map[string]interface{})
+		by_id[doc["_id"].(bson.ObjectId)] = doc
+	}
+	ret := []interface{}{}
+	for _, v := range hits {
+		doc, has := by_id[v.Id]
+		if !has { // Stale index entry.
+			continue
+		}
+		doc["snippet"] = template.HTML(v.Snippet)
+		doc["score"] = v.Score
+		ret = append(ret, doc)
+	}
+	return ret, count, nil
+}
+
+func SearchFacets(db *mgo.Database, opt map[string]interface{}, q *SearchQuery) (Facets, error) {
+	ix, err := NewIndexer(db, opt)
+	if err != nil {
+		return nil, err
+	}
+	q.Langs = queryLangs(opt, q)
+	return ix.Facets(q)
+}
+
+// Mostly for in-development use, but also needed after changing the search options.
+// Iterates trough all contents in the contents collection, regenerates their fulltext field and rebuilds the search index.
+func RegenerateFulltext(db *mgo.Database, opt map[string]interface{}) error {
+	ix, err := NewIndexer(db, opt)
+	if err != nil {
+		return err
+	}
+	err = ix.Clear()
+	if err != nil {
+		return err
+	}
+	var doc struct {
+		Id bson.ObjectId `bson:"_id"`
+	}
+	iter := db.C(Cname).Find(nil).Select(m{"_id": 1}).Iter()
+	for iter.Next(&doc) {
+		err = saveFulltext(db, doc.Id)
+		if err != nil {
+			return err
+		}
+		err = IndexContent(db, ix, opt, doc.Id)
+		if err != nil {
+			return err
+		}
+	}
+	return iter.Err()
+}
@@ -0,0 +1,62 @@
+package content_model
+
+import (
+	"labix.org/v2/mgo/bson"
+	"strings"
+	"testing"
+)
+
+func TestIndexedFields(t *testing.T) {
+	tag_id := bson.NewObjectId()
+	content := map[string]interface{}{
+		"title":       "Hello",
+		"content":     "World",
+		"_users_x":    "hidden",
+		Tag_fieldname: []interface{}{map[string]interface{}{"_id": tag_id, "name": "golang"}},
+	}
+	f := indexedFields(content)
+	if f["title"] != "Hello" || f["content"] != "World" || strings.TrimSpace(f[Tag_fieldname_displayed]) != "golang" {
+		t.Fatal(f)
+	}
+	if _, has := f["_users_x"]; has {
+		t.Fatal("Underscored fields should not be indexed.")
+	}
+	if ids := tagIds(content[Tag_fieldname]); len(ids) != 1 || ids[0] != tag_id {
+		t.Fatal(ids)
+	}
+}
+
+func TestHighlight(t *testing.T) {
+	an := AnalyzerOf("en")
+	terms := map[string]struct{}{}
+	for _, v := range an.Terms("search") {
+		terms[v] = struct{}{}
+	}
+	h := Highlight("A <b>search</b> engine", an, terms, 200)
+	if !strings.Contains(h, "<b>search</b>") || !strings.Contains(h, "&lt;b&gt;") {
+		t.Fatal(h)
+	}
+	if h := Highlight("Nothing here", an, terms, 200); strings.Contains(h, "<b>") {
+		t.Fatal(h)
+	}
+}
+
+func TestAnalyzeQuery(t *testing.T) {
+	q := &SearchQuery{Text: "the houses", Langs: []string{"en", "de"}}
+	terms := analyzeQuery(q)
+	if len(terms) != 2 || len(terms["en"]) != 1 || terms["en"][0] != AnalyzerOf("en").Terms("houses")[0] {
+		t.Fatal(terms)
+	}
+	match := termsMatch(terms)
+	or, ok := match["$or"].([]m)
+	if !ok || len(or) != 2 || or[0]["lang"] != "de" || or[1]["lang"] != "en" {
+		t.Fatal("Every language should match only its own contents:", match)
+	}
+	single := termsMatch(analyzeQuery(&SearchQuery{Text: "houses", Lang: "en"}))
+	if _, has := single["$or"]; has || single["terms.t"] == nil {
+		t.Fatal(single)
+	}
+	if terms := analyzeQuery(&SearchQuery{Text: "the", Lang: "en"}); len(terms) != 0 {
+		t.Fatal("Stop words only, nothing to search:", terms)
+	}
+}
@@ -0,0 +1,111 @@
+package content_model
+
+import (
+	"fmt"
+	"github.com/opesun/hypecms/model/scut"
+	"github.com/opesun/hypecms/modules/display/model"
+	"github.com/opesun/hypecms/modules/media/model"
+	"github.com/opesun/jsonp"
+	"regexp"
+	"strings"
+)
+
+// Every content type has SEO fields unless "seo": false is set in its options. All of them are optional, defaults are generated when they are empty.
+const (
+	Seo_title_fieldname       = "seo_title"
+	Seo_description_fieldname = "seo_description"
+	Noindex_fieldname         = "noindex"
+	Canonical_fieldname       = "canonical"
+	Social_image_fieldname    = "social_image"
+)
+
+// Length of the generated descriptions, search engines cut them around this anyway.
+const Description_length = 160
+
+func HasSeo(type_opt map[string]interface{}) bool {
+	seo, has := type_opt["seo"].(bool)
+	return !has || seo
+}
+
+func seoFields() []*Field {
+	return []*Field{
+		{Name: Seo_title_fieldname, Type: Text, Label: "SEO title", MaxLength: 120},
+		{Name: Seo_description_fieldname, Type: Text, Label: "SEO description", MaxLength: 300},
+		{Name: Noindex_fieldname, Type: Boolean, Label: "hide from search engines"},
+		{Name: Canonical_fieldname, Type: Text, Label: "canonical url"},
+		{Name: Social_image_fieldname, Type: Media, Label: "social image"},
+	}
+}
+
+// Canonical urls set by hand must be absolute, or a path on the site.
+func checkCanonical(dat map[string]interface{}) error {
+	c, _ := dat[Canonical_fieldname].(string)
+	if len(c) == 0 || strings.HasPrefix(c, "/") || strings.HasPrefix(c, "http://") || strings.HasPrefix(c, "https://") {
+		return nil
+	}
+	return fmt.Errorf("Canonical url must start with http://, https:// or /.")
+}
+
+func Noindex(content map[string]interface{}) bool {
+	n, _ := content[Noindex_fieldname].(bool)
+	return n
+}
+
+var html_tag_rx = regexp.MustCompile(`<[^>]*>`)
+
+func plainText(html string) string {
+	return strings.Join(strings.Fields(html_tag_rx.ReplaceAllString(html, " ")), " ")
+}
+
+// Url of the first media document referenced by a resolved media field.
+func mediaUrl(v interface{}) string {
+	if sl, ok := v.([]interface{}); ok {
+		if len(sl) == 0 {
+			return ""
+		}
+		v = sl[0]
+	}
+	u, _ := jsonp.GetStr(v, "url")
+	return u
+}
+
+func absolute(base, url string) string {
+	if len(url) == 0 || strings.HasPrefix(url, "http://") || strings.HasPrefix(url, "https://") {
+		return url
+	}
+	return base + url
+}
+
+// Collects the metadata of a content for the seo_tags template builtin: "title", "description", "noindex", "canonical", "image",
+// "type" and "site_name". The content must be resolved already, so the social image is a media document.
+// host is the host of the request, the canonical url uses the canonical host of the site if it has one.
+func Seo(opt map[string]interface{}, host string, content map[string]interface{}) map[string]interface{} {
+	base := "http://" + scut.Host(host, opt)
+	title, _ := content[Seo_title_fieldname].(string)
+	if len(title) == 0 {
+		title, _ = content["title"].(string)
+	}
+	desc, _ := content[Seo_description_fieldname].(string)
+	if len(desc) == 0 {
+		text, _ := content["content"].(string)
+		text = plainText(text)
+		desc = display_model.Excerpt(text, Description_length)
+		if len(desc) < len(text) {
+			desc += "..."
+		}
+	}
+	canonical, _ := content[Canonical_fieldname].(string)
+	if len(canonical) == 0 {
+		canonical = UrlOf(content)
+	}
+	site_name, _ := jsonp.GetStr(opt, "site_name")
+	return map[string]interface{}{
+		"title":       title,
+		"description": desc,
+		"noindex":     Noindex(content),
+		"canonical":   absolute(base, canonical),
+		"image":       absolute(base, mediaUrl(content[media_model.Field_prefix+Social_image_fieldname])),
+		"type":        "article",
+		"site_name":   site_name,
+	}
+}
@@ -202,17 +202,47 @@ func PullTags(db *mgo.Database, content_id string, tag_ids []string) error {
 	return db.C(Cname).Update(q, upd)
 }
 
-// Deletes a tag entirely.
-func DeleteTag(db *mgo.Database, tag_id string) error {
-	err := patterns.DeleteById(db, Tag_cname, tag_id)
+// Deletes a tag entirely. Its children are moved up to its parent.
+func DeleteTag(db *mgo.Database, opt map[string]interface{}, tag_id string) error {
+	tag, err := findTagById(db, tag_id)
 	if err != nil {
 		return err
 	}
-	return PullTagFromAll(db, tag_id)
+	id := tag["_id"].(bson.ObjectId)
+	var parent_id string
+	if par, ok := tag[Parent_fieldname].(bson.ObjectId); ok {
+		parent_id = par.Hex()
+	}
+	var children []struct {
+		Id bson.ObjectId `bson:"_id"`
+	}
+	err = db.C(Tag_cname).Find(m{Parent_fieldname: id}).Select(m{"_id": 1}).All(&children)
+	if err != nil {
+		return err
+	}
+	for _, v := range children {
+		_, err = setTagParent(db, v.Id.Hex(), parent_id)
+		if err != nil {
+			return err
+		}
+	}
+	tagged, err := taggedContents(db, []bson.ObjectId{id})
+	if err != nil {
+		return err
+	}
+	err = patterns.DeleteById(db, Tag_cname, tag_id)
+	if err != nil {
+		return err
+	}
+	err = PullTagFromAll(db, tag_id)
+	if err != nil {
+		return err
+	}
+	return reindex(db, opt, tagged)
 }
 
 func PullTagFromAll(db *mgo.Database, tag_id string) error {
-	return patterns.PullFromAll(db, Tag_cname, Tag_fieldname, patterns.ToIdWithCare(tag_id))
+	return patterns.PullFromAll(db, Cname, Tag_fieldname, patterns.ToIdWithCare(tag_id))
 }
 
 // Finds tag by query m{field: value}.
@@ -230,6 +260,273 @@ func FindTag(db *mgo.Database, field string, value interface{}) (map[string]inte
 
 func TagSearchQuery(fieldname, val string) map[string]interface{} {
 	return map[string]interface{}{
-		fieldname: bson.RegEx{"^" + val, "u"},
+		fieldname: bson.RegEx{Pattern: "^" + val, Options: "u"},
+	}
+}
+
+// Tags can be nested. A tag stores its parent and all of its ancestors (root first), so
+// the descendants of a tag can be found with one query: {"_tags_ancestors": tag_id}.
+const (
+	Parent_fieldname    = "_tags_parent"
+	Ancestors_fieldname = "_tags_ancestors"
+)
+
+func findTagById(db *mgo.Database, tag_id string) (map[string]interface{}, error) {
+	tag, err := patterns.FindEq(db, Tag_cname, "_id", tag_id)
+	if err != nil {
+		return nil, fmt.Errorf("Can't find tag %v.", tag_id)
+	}
+	return tag, nil
+}
+
+func ancestorsOf(tag map[string]interface{}) []bson.ObjectId {
+	anc, ok := tag[Ancestors_fieldname].([]interface{})
+	if !ok {
+		return []bson.ObjectId{}
+	}
+	return toIdSlice(anc)
+}
+
+func containsId(ids []bson.ObjectId, id bson.ObjectId) bool {
+	for _, v := range ids {
+		if v == id {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the id of the tag and the ids of all of its descendants.
+func TagAndDescendants(db *mgo.Database, tag_id bson.ObjectId) ([]bson.ObjectId, error) {
+	var res []struct {
+		Id bson.ObjectId `bson:"_id"`
+	}
+	err := db.C(Tag_cname).Find(m{Ancestors_fieldname: tag_id}).Select(m{"_id": 1}).All(&res)
+	if err != nil {
+		return nil, err
+	}
+	ret := []bson.ObjectId{tag_id}
+	for _, v := range res {
+		ret = append(ret, v.Id)
+	}
+	return ret, nil
+}
+
+func taggedContents(db *mgo.Database, tag_ids []bson.ObjectId) ([]bson.ObjectId, error) {
+	var res []struct {
+		Id bson.ObjectId `bson:"_id"`
+	}
+	err := db.C(Cname).Find(m{Tag_fieldname: m{"$in": tag_ids}}).Select(m{"_id": 1}).All(&res)
+	if err != nil {
+		return nil, err
+	}
+	ret := []bson.ObjectId{}
+	for _, v := range res {
+		ret = append(ret, v.Id)
+	}
+	return ret, nil
+}
+
+func reindex(db *mgo.Database, opt map[string]interface{}, content_ids []bson.ObjectId) error {
+	ix, err := NewIndexer(db, opt)
+	if err != nil {
+		return err
+	}
+	for _, v := range content_ids {
+		err = IndexContent(db, ix, opt, v)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Reindexes the contents having any of the tags, the search index holds the ids and the names of the tags of a content.
+func reindexTagged(db *mgo.Database, opt map[string]interface{}, tag_ids []bson.ObjectId) error {
+	ids, err := taggedContents(db, tag_ids)
+	if err != nil {
+		return err
+	}
+	return reindex(db, opt, ids)
+}
+
+// Moves a tag (with all of its descendants) under an other tag. An empty parent_id makes the tag a root tag.
+func SetTagParent(db *mgo.Database, opt map[string]interface{}, tag_id, parent_id string) error {
+	id, err := setTagParent(db, tag_id, parent_id)
+	if err != nil {
+		return err
+	}
+	moved, err := TagAndDescendants(db, id)
+	if err != nil {
+		return err
+	}
+	return reindexTagged(db, opt, moved)
+}
+
+func setTagParent(db *mgo.Database, tag_id, parent_id string) (bson.ObjectId, error) {
+	tag, err := findTagById(db, tag_id)
+	if err != nil {
+		return "", err
+	}
+	id := tag["_id"].(bson.ObjectId)
+	ancestors := []bson.ObjectId{}
+	var parent interface{}
+	if len(parent_id) > 0 {
+		par, err := findTagById(db, parent_id)
+		if err != nil {
+			return "", err
+		}
+		par_id := par["_id"].(bson.ObjectId)
+		if par_id == id {
+			return "", fmt.Errorf("A tag can't be its own parent.")
+		}
+		ancestors = append(ancestorsOf(par), par_id)
+		if containsId(ancestors, id) {
+			return "", fmt.Errorf("A tag can't be moved under its own descendant.")
+		}
+		parent = par_id
+	}
+	old_ancestors := ancestorsOf(tag)
+	upd := m{"$set": m{Parent_fieldname: parent, Ancestors_fieldname: ancestors}}
+	err = db.C(Tag_cname).Update(m{"_id": id}, upd)
+	if err != nil {
+		return "", err
+	}
+	// The descendants keep their path below the moved tag, only the part above it changes.
+	var descendants []interface{}
+	err = db.C(Tag_cname).Find(m{Ancestors_fieldname: id}).All(&descendants)
+	if err != nil {
+		return "", err
+	}
+	descendants = basic.Convert(descendants).([]interface{})
+	for _, v := range descendants {
+		desc := v.(map[string]interface{})
+		desc_anc := ancestorsOf(desc)
+		if len(desc_anc) < len(old_ancestors) { // Should not happen.
+			continue
+		}
+		below := desc_anc[len(old_ancestors):]
+		new_anc := append(append([]bson.ObjectId{}, ancestors...), below...)
+		err = db.C(Tag_cname).Update(m{"_id": desc["_id"]}, m{"$set": m{Ancestors_fieldname: new_anc}})
+		if err != nil {
+			return "", err
+		}
+	}
+	return id, nil
+}
+
+// Renames a tag, its slug is regenerated too.
+// Fails if an other tag already has the new slug, those two should be merged instead.
+func RenameTag(db *mgo.Database, opt map[string]interface{}, tag_id, name string) error {
+	name = strings.Trim(name, " ")
+	if len(name) == 0 {
+		return fmt.Errorf("Tag name can't be empty.")
+	}
+	tag, err := findTagById(db, tag_id)
+	if err != nil {
+		return err
+	}
+	id := tag["_id"].(bson.ObjectId)
+	slug := slugify.S(name)
+	count, err := db.C(Tag_cname).Find(m{"slug": slug, "_id": m{"$ne": id}}).Count()
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return fmt.Errorf("Tag with slug %v already exists, merge them instead.", slug)
+	}
+	err = db.C(Tag_cname).Update(m{"_id": id}, m{"$set": m{"name": name, "slug": slug}})
+	if err != nil {
+		return err
+	}
+	return reindexTagged(db, opt, []bson.ObjectId{id})
+}
+
+// Recomputes the overall and the per type content counters of a tag.
+func RecountTag(db *mgo.Database, tag_id bson.ObjectId) error {
+	pipe := []m{
+		{"$match": m{Tag_fieldname: tag_id}},
+		{"$group": m{"_id": "$type", "count": m{"$sum": 1}}},
+	}
+	var res []struct {
+		Type  string `bson:"_id"`
+		Count int    `bson:"count"`
+	}
+	err := db.C(Cname).Pipe(pipe).All(&res)
+	if err != nil {
+		return err
+	}
+	tag, err := findTagById(db, tag_id.Hex())
+	if err != nil {
+		return err
+	}
+	set := m{}
+	// Zero out the counters of types which no longer have contents with this tag.
+	for i, _ := range tag {
+		if strings.HasSuffix(i, "_"+Count_fieldname) {
+			set[i] = 0
+		}
+	}
+	all := 0
+	for _, v := range res {
+		set[v.Type+"_"+Count_fieldname] = v.Count
+		all += v.Count
+	}
+	set[Count_fieldname] = all
+	return db.C(Tag_cname).Update(m{"_id": tag_id}, m{"$set": set})
+}
+
+// Merges tag "from" into tag "into": contents of "from" will be tagged with "into", the children of "from"
+// are moved under "into", then "from" is deleted.
+func MergeTags(db *mgo.Database, opt map[string]interface{}, from_id, into_id string) error {
+	from, err := findTagById(db, from_id)
+	if err != nil {
+		return err
+	}
+	into, err := findTagById(db, into_id)
+	if err != nil {
+		return err
+	}
+	from_oid := from["_id"].(bson.ObjectId)
+	into_oid := into["_id"].(bson.ObjectId)
+	if from_oid == into_oid {
+		return fmt.Errorf("Can't merge a tag into itself.")
+	}
+	if containsId(ancestorsOf(into), from_oid) {
+		return fmt.Errorf("Can't merge a tag into its own descendant.")
+	}
+	_, err = db.C(Cname).UpdateAll(m{Tag_fieldname: from_oid}, m{"$addToSet": m{Tag_fieldname: into_oid}})
+	if err != nil {
+		return err
+	}
+	_, err = db.C(Cname).UpdateAll(m{Tag_fieldname: from_oid}, m{"$pull": m{Tag_fieldname: from_oid}})
+	if err != nil {
+		return err
+	}
+	var children []struct {
+		Id bson.ObjectId `bson:"_id"`
+	}
+	err = db.C(Tag_cname).Find(m{Parent_fieldname: from_oid}).Select(m{"_id": 1}).All(&children)
+	if err != nil {
+		return err
+	}
+	for _, v := range children {
+		_, err = setTagParent(db, v.Id.Hex(), into_oid.Hex())
+		if err != nil {
+			return err
+		}
+	}
+	err = db.C(Tag_cname).Remove(m{"_id": from_oid})
+	if err != nil {
+		return err
+	}
+	err = RecountTag(db, into_oid)
+	if err != nil {
+		return err
+	}
+	merged, err := TagAndDescendants(db, into_oid)
+	if err != nil {
+		return err
 	}
+	return reindexTagged(db, opt, merged)
 }
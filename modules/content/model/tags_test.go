@@ -4,50 +4,59 @@ import (
 	"labix.org/v2/mgo"
 	"labix.org/v2/mgo/bson"
 	"testing"
+	"time"
 )
 
 type Event struct {
 }
 
-func (e Event) Param(params ...interface{}) {
+func (e Event) Trigger(eventname string, params ...interface{}) {
 }
 
-func (e Event) TriggerAll(eventnames ...string) {
+func (e Event) Iterate(eventname string, stopfunc interface{}, params ...interface{}) {
 }
 
-func (e Event) Trigger(eventname string, params ...interface{}) {
+// These tests need a running MongoDB, they are skipped without one.
+func testDb(t *testing.T, name string) *mgo.Database {
+	session, err := mgo.DialWithTimeout("127.0.0.1", time.Second)
+	if err != nil {
+		t.Skip("Cant connect to db.")
+	}
+	db := session.DB(name)
+	if err := db.DropDatabase(); err != nil {
+		t.Fatal(err)
+	}
+	return db
 }
 
 func TestTags(t *testing.T) {
-	session, err := mgo.Dial("127.0.0.1")
-	if err != nil {
-		t.Fatal("Cant connect to db.")
-	}
-	db := session.DB("tags_test")
+	db := testDb(t, "tags_test")
+	defer db.Session.Close()
 	ev := &Event{}
-	rule := map[string]interface{}{
-		tag_fieldname_displayed: 1,
-		"type":                  1,
+	type_opt := map[string]interface{}{
+		"rules": map[string]interface{}{
+			Tag_fieldname_displayed: 1,
+			"type":                  1,
+		},
 	}
 	dat := map[string][]string{
-		tag_fieldname_displayed: {"lol, lal, lol, lal"},
+		Tag_fieldname_displayed: {"lol, lal, lol, lal"},
 		"type":                  {"blog"},
+		"draft_id":              {""},
 	}
 	uid := bson.NewObjectId()
-	// id := bson.NewObjectId()
-	// dat["_id"] = id
-	err = Insert(db, ev, rule, dat, uid)
+	_, err := Insert(db, ev, nil, type_opt, dat, uid)
 	if err != nil {
 		t.Fatal(err.Error())
 	}
 	var i []interface{}
-	db.C(collection_name).Find(nil).All(&i)
+	db.C(Tag_cname).Find(nil).All(&i)
 	if len(i) != 2 {
 		t.Fatal("Bad number of tags: ", len(i))
 	}
 	for _, v := range i {
 		val := v.(bson.M)
-		counter := val[count_fieldname].(int)
+		counter := val[Count_fieldname].(int)
 		if counter != 1 {
 			t.Fatal("Bad tag count: ", counter, " instead of 1.")
 		}
@@ -57,3 +66,65 @@ func TestTags(t *testing.T) {
 		t.Fatal("Cant drop database.")
 	}
 }
+
+func TestMergeRenameRecount(t *testing.T) {
+	db := testDb(t, "tags_merge_test")
+	defer db.Session.Close()
+	from, into, child := bson.NewObjectId(), bson.NewObjectId(), bson.NewObjectId()
+	tags := []interface{}{
+		m{"_id": from, "name": "Go", "slug": "go", Ancestors_fieldname: []bson.ObjectId{}},
+		m{"_id": into, "name": "Golang", "slug": "golang", Ancestors_fieldname: []bson.ObjectId{}},
+		m{"_id": child, "name": "Goroutines", "slug": "goroutines", Parent_fieldname: from, Ancestors_fieldname: []bson.ObjectId{from}},
+	}
+	if err := db.C(Tag_cname).Insert(tags...); err != nil {
+		t.Fatal(err)
+	}
+	c1, c2 := bson.NewObjectId(), bson.NewObjectId()
+	err := db.C(Cname).Insert(
+		m{"_id": c1, "type": "blog", "title": "First", Tag_fieldname: []bson.ObjectId{from}},
+		m{"_id": c2, "type": "page", "title": "Second", Tag_fieldname: []bson.ObjectId{from, into}},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := RecountTag(db, from); err != nil {
+		t.Fatal(err)
+	}
+	var tag bson.M
+	db.C(Tag_cname).FindId(from).One(&tag)
+	if tag[Count_fieldname] != 2 || tag["blog_"+Count_fieldname] != 1 || tag["page_"+Count_fieldname] != 1 {
+		t.Fatal(tag)
+	}
+	if err := MergeTags(db, nil, from.Hex(), into.Hex()); err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := db.C(Tag_cname).FindId(from).Count(); n != 0 {
+		t.Fatal("Merged tag should be deleted.")
+	}
+	if n, _ := db.C(Cname).Find(m{Tag_fieldname: into}).Count(); n != 2 {
+		t.Fatal("Contents should be tagged with the merged tag:", n)
+	}
+	db.C(Tag_cname).FindId(into).One(&tag)
+	if tag[Count_fieldname] != 2 {
+		t.Fatal(tag)
+	}
+	db.C(Tag_cname).FindId(child).One(&tag)
+	if tag[Parent_fieldname] != into {
+		t.Fatal("Children should be moved under the merged tag:", tag)
+	}
+	var entry bson.M
+	if err := db.C(Search_cname).FindId(c1).One(&entry); err != nil {
+		t.Fatal("Contents of a merged tag should be reindexed:", err)
+	}
+	if err := RenameTag(db, nil, into.Hex(), "Goroutines"); err == nil {
+		t.Fatal("Renaming to the slug of an other tag should fail.")
+	}
+	if err := RenameTag(db, nil, into.Hex(), "Go language"); err != nil {
+		t.Fatal(err)
+	}
+	db.C(Tag_cname).FindId(into).One(&tag)
+	if tag["name"] != "Go language" || tag["slug"] != "go-language" {
+		t.Fatal(tag)
+	}
+	db.DropDatabase()
+}
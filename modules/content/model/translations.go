@@ -0,0 +1,174 @@
+package content_model
+
+import (
+	"fmt"
+	"github.com/opesun/hypecms/model/basic"
+	"github.com/opesun/jsonp"
+	"labix.org/v2/mgo"
+	"labix.org/v2/mgo/bson"
+	"regexp"
+	"strings"
+)
+
+// Translations of a content share the same translation group, which is the id of the original.
+// Every translation has its own slug (and path if it is a page), so they have their own urls too.
+const (
+	Lang_fieldname              = "lang"
+	Translation_group_fieldname = "translation_group"
+)
+
+var lang_rx = regexp.MustCompile(`^[a-z]{2,3}(-[a-z0-9]{2,8})?$`)
+
+// Languages the contents can be written in, "Modules.content.languages". The first one is the default.
+func Languages(opt map[string]interface{}) []string {
+	langs, has := jsonp.GetS(opt, "Modules.content.languages")
+	if !has || len(langs) == 0 {
+		return []string{"en"}
+	}
+	return jsonp.ToStringSlice(langs)
+}
+
+func firstVal(form map[string][]string, key string) string {
+	if v, has := form[key]; has && len(v) > 0 {
+		return strings.TrimSpace(v[0])
+	}
+	return ""
+}
+
+// The group of the original, which can be a translation itself.
+func translationGroup(db *mgo.Database, original_id bson.ObjectId) (bson.ObjectId, error) {
+	var orig bson.M
+	err := db.C(Cname).Find(m{"_id": original_id}).Select(m{Translation_group_fieldname: 1}).One(&orig)
+	if err != nil {
+		return "", fmt.Errorf("Can't find the content to be translated.")
+	}
+	if group, ok := orig[Translation_group_fieldname].(bson.ObjectId); ok {
+		return group, nil
+	}
+	return original_id, nil
+}
+
+// Sets the language and the translation group of a content about to be saved, from the "lang" and "translation_of" form fields.
+// id is empty when inserting. A group can have only one content per language.
+func setLanguage(db *mgo.Database, id bson.ObjectId, form map[string][]string, dat map[string]interface{}) error {
+	lang := strings.ToLower(firstVal(form, "lang"))
+	if len(lang) > 0 {
+		if !lang_rx.MatchString(lang) {
+			return fmt.Errorf("%v is not a valid language code.", lang)
+		}
+		dat[Lang_fieldname] = lang
+	}
+	var group bson.ObjectId
+	if orig := firstVal(form, "translation_of"); len(orig) > 0 {
+		if len(lang) == 0 {
+			return fmt.Errorf("A translation must have a language.")
+		}
+		var err error
+		group, err = translationGroup(db, basic.ToIdWithCare(orig))
+		if err != nil {
+			return err
+		}
+		dat[Translation_group_fieldname] = group
+	} else if len(id) > 0 {
+		group, _ = translationGroup(db, id)
+	}
+	if len(group) == 0 || len(lang) == 0 {
+		return nil
+	}
+	q := m{
+		"$or":          []interface{}{m{Translation_group_fieldname: group}, m{"_id": group}},
+		Lang_fieldname: lang,
+	}
+	if len(id) > 0 {
+		q["_id"] = m{"$ne": id}
+	}
+	count, err := db.C(Cname).Find(q).Count()
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return fmt.Errorf("This content already has a translation in %v.", lang)
+	}
+	return nil
+}
+
+// Originals are the first members of their own group.
+func closeGroup(db *mgo.Database, id bson.ObjectId) error {
+	q := m{"_id": id, Translation_group_fieldname: m{"$exists": false}}
+	err := db.C(Cname).Update(q, m{"$set": m{Translation_group_fieldname: id}})
+	if err == mgo.ErrNotFound {
+		return nil
+	}
+	return err
+}
+
+// Returns all variants of a content (itself included), ordered by language.
+func Translations(db *mgo.Database, content map[string]interface{}) ([]map[string]interface{}, error) {
+	group, has := content[Translation_group_fieldname].(bson.ObjectId)
+	if !has {
+		return []map[string]interface{}{content}, nil
+	}
+	var res []interface{}
+	err := db.C(Cname).Find(m{Translation_group_fieldname: group}).Sort(Lang_fieldname).All(&res)
+	if err != nil {
+		return nil, err
+	}
+	ret := []map[string]interface{}{}
+	for _, v := range res {
+		ret = append(ret, basic.Convert(v).(map[string]interface{}))
+	}
+	return ret, nil
+}
+
+func langOf(content map[string]interface{}, default_lang string) string {
+	if l, ok := content[Lang_fieldname].(string); ok && len(l) > 0 {
+		return l
+	}
+	return default_lang
+}
+
+// Chooses the variant best matching the languages of the user ("de-at" matches "de" too).
+// Falls back to the default language, then to the content itself.
+func PickTranslation(variants []map[string]interface{}, current map[string]interface{}, user_langs []string, default_lang string) map[string]interface{} {
+	wanted := append(append([]string{}, user_langs...), default_lang)
+	for _, ul := range wanted {
+		ul = strings.ToLower(ul)
+		primary := strings.Split(ul, "-")[0]
+		for _, exact := range []bool{true, false} {
+			for _, v := range variants {
+				l := langOf(v, default_lang)
+				if l == ul || (!exact && strings.Split(l, "-")[0] == primary) {
+					return v
+				}
+			}
+		}
+	}
+	return current
+}
+
+// Url of a content: pages are accessed by their path, everything else by slug.
+func UrlOf(content map[string]interface{}) string {
+	if p, ok := content[Path_fieldname].(string); ok && len(p) > 0 {
+		return "/" + p
+	}
+	slug, _ := content["slug"].(string)
+	return "/" + slug
+}
+
+// Links to the language variants, to be displayed in language switchers and as alternate links in the head.
+// The language is in the url too, otherwise the variant matching the languages of the visitor would be displayed.
+func AlternateLinks(variants []map[string]interface{}, current map[string]interface{}, default_lang string) []map[string]interface{} {
+	ret := []map[string]interface{}{}
+	if len(variants) < 2 {
+		return ret
+	}
+	for _, v := range variants {
+		ret = append(ret, map[string]interface{}{
+			"lang":    langOf(v, default_lang),
+			"url":     UrlOf(v) + "?lang=" + langOf(v, default_lang),
+			"title":   v["title"],
+			"current": v["_id"] == current["_id"],
+		})
+	}
+	return ret
+}
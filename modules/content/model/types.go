@@ -0,0 +1,308 @@
+package content_model
+
+import (
+	"fmt"
+	"github.com/opesun/extract"
+	"github.com/opesun/hypecms/model/basic"
+	"github.com/opesun/jsonp"
+	"labix.org/v2/mgo"
+	"labix.org/v2/mgo/bson"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// The last underscore separates the type from the subtype in the admin ("blog_draft"), so type names can't have one.
+var type_name_rx = regexp.MustCompile(`^[a-z][a-z0-9]*$`)
+
+func CheckTypeName(name string) error {
+	if !type_name_rx.MatchString(name) {
+		return fmt.Errorf("Type name %v is invalid, it can contain only lowercase letters and digits, and must start with a letter.", name)
+	}
+	return nil
+}
+
+// Options of a freshly created content type.
+func DefaultTypeOptions() map[string]interface{} {
+	return m{
+		"rules": m{
+			"title":                 1,
+			"slug":                  1,
+			"content":               m{"sanitize": true},
+			Tag_fieldname_displayed: 1,
+			"fulltext":              false,
+			basic.Created:           false,
+			basic.Created_by:        false,
+			basic.Last_modified:     false,
+			basic.Last_modified_by:  false,
+		},
+		"comment_rules": m{
+			basic.Created:     false,
+			basic.Created_by:  false,
+			"comment_content": 1,
+		},
+		"non_versioned_fields": m{
+			"comment_count": 1,
+		},
+	}
+}
+
+// Default query listing the newest contents of a type at a display point.
+func DefaultTypeQuery(typ string) map[string]interface{} {
+	return m{
+		"c":  Cname,
+		"l":  10,
+		"q":  m{"type": typ},
+		"so": "-created",
+		"p":  "page",
+	}
+}
+
+// Creates a new content type with the default options, and lists it on the index page.
+func NewType(db *mgo.Database, opt map[string]interface{}, inp map[string][]string) error {
+	rule := map[string]interface{}{
+		"type": "must",
+	}
+	dat, err := extract.New(rule).Extract(inp)
+	if err != nil {
+		return err
+	}
+	typ := dat["type"].(string)
+	err = CheckTypeName(typ)
+	if err != nil {
+		return err
+	}
+	if _, exists := jsonp.Get(opt, "Modules.content.types."+typ); exists {
+		return fmt.Errorf("Content type %v already exists.", typ)
+	}
+	id := basic.CreateOptCopy(db)
+	upd := m{
+		"$set": m{
+			"Modules.content.types." + typ:        DefaultTypeOptions(),
+			"Display-points.index.queries." + typ: DefaultTypeQuery(typ),
+		},
+	}
+	return db.C("options").Update(m{"_id": id}, upd)
+}
+
+func decodeMap(name, s string) (map[string]interface{}, error) {
+	if len(strings.TrimSpace(s)) == 0 {
+		return nil, nil
+	}
+	v, err := jsonp.Decode(s)
+	if err != nil {
+		return nil, fmt.Errorf("%v is not valid JSON: %v", name, err)
+	}
+	vm, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%v must be a JSON object.", name)
+	}
+	return vm, nil
+}
+
+// Same as in display_editor.
+func checkQuery(point string, q interface{}) error {
+	qm, ok := q.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("Query of display point %v is not an object.", point)
+	}
+	for i, _ := range qm {
+		switch i {
+		case "c", "q", "sk", "l", "so", "p", "ex", "r", "rev":
+		default:
+			return fmt.Errorf("Nonsensical field %v in query of display point %v.", i, point)
+		}
+	}
+	return nil
+}
+
+// Query of the given type at every display point, display point name -> query.
+func TypeQueries(opt map[string]interface{}, typ string) map[string]interface{} {
+	ret := map[string]interface{}{}
+	points, _ := jsonp.GetM(opt, "Display-points")
+	for name, v := range points {
+		if q, has := jsonp.Get(v, "queries."+typ); has {
+			ret[name] = q
+		}
+	}
+	return ret
+}
+
+// Saves the options of a content type. Every JSON field replaces the corresponding option as a whole,
+// options not present on the form (for example "search") are left untouched.
+// Example input:
+//
+//	type=blog
+//	rules={"title": 1, "content": 1}
+//	fields=[{"name": "price", "type": "number"}]
+//	comment_rules={"comment_content": 1}
+//	non_versioned_fields=comment_count, views
+//	actions={"insert_comment": {"auth": {"min_lev": 0}}}
+//	moderate_comment=on
+//	tree=on
+//	seo=on
+//	draft_level=300
+//	comments_per_page=50
+//	queries={"index": {"c": "contents", "q": {"type": "blog"}, "l": 10}}
+func SaveTypeConfig(db *mgo.Database, opt map[string]interface{}, inp map[string][]string) error {
+	rule := map[string]interface{}{
+		"type":                 "must",
+		"rules":                1,
+		"fields":               1,
+		"comment_rules":        1,
+		"non_versioned_fields": 1,
+		"actions":              1,
+		"moderate_comment":     1,
+		"tree":                 1,
+		"seo":                  1,
+		"draft_level":          1,
+		"comments_per_page":    1,
+		"queries":              1,
+	}
+	dat, err := extract.New(rule).Extract(inp)
+	if err != nil {
+		return err
+	}
+	typ := dat["type"].(string)
+	if _, exists := jsonp.Get(opt, "Modules.content.types."+typ); !exists {
+		return fmt.Errorf("Can't find content type %v.", typ)
+	}
+	str := func(key string) string {
+		s, _ := dat[key].(string)
+		return s
+	}
+	set := m{}
+	unset := m{}
+	prefix := "Modules.content.types." + typ + "."
+	type_opt := m{}
+	for _, key := range []string{"rules", "comment_rules", "actions"} {
+		val, err := decodeMap(key, str(key))
+		if err != nil {
+			return err
+		}
+		if val == nil {
+			unset[prefix+key] = 1
+		} else {
+			set[prefix+key] = val
+			type_opt[key] = val
+		}
+	}
+	if len(strings.TrimSpace(str("fields"))) > 0 {
+		fields, err := jsonp.Decode(str("fields"))
+		if err != nil {
+			return fmt.Errorf("fields is not valid JSON: %v", err)
+		}
+		set[prefix+"fields"] = fields
+		type_opt["fields"] = fields
+	} else {
+		unset[prefix+"fields"] = 1
+	}
+	nvf := m{}
+	for _, v := range strings.Split(str("non_versioned_fields"), ",") {
+		if v = strings.TrimSpace(v); len(v) > 0 {
+			nvf[v] = 1
+		}
+	}
+	set[prefix+"non_versioned_fields"] = nvf
+	set[prefix+"moderate_comment"] = len(str("moderate_comment")) > 0
+	set[prefix+"tree"] = len(str("tree")) > 0
+	type_opt["tree"] = set[prefix+"tree"]
+	set[prefix+"seo"] = len(str("seo")) > 0
+	type_opt["seo"] = set[prefix+"seo"]
+	_, _, err = TypeRules(type_opt) // Validates the fields too.
+	if err != nil {
+		return err
+	}
+	for _, key := range []string{"draft_level", "comments_per_page"} {
+		s := strings.TrimSpace(str(key))
+		if len(s) == 0 {
+			unset[prefix+key] = 1
+			continue
+		}
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			return fmt.Errorf("%v must be a positive number.", key)
+		}
+		set[prefix+key] = int64(n)
+	}
+	queries, err := decodeMap("queries", str("queries"))
+	if err != nil {
+		return err
+	}
+	for point, q := range queries {
+		if err := checkQuery(point, q); err != nil {
+			return err
+		}
+		set["Display-points."+point+".queries."+typ] = q
+	}
+	for point, _ := range TypeQueries(opt, typ) {
+		if _, kept := queries[point]; !kept {
+			unset["Display-points."+point+".queries."+typ] = 1
+		}
+	}
+	upd := m{"$set": set}
+	if len(unset) > 0 {
+		upd["$unset"] = unset
+	}
+	id := basic.CreateOptCopy(db)
+	return db.C("options").Update(m{"_id": id}, upd)
+}
+
+// Deletes a content type, but only if it has no contents (or drafts) anymore.
+func DeleteType(db *mgo.Database, opt map[string]interface{}, inp map[string][]string) error {
+	rule := map[string]interface{}{
+		"type": "must",
+	}
+	dat, err := extract.New(rule).Extract(inp)
+	if err != nil {
+		return err
+	}
+	typ := dat["type"].(string)
+	if _, exists := jsonp.Get(opt, "Modules.content.types."+typ); !exists {
+		return fmt.Errorf("Can't find content type %v.", typ)
+	}
+	for _, coll := range []string{Cname, Cname + Draft_collection_postfix} {
+		count, err := db.C(coll).Find(m{"type": typ}).Count()
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			return fmt.Errorf("Can't delete content type %v: it still has %v documents in %v.", typ, count, coll)
+		}
+	}
+	unset := m{
+		"Modules.content.types." + typ: 1,
+	}
+	for point, _ := range TypeQueries(opt, typ) {
+		unset["Display-points."+point+".queries."+typ] = 1
+	}
+	id := basic.CreateOptCopy(db)
+	return db.C("options").Update(m{"_id": id}, m{"$unset": unset})
+}
+
+// Saves the preferences of a given user regarding a content type, they are stored in the user document under "content_options.<type>".
+func SavePersonalTypeConfig(db *mgo.Database, opt map[string]interface{}, inp map[string][]string, user_id bson.ObjectId) error {
+	rule := map[string]interface{}{
+		"type":                    "must",
+		"safe_delete_content":     1,
+		"safe_delete_tag_editor":  1,
+		"safe_delete_in_tag_list": 1,
+	}
+	dat, err := extract.New(rule).Extract(inp)
+	if err != nil {
+		return err
+	}
+	typ := dat["type"].(string)
+	if _, exists := jsonp.Get(opt, "Modules.content.types."+typ); !exists {
+		return fmt.Errorf("Can't find content type %v.", typ)
+	}
+	prefs := m{}
+	for i, _ := range rule {
+		if i == "type" {
+			continue
+		}
+		_, checked := dat[i]
+		prefs[i] = checked
+	}
+	return db.C("users").Update(m{"_id": user_id}, m{"$set": m{"content_options." + typ: prefs}})
+}
@@ -4,7 +4,7 @@ Comments:<br />
 {{$con := .content}}
 {{if .content.comments}}
 	{{range .content.comments}}
-		{{.comment_content}} <a href="/b/content/delete_comment?type={{$con.type}}&content_id={{$con._id}}&comment_id={{.comment_id}}">Del</a><br />
+		{{.comment_content}} <a href="/b/content/delete_comment?type={{$con.type}}&content_id={{$con._id}}&comment_id={{._id}}">Del</a><br />
 	{{end}}
 {{else}}
 	No comments yet.<br />
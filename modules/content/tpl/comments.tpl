@@ -7,8 +7,10 @@
 		<div>
 			{{if .in_moderation}}
 				<span>Awaiting moderation</span>
-				{{.content}}
-				{{.created_by.guest_name}}
+				{{.comment_content}}
+				{{._users_created_by.name}}
+				<a href="/b/content/move_to_final?comment_id={{._id}}">Approve</a>
+				<a href="/b/content/delete_comment?type={{.content_type}}&content_id={{if is_map ._contents_parent}}{{._contents_parent._id}}{{else}}{{._contents_parent}}{{end}}&comment_id={{._id}}">Delete</a>
 			{{else}}
 				Comment:<br />
 				{{if is_map ._contents_parent}}
@@ -21,9 +23,13 @@
 		</div>
 		<br />
 	{{end}}
+	{{$navi := .comment_list_navi}}
+	{{require admin/navi.t}}
 {{else}}
 	No comments yet.
 {{end}}
+<br />
+<a href="/b/content/migrate_comments">Move old embedded comments to the comments collection</a>
 
 
 {{require content/footer.t}}
@@ -1,5 +1,11 @@
 {{require admin/header.t}}
 {{require content/sidebar.t}}
-Yo, this is it here, content admin index.
+Yo, this is it here, content admin index.<br />
+<br />
+<a href="/b/content/regenerate_fulltext">Rebuild the search index</a><br />
+<br />
+<form action="/b/content/new_type" method="post">
+	New content type: <input name="type" type="text" /> <input type="submit" value="Create">
+</form>
 {{require content/footer.t}}
 {{require admin/footer.t}}
\ No newline at end of file
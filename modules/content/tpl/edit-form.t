@@ -8,6 +8,13 @@ $(".html-editor").each(function(index, elem) {
 	new nicEditor({fullPanel : true, iconsPath : '/shared/nicEdit/nicEditorIcons.gif'}).panelInstance(id)
 })
 
+// The last row of a group is always empty, adding a row clones it.
+$(".add-row").on("click", function(){
+	var last = $(this).prev(".field-group").children(".field-group-row").last()
+	last.clone().insertAfter(last).find("input, textarea").val("")
+	return false
+})
+
 // Hack to overwrite default styling for nicEdit. This allows one to resize popup windows properly.
 $(".nicEdit-button").on("click", function(){
 	$("#code").css("margin-right", "10px")
@@ -40,12 +47,30 @@ $(".nicEdit-button").on("click", function(){
 <form action="/b/content/{{.op}}" method="post" id="edit-form">
 {{$content := .content}}
 {{range .fields}}
+	{{if .typed}}
+		{{.label}}{{if .required}} *{{end}}<br />
+		{{if .group}}
+			<div class="field-group" data-group="{{.name}}">
+			{{range .rows}}
+				<div class="field-group-row">
+				{{range .}}
+					{{.label}}: {{require content/field.t}}
+				{{end}}
+				</div>
+			{{end}}
+			</div>
+			<a href="#" class="add-row">Add row</a><br />
+		{{else}}
+			{{require content/field.t}}<br />
+		{{end}}
+	{{else}}
 	{{.key}}<br />
 	{{if eq .key "content"}}
 		<textarea id="{{.key}}-field" name="content" class="html-editor">{{.value}}</textarea>
 	{{else}}
 		<input name="{{.key}}" value="{{.value}}" type="text" /><br />
 	{{end}}
+	{{end}}
 	<br />
 	{{if .tags}}
 		<script src="/tpl/content/tag_finder.js"></script>
@@ -83,6 +108,17 @@ $(".nicEdit-button").on("click", function(){
 		{{end}}
 	{{end}}
 {{end}}
+{{if .languages}}
+	{{$lang := .lang}}
+	language<br />
+	<select name="lang">
+		{{range .languages}}<option value="{{.}}"{{if eq . $lang}} selected="selected"{{end}}>{{.}}</option>{{end}}
+	</select><br />
+	<br />
+{{end}}
+{{if .translation_of}}
+	<input type="hidden" name="translation_of" value="{{.translation_of}}" />
+{{end}}
 <input type="hidden" name="type" value="{{.type}}" />
 <input type="hidden" name="draft_id" value="{{if .is_draft}}{{.draft._id}}{{end}}" />
 <input type="hidden" name="id" value="{{if .is_draft}}{{.draft.draft_of}}{{else}}{{$content._id}}{{end}}" />
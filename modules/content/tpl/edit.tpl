@@ -19,6 +19,19 @@
 {{require content/edit-form.t}}
 
 <br />
+{{if .translations}}
+	Translations: {{range .translations}}<a href="/admin/content/edit?type={{.type}}&id={{._id}}">{{.lang}}</a> {{end}}<br />
+	<br />
+{{end}}
+{{if .missing_languages}}
+	{{$content := .content}}
+	Translate to: {{range .missing_languages}}<a href="/admin/content/edit?type={{$content.type}}&translation_of={{$content._id}}&lang={{.}}">{{.}}</a> {{end}}<br />
+	<br />
+{{end}}
+{{if .slug_history}}
+	Old slugs, they are redirected here: {{range .slug_history}}/{{.}} {{end}}<br />
+	<br />
+{{end}}
 
 <script src="/shared/arborjs/lib/arbor.js"></script>
 <script src="/shared/arborjs/lib/arbor-tween.js"></script>
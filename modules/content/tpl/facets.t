@@ -0,0 +1,34 @@
+<div class="facets">
+	{{if $facets.type}}
+		<h4>Types</h4>
+		<ul>
+		{{range $facets.type}}
+			<li><a href="{{.Url}}">{{if .Selected}}<b>{{.Label}}</b>{{else}}{{.Label}}{{end}}</a> ({{.Count}})</li>
+		{{end}}
+		</ul>
+	{{end}}
+	{{if $facets.tag}}
+		<h4>Tags</h4>
+		<ul>
+		{{range $facets.tag}}
+			<li><a href="{{.Url}}">{{if .Selected}}<b>{{.Label}}</b>{{else}}{{.Label}}{{end}}</a> ({{.Count}})</li>
+		{{end}}
+		</ul>
+	{{end}}
+	{{if $facets.author}}
+		<h4>Authors</h4>
+		<ul>
+		{{range $facets.author}}
+			<li><a href="{{.Url}}">{{if .Selected}}<b>{{.Label}}</b>{{else}}{{.Label}}{{end}}</a> ({{.Count}})</li>
+		{{end}}
+		</ul>
+	{{end}}
+	{{if $facets.month}}
+		<h4>Months</h4>
+		<ul>
+		{{range $facets.month}}
+			<li><a href="{{.Url}}">{{if .Selected}}<b>{{.Label}}</b>{{else}}{{.Label}}{{end}}</a> ({{.Count}})</li>
+		{{end}}
+		</ul>
+	{{end}}
+</div>
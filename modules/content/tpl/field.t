@@ -0,0 +1,34 @@
+{{if .richtext}}
+	<textarea id="{{.input_name}}-field" name="{{.input_name}}" class="html-editor">{{.value}}</textarea>
+{{else}}{{if .markdown}}
+	<textarea id="{{.input_name}}-field" name="{{.input_name}}" rows="12" cols="80">{{.value}}</textarea>
+{{else}}{{if .boolean}}
+	{{if .in_group}}
+		<select name="{{.input_name}}">
+			<option value="">no</option>
+			<option value="1" {{if .value}}selected{{end}}>yes</option>
+		</select>
+	{{else}}
+		<input type="checkbox" name="{{.input_name}}" value="1" {{if .value}}checked{{end}} />
+	{{end}}
+{{else}}{{if .select}}
+	{{$value := .value}}
+	<select name="{{.input_name}}">
+		{{if not .required}}<option value=""></option>{{end}}
+		{{range .options}}
+			<option value="{{.}}" {{if eq . $value}}selected{{end}}>{{.}}</option>
+		{{end}}
+	</select>
+{{else}}{{if .number}}
+	<input name="{{.input_name}}" value="{{.value}}" type="number" step="any" />
+{{else}}{{if .date}}
+	<input name="{{.input_name}}" value="{{.value}}" type="text" placeholder="2013-04-25 18:30" />
+{{else}}{{if .reference}}
+	<input id="{{.input_name}}-field" name="{{.input_name}}" value="{{.value}}" type="text" placeholder="content id{{if .multiple}}s, comma separated{{end}}" />
+{{else}}{{if .media}}
+	<input id="{{.input_name}}-field" name="{{.input_name}}" value="{{.value}}" type="text" />
+	{{if and .value (not .multiple)}}<img src="/media/{{.value}}/thumb" alt="">{{end}}
+	<a href="/admin/media/select?field={{.input_name}}-field" target="_blank" onclick="window.open(this.href, 'media', 'width=800,height=600'); return false;">Select media</a>
+{{else}}
+	<input name="{{.input_name}}" value="{{.value}}" type="text" />
+{{end}}{{end}}{{end}}{{end}}{{end}}{{end}}{{end}}{{end}}
\ No newline at end of file
@@ -3,6 +3,10 @@
 
 <h4>All enries: </h4>
 {{require content/search-form.t}}
+{{if .facets}}
+	{{$facets := .facets}}
+	{{require content/facets.t}}
+{{end}}
 {{range .latest}}
 	{{require content/listing.t}}
 {{end}}
@@ -2,8 +2,41 @@
 {{require content/sidebar.t}}
 
 <h4>Tags:</h4>
+{{$all := .latest}}
 {{range .latest}}
-	<a class="delete" href="/b/content/delete_tag?tag_id={{._id}}">-</a> {{.name}} ({{.count}})<br />
+	{{$tag := .}}
+	<div class="list-item">
+		<a class="delete" href="/b/content/delete_tag?tag_id={{._id}}">-</a>
+		{{range ._tags_ancestors}}{{.name}} / {{end}}<b>{{.name}}</b> ({{.count}})
+		<form action="/b/content/rename_tag" style="display: inline;">
+			<input type="hidden" name="tag_id" value="{{._id}}">
+			<input name="name" value="{{.name}}">
+			<input type="submit" value="Rename">
+		</form>
+		<form action="/b/content/set_tag_parent" style="display: inline;">
+			<input type="hidden" name="tag_id" value="{{._id}}">
+			<select name="parent_id">
+				<option value="">- No parent -</option>
+				{{range $all}}
+					{{if eq ._id $tag._id}}{{else}}
+					<option value="{{._id}}" {{if is_map $tag._tags_parent}}{{if eq ._id $tag._tags_parent._id}}selected{{end}}{{end}}>{{.name}}</option>
+					{{end}}
+				{{end}}
+			</select>
+			<input type="submit" value="Set parent">
+		</form>
+		<form action="/b/content/merge_tags" style="display: inline;">
+			<input type="hidden" name="tag_id" value="{{._id}}">
+			<select name="into_id">
+				{{range $all}}
+					{{if eq ._id $tag._id}}{{else}}
+					<option value="{{._id}}">{{.name}}</option>
+					{{end}}
+				{{end}}
+			</select>
+			<input type="submit" value="Merge into">
+		</form>
+	</div>
 {{end}}
 
 {{require content/footer.t}}
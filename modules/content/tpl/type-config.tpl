@@ -1,21 +1,71 @@
 {{require admin/header.t}}
 {{require content/sidebar.t}}
 <h4>Configure {{.type}} content type.</h4>
-(This page is not implemented yet.)<br />
 <h5>These options modify behavior for every admin: </h5>
+{{$op := .op}}
+<form action="/b/content/save_type_config" method="post">
+	<input type="hidden" name="type" value="{{.type}}" />
+	<b>Rules</b><br />
+	Untyped fields, for example {"title": 1, "content": 1}. Keys with false value are set by the system (created, created_by etc).<br />
+	<textarea name="rules" rows="12" cols="80">{{.rules}}</textarea><br />
+	<br />
+	<b>Typed fields</b><br />
+	List of fields with types text, richtext, markdown, number, date, boolean, select, reference, media or group.<br />
+	<textarea name="fields" rows="12" cols="80">{{.fields}}</textarea><br />
+	<br />
+	<b>Comment rules</b><br />
+	<textarea name="comment_rules" rows="6" cols="80">{{.comment_rules}}</textarea><br />
+	<br />
+	<b>Non versioned fields</b> (comma separated)<br />
+	<input name="non_versioned_fields" value="{{.non_versioned_fields}}" type="text" size="60" /><br />
+	<br />
+	<b>Actions</b><br />
+	Per action authentication, for example {"insert_comment": {"auth": {"min_lev": 0}}}.<br />
+	<textarea name="actions" rows="8" cols="80">{{.actions}}</textarea><br />
+	<br />
+	<input name="moderate_comment" type="checkbox" {{if $op.moderate_comment}}CHECKED{{end}}><b>Moderate comments</b><br />
+	Comments go into the moderation queue first.<br />
+	<br />
+	<input name="tree" type="checkbox" {{if $op.tree}}CHECKED{{end}}><b>Page tree</b><br />
+	<input name="seo" type="checkbox" {{if .seo_enabled}}CHECKED{{end}}><b>SEO fields</b> (title override, description, noindex, canonical url, social image)<br />
+	Contents of this type are pages: they can have a parent page, and they are accessible at the path built from the slugs of their ancestors, eg. /about/team/joe.<br />
+	<br />
+	<b>Draft level</b><br />
+	Minimum user level needed to save drafts (defaults to 300).<br />
+	<input name="draft_level" value="{{if $op.draft_level}}{{$op.draft_level}}{{end}}" type="text" /><br />
+	<br />
+	<b>Comments per page</b><br />
+	<input name="comments_per_page" value="{{if $op.comments_per_page}}{{$op.comments_per_page}}{{end}}" type="text" /><br />
+	<br />
+	<b>Display point queries</b><br />
+	Display point name -> query listing {{.type}} contents there, for example {"index": {"c": "contents", "q": {"type": "{{.type}}"}, "l": 10, "so": "-created", "p": "page"}}.<br />
+	<textarea name="queries" rows="10" cols="80">{{.queries}}</textarea><br />
+	<br />
+	<input type="submit" value="Save">
+</form>
+<br />
+{{if .content_count}}
+	This type has {{.content_count}} contents, it can't be deleted.<br />
+{{else}}
+	<form action="/b/content/delete_type" method="post" onsubmit="return confirm('Delete {{.type}} content type?')">
+		<input type="hidden" name="type" value="{{.type}}" />
+		<input type="submit" value="Delete {{.type}} content type">
+	</form>
+{{end}}
 
 {{$top := .user_type_op}}
 <h5>These options modify behavior for you only: </h5>
-<form action="/b/content/save_type_config?type={{.type}}">
-	<input name="content_safe_delete" type="checkbox" {{if $top.safe_delete_content}}CHECKED{{end}}><b>Safe delete contents</b><br />
+<form action="/b/content/save_personal_type_config" method="post">
+	<input type="hidden" name="type" value="{{.type}}" />
+	<input name="safe_delete_content" type="checkbox" {{if $top.safe_delete_content}}CHECKED{{end}}><b>Safe delete contents</b><br />
 	Check this in if you want the system to ask for a confirmation when deleting {{.type}} contents.<br />
 	<br />
 	
-	<input name="tag_safe_delete" type="checkbox" {{if $top.safe_delete_tag_editor}}CHECKED{{end}}><b>Safe delete tags in editor</b><br />
+	<input name="safe_delete_tag_editor" type="checkbox" {{if $top.safe_delete_tag_editor}}CHECKED{{end}}><b>Safe delete tags in editor</b><br />
 	Check this in if you want the system to ask for a confirmation when deleting tags in the content editor.<br />
 	<br />
 	
-	<input name="tag_safe_delete" type="checkbox" {{if $top.safe_delete_in_tag_list}}CHECKED{{end}}><b>Safe delete tags in listing</b><br />
+	<input name="safe_delete_in_tag_list" type="checkbox" {{if $top.safe_delete_in_tag_list}}CHECKED{{end}}><b>Safe delete tags in listing</b><br />
 	Check this in if you want the system to ask for a confirmation when deleting tags in the tag listing.<br />
 	<br />
 	
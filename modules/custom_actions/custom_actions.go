@@ -27,7 +27,15 @@ func (a *A) Execute() error {
 	case "vote":
 		r = ca_model.Vote(db, user, action, inp)
 	case "respond_content":
-		r = ca_model.RespondContent(db, user, action, inp, opt)
+		resp_typ, has_typ := inp["type"]
+		if !has_typ {
+			return fmt.Errorf("Response has no type.")
+		}
+		rcto, has_rcto := jsonp.GetM(opt, "Modules.content.types."+resp_typ[0])
+		if !has_rcto {
+			return fmt.Errorf("Can't find options of content type %v.", resp_typ[0])
+		}
+		r = ca_model.RespondContent(db, opt, user, action, inp, rcto)
 	default:
 		r = fmt.Errorf("Unkown action %v at RunAction.", action_name)
 	}
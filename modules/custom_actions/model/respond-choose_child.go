@@ -169,7 +169,7 @@ func UnchooseChild(db *mgo.Database, user, action map[string]interface{}, inp ma
 // Checks if parent exists. Returns error if not.
 // Inserts new content with the id of the parent.
 // Increments field named "counter_fieldname" in parent.
-func RespondContent(db *mgo.Database, user, action map[string]interface{}, inp map[string][]string, response_content_type_options map[string]interface{}) error {
+func RespondContent(db *mgo.Database, opt, user, action map[string]interface{}, inp map[string][]string, response_content_type_options map[string]interface{}) error {
 	parent_fieldname := action["parent_fieldname"].(string)
 	counter_fieldname := action["counter_fieldname"].(string)
 	rcto := response_content_type_options
@@ -187,7 +187,7 @@ func RespondContent(db *mgo.Database, user, action map[string]interface{}, inp m
 		return fmt.Errorf("Can't find parent with id %v.", parent_id)
 	}
 	fixval := m{parent_fieldname: parent_id}
-	_, err = content_model.InsertWithFix(db, nil, rcto, inp, user["_id"].(bson.ObjectId), fixval)
+	_, err = content_model.InsertWithFix(db, nil, opt, rcto, inp, user["_id"].(bson.ObjectId), fixval)
 	if err != nil {
 		return err
 	}
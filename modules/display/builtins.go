@@ -3,8 +3,13 @@ package display
 // All functions which can be called from templates reside here.
 
 import (
+	"bytes"
 	"github.com/opesun/hypecms/api/context"
+	"github.com/opesun/hypecms/model/sanitize"
 	"github.com/opesun/hypecms/model/scut"
+	"github.com/opesun/hypecms/modules/content/model"
+	"github.com/opesun/hypecms/modules/display/model"
+	"github.com/opesun/hypecms/modules/output_cache/model"
 	"github.com/opesun/hypecms/modules/user"
 	"github.com/opesun/jsonp"
 	"github.com/opesun/numcon"
@@ -64,10 +69,27 @@ func showPuzzles(uni *context.Uni, mod_name, action_name string) string {
 	return str
 }
 
+// Trusts s completely, never use it on anything coming from the users, see safeHtml.
 func html(s string) template.HTML {
 	return template.HTML(s)
 }
 
+// {{safe_html .content.content}} sanitizes s at render time, with the "Sanitizer" policy of the options or the default one
+// (see the sanitize package). Use it for user submitted HTML which was saved before the content or comment rules sanitized it.
+func safeHtml(opt map[string]interface{}, s interface{}) (template.HTML, error) {
+	if s == nil {
+		return "", nil
+	}
+	pol, err := sanitize.FromConfig(opt["Sanitizer"])
+	if err != nil {
+		return "", err
+	}
+	if pol == nil {
+		pol = sanitize.Default
+	}
+	return template.HTML(pol.Sanitize(fmt.Sprint(s))), nil
+}
+
 func nonEmpty(a interface{}) bool {
 	if a == nil {
 		return false
@@ -111,11 +133,116 @@ func sameKind(a, b interface{}) bool {
 	return reflect.ValueOf(a).Kind() == reflect.ValueOf(b).Kind()
 }
 
+// Renders the title, meta, Open Graph and Twitter card tags out of the "seo" map the content module puts into the data.
+func seoTags(seo interface{}) template.HTML {
+	s, ok := seo.(map[string]interface{})
+	if !ok {
+		return ""
+	}
+	str := func(key string) string {
+		v, _ := s[key].(string)
+		return template.HTMLEscapeString(v)
+	}
+	tags := []string{}
+	meta := func(attr, name, content string) {
+		if len(content) > 0 {
+			tags = append(tags, fmt.Sprintf(`<meta %v="%v" content="%v" />`, attr, name, content))
+		}
+	}
+	title := str("title")
+	if len(str("site_name")) > 0 {
+		title += " - " + str("site_name")
+	}
+	tags = append(tags, "<title>"+title+"</title>")
+	meta("name", "description", str("description"))
+	if noindex, _ := s["noindex"].(bool); noindex {
+		meta("name", "robots", "noindex")
+	}
+	if len(str("canonical")) > 0 {
+		tags = append(tags, `<link rel="canonical" href="`+str("canonical")+`" />`)
+	}
+	meta("property", "og:title", str("title"))
+	meta("property", "og:description", str("description"))
+	meta("property", "og:type", str("type"))
+	meta("property", "og:url", str("canonical"))
+	meta("property", "og:image", str("image"))
+	meta("property", "og:site_name", str("site_name"))
+	card := "summary"
+	if len(str("image")) > 0 {
+		card = "summary_large_image"
+	}
+	meta("name", "twitter:card", card)
+	meta("name", "twitter:title", str("title"))
+	meta("name", "twitter:description", str("description"))
+	meta("name", "twitter:image", str("image"))
+	return template.HTML(strings.Join(tags, "\n"))
+}
+
+// Renders user submitted markup safely, eg. {{format .content.body "md"}}.
+func format(src interface{}, name string) (template.HTML, error) {
+	if src == nil {
+		return "", nil
+	}
+	out, err := display_model.Convert(name, []byte(fmt.Sprint(src)), true)
+	return template.HTML(out), err
+}
+
+// Renders a field of the displayed content in its format (see content_model.FieldFormats), eg. {{field "body"}}.
+// Fields without a format are simply escaped.
+func field(dat map[string]interface{}, name string) (template.HTML, error) {
+	content, _ := dat["content"].(map[string]interface{})
+	v, has := content[name]
+	if !has || v == nil {
+		return "", nil
+	}
+	if f, ok := jsonp.GetStr(dat, "formats."+name); ok {
+		return format(v, f)
+	}
+	return template.HTML(template.HTMLEscapeString(fmt.Sprint(v))), nil
+}
+
+// {{fragment "name" .}} executes the template defined as "name" and caches its output, if the output cache is installed and the user is below its level.
+// The key is the name (and the language and the user, see output_cache_model.UserKey), so a fragment must look the same on every page it is used on, eg. a sidebar.
+func fragment(uni *context.Uni, t *template.Template) func(string, interface{}) (template.HTML, error) {
+	return func(name string, data interface{}) (template.HTML, error) {
+		var backend output_cache_model.Backend
+		var key string
+		version := scut.OptVersion(uni.OriginalOpt())
+		if output_cache_model.Installed(uni.Opt) && output_cache_model.Cacheable(uni.Opt, scut.Ulev(uni.Dat["_user"])) {
+			langs, _ := jsonp.GetS(uni.Dat, "_user.languages")
+			lang := ""
+			if len(langs) > 0 {
+				lang, _ = langs[0].(string)
+			}
+			user, _ := uni.Dat["_user"].(map[string]interface{})
+			backend = output_cache_model.GetBackend(uni.Db, uni.Opt)
+			key = output_cache_model.FragmentKey(uni.Req.Host, name, lang, output_cache_model.UserKey(scut.Ulev(user), user))
+			if e, _ := backend.Get(key, version); e != nil {
+				return template.HTML(e.Body), nil
+			}
+		}
+		var buf bytes.Buffer
+		if err := t.ExecuteTemplate(&buf, name, data); err != nil {
+			return "", err
+		}
+		if backend != nil {
+			backend.Set(output_cache_model.NewEntry(uni.Opt, version, key, buf.Bytes(), "text/html; charset=utf-8", []string{output_cache_model.Contents_tag}))
+		}
+		return template.HTML(buf.String()), nil
+	}
+}
+
 // We must recreate this map each time because map write is not threadsafe.
-// Write will happen when a hook modifies the map (hook call is not implemented yet).
+// Write will happen when a hook modifies the map: modules can add their own functions in the templateFunctions hook,
+// which gets the uni and the map, like shellFunctions of the shell. The templates are parsed with a map built for an other request,
+// so the functions must only use the uni when they are called.
 func builtins(uni *context.Uni) map[string]interface{} {
 	dat := uni.Dat
 	user := uni.Dat["_user"]
+	lang := ""
+	if langs, _ := jsonp.GetS(dat, "_user.languages"); len(langs) > 0 {
+		lang, _ = langs[0].(string)
+	}
 	ret := map[string]interface{}{
 		"get": func(s ...string) interface{} {
 			return get(dat, s...)
@@ -145,10 +272,71 @@ func builtins(uni *context.Uni) map[string]interface{} {
 			return showPuzzles(uni, a, b)
 		},
 		"html": html,
+		"safe_html": func(s interface{}) (template.HTML, error) {
+			return safeHtml(uni.Opt, s)
+		},
 		"format_float": formatFloat,
 		"fallback": fallback,
 		"type_of":	typeOf,
 		"same_kind": sameKind,
+		// The menu of the page trees, only queried by the templates showing it.
+		"menu": func() ([]interface{}, error) {
+			return content_model.Menu(uni.Db, uni.Opt, strings.Trim(uni.P, "/"))
+		},
+		"seo_tags": func() template.HTML {
+			return seoTags(dat["seo"])
+		},
+		"format": format,
+		"field": func(name string) (template.HTML, error) {
+			return field(dat, name)
+		},
+		"fragment": func(name string, data interface{}) (template.HTML, error) {
+			return "", fmt.Errorf("fragment is bound to the template at execution.") // See prepareAndExec.
+		},
+		"t": func(key string, args ...interface{}) (string, error) {
+			return "", fmt.Errorf("t is bound to the template at execution.") // See prepareAndExec and display_model.Translator.
+		},
+		// See funcs.go for these.
+		"truncate": truncate,
+		"lower": lower,
+		"upper": upper,
+		"replace": replace,
+		"slugify": slug,
+		"add": add,
+		"sub": sub,
+		"mul": mul,
+		"div": div,
+		"mod": mod,
+		"max": maxOf,
+		"min": minOf,
+		"list": list,
+		"first": first,
+		"last": last,
+		"slice": slice,
+		"join": join,
+		"sort_by": sortBy,
+		"dict": dict,
+		"url": buildUrl,
+		"self_url": func(kv ...interface{}) (string, error) {
+			return selfUrl(uni.P, uni.Req.URL.Query(), pagingKeys(dat), kv...)
+		},
+		"plural": func(n interface{}, forms ...interface{}) (string, error) {
+			f, err := display_model.ToFloat(n)
+			if err != nil {
+				return "", err
+			}
+			return pluralForm(lang, f, forms)
+		},
+		"ago": func(t interface{}, texts ...interface{}) (string, error) {
+			var tx map[string]interface{}
+			if len(texts) > 0 {
+				tx, _ = texts[0].(map[string]interface{}) // The localization file may be missing.
+			}
+			return relTime(lang, time.Now(), t, tx)
+		},
+	}
+	if uni.Ev != nil {
+		uni.Ev.Trigger("templateFunctions", uni, ret)
 	}
 	return ret
 }
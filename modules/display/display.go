@@ -6,6 +6,7 @@
 package display
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"github.com/opesun/hypecms/api/context"
@@ -13,19 +14,50 @@ import (
 	"github.com/opesun/hypecms/modules/display/model"
 	"github.com/opesun/jsonp"
 	"github.com/opesun/require"
-	"github.com/russross/blackfriday"
 	"html/template"
 	"runtime/debug"
 	"strings"
 )
 
-// Prints errors during template file display to http response. (Kinda nonsense now as it is.)
+// In debug mode template errors are shown in the browser, otherwise the 500 template is displayed and the error only goes to the log.
+var Debug bool
+
+// Panics during template file display are handled as template errors.
 func displErr(uni *context.Uni) {
 	r := recover()
 	if r != nil {
-		uni.Put("There was an error executing the template.")
 		fmt.Println("problem with template: ", r)
 		debug.PrintStack()
+		templateErr(uni, &display_model.TemplateError{Msg: fmt.Sprint(r)})
+	}
+}
+
+var debug_page = template.Must(template.New("error").Parse(`<!DOCTYPE html>
+<html><head><title>Template error</title></head>
+<body>
+<h2>Template error in {{.File}}{{if .Line}}, line {{.Line}}{{end}}</h2>
+<p>{{.Msg}}</p>
+{{if .Chain}}<p>Required from:</p><ul>{{range .Chain}}<li>{{.}}</li>{{end}}</ul>{{end}}
+{{if .Src}}<pre>{{.Src}}</pre>{{end}}
+</body></html>`))
+
+// Displays a template error. Errors happening while displaying the 500 template itself end up as a plain message.
+func templateErr(uni *context.Uni, te *display_model.TemplateError) {
+	fmt.Println("template error:", te)
+	uni.W.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if Debug {
+		uni.W.WriteHeader(500)
+		debug_page.Execute(uni.W, te)
+		return
+	}
+	if _, in := uni.Dat["_template_error"]; in {
+		uni.Put("There was an error executing the template.")
+		return
+	}
+	uni.Dat["_template_error"] = true
+	uni.W.WriteHeader(500)
+	if err := DisplayFile(uni, "500"); err != nil {
+		uni.Put("There was an error executing the template.")
 	}
 }
 
@@ -56,16 +88,7 @@ func toStringSlice(a interface{}) []string {
 	return nil
 }
 
-func validFormat(format string) bool {
-	switch format {
-	case "md":
-		return true
-	}
-	return false
-}
-
-// Does format conversions.
-// Currently only: markdown -> html
+// Does format conversions, with any of the formats registered in display_model (markdown, asciidoc...).
 func GetFileAndConvert(root, fi string, opt map[string]interface{}, host string, file_reader func(string) ([]byte, error)) ([]byte, error) {
 	file, err := scut.GetFile(root, fi, opt, host, file_reader)
 	if err != nil {
@@ -74,7 +97,11 @@ func GetFileAndConvert(root, fi string, opt map[string]interface{}, host string,
 	spl := strings.Split(fi, ".")
 	extension := spl[len(spl)-1]
 	get := func(root, fi string) ([]byte, error) {
-		return GetFileAndConvert(root, fi, opt, host, file_reader)
+		file, err := GetFileAndConvert(root, fi, opt, host, file_reader)
+		if err != nil {
+			return nil, err
+		}
+		return display_model.Mark(fi, file), nil
 	}
 	file, err = display_model.Load(opt["Loads"], root, file, get)
 	if err != nil {
@@ -84,41 +111,66 @@ func GetFileAndConvert(root, fi string, opt map[string]interface{}, host string,
 	if extension == "tpl" {
 		strfile := string(file)
 		newline_pos := strings.Index(strfile, "\n")
-		if newline_pos > 3 && validFormat(strfile[2:newline_pos-1]) { // "--" plus at least 1 characer.
+		if newline_pos > 3 && display_model.ValidFormat(strfile[2:newline_pos-1]) { // "--" plus at least 1 characer.
 			extension = strfile[2 : newline_pos-1]
 			file = file[newline_pos:]
 		}
 	}
-	switch extension {
-	case "md":
-		file = blackfriday.MarkdownCommon(file)
+	if display_model.ValidFormat(extension) {
+		// Markers of the loaded files would be mangled by the conversion.
+		file, _ = display_model.Convert(extension, []byte(display_model.Unmark(string(file))), false)
 	}
 	//file = append([]byte(fmt.Sprintf("<!-- %v/%v. -->", root, fi)), file...)
 	//file = append(file, []byte(fmt.Sprintf("<!-- /%v/%v -->", root, fi))...)
 	return file, nil
 }
 
+// Gets the template file from the cache, or reads, converts and parses it.
+func getTemplate(uni *context.Uni, filep string) (*display_model.Template, error) {
+	ph := *uni
+	ph.Dat = map[string]interface{}{}
+	placeholders := template.FuncMap(builtins(&ph))
+	return display_model.GetTemplate(uni.Req.Host+":"+filep, scut.OptVersion(uni.OriginalOpt()), placeholders,
+		func(t *display_model.Template) ([]byte, error) {
+			get := func(root, fi string) ([]byte, error) {
+				file, err := GetFileAndConvert(uni.Root, fi, uni.Opt, uni.Req.Host, t.ReadFile)
+				if err != nil {
+					return nil, err
+				}
+				return display_model.Mark(fi, file), nil
+			}
+			file, err := require.R("", filep+".tpl", get)
+			if err != nil {
+				return nil, err
+			}
+			// Layouts are read the same way as the page, with all their requires resolved.
+			src, err := display_model.Extend(string(file), func(fi string) (string, error) {
+				layout, err := require.R("", fi, get)
+				return string(layout), err
+			})
+			return []byte(src), err
+		})
+}
+
 // Tries to dislay a template file.
 func DisplayTemplate(uni *context.Uni, filep string) error {
 	_, src := uni.Req.Form["src"]
-	file, err := require.R("", filep+".tpl",
-		func(root, fi string) ([]byte, error) {
-			return GetFileAndConvert(uni.Root, fi, uni.Opt, uni.Req.Host, nil)
-		})
+	tpl, err := getTemplate(uni, filep)
 	if err != nil {
 		return fmt.Errorf("Cant find template file %v.", filep)
 	}
 	if src {
-		uni.Put(string(file))
+		uni.Put(display_model.Unmark(tpl.Src))
 		return nil
 	}
 	uni.Dat["_tpl"] = "/templates/" + scut.TemplateType(uni.Opt) + "/" + scut.TemplateName(uni.Opt) + "/"
-	prepareAndExec(uni, string(file))
+	prepareAndExec(uni, tpl)
 	return nil
 }
 
-// Loads localization, template functions and executes the template.
-func prepareAndExec(uni *context.Uni, file string) {
+// Loads localization, binds the template functions of the request and executes the template.
+// The output is buffered, so nothing half done gets to the response if the execution fails.
+func prepareAndExec(uni *context.Uni, tpl *display_model.Template) {
 	root := uni.Root
 	host := uni.Req.Host
 	dat := uni.Dat
@@ -129,15 +181,36 @@ func prepareAndExec(uni *context.Uni, file string) {
 		langs = []string{"en"}
 	}
 	langs_s := toStringSlice(langs)
-	if !has {
-		langs = []string{"en"}
+	loc, loaded, err := tpl.Loc(langs_s, root, scut.GetTPath(opt, host))
+	if err != nil && (loaded || Debug) {
+		fmt.Println(err) // The error is cached with the template, no need to flood the output on every request.
 	}
-	loc, _ := display_model.LoadLocTempl(file, langs_s, root, scut.GetTPath(opt, host), nil) // TODO: think about errors here.
 	dat["loc"] = merge(dat["loc"], loc)
-	funcMap := template.FuncMap(builtins(uni))
-	t, _ := template.New("tpl").Funcs(funcMap).Parse(string(file))
+	lang := ""
+	if len(langs_s) > 0 {
+		lang = langs_s[0]
+	}
+	tr := display_model.NewTranslator(dat["loc"].(map[string]interface{}), lang)
+	t, err := tpl.Clone()
+	if err != nil {
+		templateErr(uni, display_model.Locate(tpl.Src, err))
+		return
+	}
+	t.Funcs(template.FuncMap(builtins(uni)))
+	t.Funcs(template.FuncMap{"fragment": fragment(uni, t), "t": tr.T})
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, dat); err != nil {
+		templateErr(uni, display_model.Locate(tpl.Src, err))
+		return
+	}
+	if missing := tr.Missing(); Debug && len(missing) > 0 {
+		report := strings.Join(missing, ", ")
+		fmt.Println("missing localization keys at "+uni.P+":", report)
+		w.Header().Set("X-Missing-Loc", report)
+		buf.WriteString("\n<!-- Missing localization keys: " + report + " -->")
+	}
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
-	t.Execute(w, dat) // TODO: watch for errors in execution.
+	w.Write(buf.Bytes())
 }
 
 // Tries to display a module file.
@@ -149,19 +222,16 @@ func DisplayFallback(uni *context.Uni, filep string) error {
 	if scut.PossibleModPath(filep) {
 		return fmt.Errorf("Not a possible fallback path.")
 	}
-	file, err := require.R("", filep+".tpl", // Tricky, care.
-		func(root, fi string) ([]byte, error) {
-			return GetFileAndConvert(uni.Root, fi, uni.Opt, uni.Req.Host, nil)
-		})
+	tpl, err := getTemplate(uni, filep) // Tricky, care.
 	if err != nil {
-		fmt.Errorf("Cant find fallback file %v.", filep)
+		return fmt.Errorf("Cant find fallback file %v.", filep)
 	}
 	if src {
-		uni.Put(string(file))
+		uni.Put(display_model.Unmark(tpl.Src))
 		return nil
 	}
 	uni.Dat["_tpl"] = "/modules/" + strings.Split(filep, "/")[0] + "/tpl/"
-	prepareAndExec(uni, string(file))
+	prepareAndExec(uni, tpl)
 	return nil
 }
 
@@ -224,6 +294,17 @@ func BeforeDisplay(uni *context.Uni) {
 	uni.Ev.Trigger("BeforeDisplay")
 }
 
+// Called when the page is written, eg. the output cache stores it here.
+func AfterDisplay(uni *context.Uni) {
+	defer func() {
+		r := recover()
+		if r != nil {
+			fmt.Println(r)
+		}
+	}()
+	uni.Ev.Trigger("AfterDisplay")
+}
+
 // Displays a display point.
 func D(uni *context.Uni) {
 	points, points_exist := uni.Dat["_points"]
@@ -251,13 +332,15 @@ func D(uni *context.Uni) {
 	scut.IdsToStrings(uni.Dat)
 	langs, _ := jsonp.Get(uni.Dat, "_user.languages") // _user always has language member
 	langs_s := toStringSlice(langs)
-	loc, _ := display_model.LoadLocStrings(uni.Dat, langs_s, uni.Root, scut.GetTPath(uni.Opt, uni.Req.Host), nil) // TODO: think about errors here.
+	loc, err := display_model.LoadLocStrings(uni.Dat, langs_s, uni.Root, scut.GetTPath(uni.Opt, uni.Req.Host), nil)
+	if err != nil && Debug {
+		fmt.Println(err) // What could be loaded is still used. Reported once per template by prepareAndExec otherwise.
+	}
 	if loc != nil {
 		uni.Dat["loc"] = loc
 	}
 	if _, isjson := uni.Req.Form["json"]; isjson {
 		putJSON(uni)
-		return
 	} else {
 		err := DisplayFile(uni, point)
 		if err != nil {
@@ -268,4 +351,5 @@ func D(uni *context.Uni) {
 			}
 		}
 	}
+	AfterDisplay(uni)
 }
@@ -0,0 +1,435 @@
+package display
+
+// The general purpose template functions: strings, math, lists, maps, urls, plurals and relative times.
+// They are registered in builtins, the ones needing the request are wrapped there.
+
+import (
+	"fmt"
+	"github.com/opesun/hypecms/modules/display/model"
+	"github.com/opesun/slugify"
+	"math"
+	"net/url"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+func str(i interface{}) string {
+	if i == nil {
+		return ""
+	}
+	return fmt.Sprint(i)
+}
+
+// Cuts s to at most n characters, at a word boundary if possible, and appends "...". {{.title | truncate 40}}
+func truncate(n int, s interface{}) string {
+	r := []rune(str(s))
+	if len(r) <= n {
+		return string(r)
+	}
+	cut := string(r[:n])
+	if i := strings.LastIndex(cut, " "); i > 0 {
+		cut = cut[:i]
+	}
+	return strings.TrimRight(cut, " ,.;:") + "..."
+}
+
+func lower(s interface{}) string {
+	return strings.ToLower(str(s))
+}
+
+func upper(s interface{}) string {
+	return strings.ToUpper(str(s))
+}
+
+// {{.title | replace "-" " "}}
+func replace(old, with string, s interface{}) string {
+	return strings.Replace(str(s), old, with, -1)
+}
+
+func slug(s interface{}) string {
+	return slugify.S(str(s))
+}
+
+// Integral results are returned as int, so {{add $i 1}} prints 2 and can be used as an index too.
+func number(f float64) interface{} {
+	if f == math.Trunc(f) && math.Abs(f) < 1<<53 {
+		return int(f)
+	}
+	return f
+}
+
+func arith(op func(a, b float64) (float64, error)) func(a, b interface{}) (interface{}, error) {
+	return func(a, b interface{}) (interface{}, error) {
+		x, err := display_model.ToFloat(a)
+		if err != nil {
+			return nil, err
+		}
+		y, err := display_model.ToFloat(b)
+		if err != nil {
+			return nil, err
+		}
+		res, err := op(x, y)
+		if err != nil {
+			return nil, err
+		}
+		return number(res), nil
+	}
+}
+
+var (
+	add = arith(func(a, b float64) (float64, error) { return a + b, nil })
+	sub = arith(func(a, b float64) (float64, error) { return a - b, nil })
+	mul = arith(func(a, b float64) (float64, error) { return a * b, nil })
+	div = arith(func(a, b float64) (float64, error) {
+		if b == 0 {
+			return 0, fmt.Errorf("Division by zero.")
+		}
+		return a / b, nil
+	})
+	mod = arith(func(a, b float64) (float64, error) {
+		if b == 0 {
+			return 0, fmt.Errorf("Division by zero.")
+		}
+		return math.Mod(a, b), nil
+	})
+	maxOf = arith(func(a, b float64) (float64, error) { return math.Max(a, b), nil })
+	minOf = arith(func(a, b float64) (float64, error) { return math.Min(a, b), nil })
+)
+
+func list(a ...interface{}) []interface{} {
+	return a
+}
+
+// Any kind of slice (or array) as []interface{}, nil is an empty list.
+func toList(i interface{}) ([]interface{}, error) {
+	if i == nil {
+		return []interface{}{}, nil
+	}
+	if l, ok := i.([]interface{}); ok {
+		return l, nil
+	}
+	v := reflect.ValueOf(i)
+	if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+		return nil, fmt.Errorf("%T is not a list.", i)
+	}
+	ret := make([]interface{}, v.Len())
+	for j := range ret {
+		ret[j] = v.Index(j).Interface()
+	}
+	return ret, nil
+}
+
+func first(i interface{}) (interface{}, error) {
+	l, err := toList(i)
+	if err != nil || len(l) == 0 {
+		return nil, err
+	}
+	return l[0], nil
+}
+
+func last(i interface{}) (interface{}, error) {
+	l, err := toList(i)
+	if err != nil || len(l) == 0 {
+		return nil, err
+	}
+	return l[len(l)-1], nil
+}
+
+// Same argument order as the slice of text/template ({{range slice .list 0 3}}), but the bounds are clamped,
+// so it never fails on short lists. Strings are sliced by characters.
+func slice(i interface{}, bounds ...int) (interface{}, error) {
+	if len(bounds) > 2 {
+		return nil, fmt.Errorf("slice takes at most two bounds.")
+	}
+	s, is_str := i.(string)
+	var l []interface{}
+	length := len([]rune(s))
+	if !is_str {
+		var err error
+		l, err = toList(i)
+		if err != nil {
+			return nil, err
+		}
+		length = len(l)
+	}
+	beg, end := 0, length
+	if len(bounds) > 0 {
+		beg = bounds[0]
+	}
+	if len(bounds) > 1 {
+		end = bounds[1]
+	}
+	if end > length {
+		end = length
+	}
+	if beg < 0 {
+		beg = 0
+	}
+	if beg > end {
+		beg = end
+	}
+	if is_str {
+		return string([]rune(s)[beg:end]), nil
+	}
+	return l[beg:end], nil
+}
+
+func join(sep string, i interface{}) (string, error) {
+	l, err := toList(i)
+	if err != nil {
+		return "", err
+	}
+	s := []string{}
+	for _, v := range l {
+		s = append(s, str(v))
+	}
+	return strings.Join(s, sep), nil
+}
+
+// Compares numbers as numbers and everything else as strings, nils go first.
+func less(a, b interface{}) bool {
+	if a == nil || b == nil {
+		return a == nil && b != nil
+	}
+	x, errx := display_model.ToFloat(a)
+	y, erry := display_model.ToFloat(b)
+	if errx == nil && erry == nil {
+		return x < y
+	}
+	return str(a) < str(b)
+}
+
+type byField struct {
+	l     []interface{}
+	field string
+}
+
+func (b byField) Len() int      { return len(b.l) }
+func (b byField) Swap(i, j int) { b.l[i], b.l[j] = b.l[j], b.l[i] }
+func (b byField) Less(i, j int) bool {
+	return less(dig(b.l[i], b.field), dig(b.l[j], b.field))
+}
+
+// Value of a dotted path in nested maps, nil if it is not there.
+func dig(i interface{}, path string) interface{} {
+	for _, v := range strings.Split(path, ".") {
+		m, ok := i.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		i = m[v]
+	}
+	return i
+}
+
+// Sorts a list of maps by a field (can be dotted), "-field" sorts descending. Returns a sorted copy. {{range sort_by "-created" .list}}
+func sortBy(field string, i interface{}) ([]interface{}, error) {
+	l, err := toList(i)
+	if err != nil {
+		return nil, err
+	}
+	desc := strings.HasPrefix(field, "-")
+	cp := make([]interface{}, len(l))
+	copy(cp, l)
+	b := byField{cp, strings.TrimPrefix(field, "-")}
+	if desc {
+		sort.Stable(sort.Reverse(b))
+	} else {
+		sort.Stable(b)
+	}
+	return cp, nil
+}
+
+// {{template "x" dict "title" .title "list" .list}}
+func dict(kv ...interface{}) (map[string]interface{}, error) {
+	if len(kv)%2 == 1 {
+		return nil, fmt.Errorf("dict needs key value pairs.")
+	}
+	ret := map[string]interface{}{}
+	for i := 0; i < len(kv); i += 2 {
+		k, ok := kv[i].(string)
+		if !ok {
+			return nil, fmt.Errorf("Key %v of dict is not a string.", kv[i])
+		}
+		ret[k] = kv[i+1]
+	}
+	return ret, nil
+}
+
+// Sets the given parameters of the query, nil or "" removes them.
+func setParams(q url.Values, kv []interface{}) (map[string]struct{}, error) {
+	if len(kv)%2 == 1 {
+		return nil, fmt.Errorf("Query parameters must come in key value pairs.")
+	}
+	set := map[string]struct{}{}
+	for i := 0; i < len(kv); i += 2 {
+		k := str(kv[i])
+		set[k] = struct{}{}
+		if v := str(kv[i+1]); len(v) > 0 {
+			q.Set(k, v)
+		} else {
+			q.Del(k)
+		}
+	}
+	return set, nil
+}
+
+// {{url "/search" "q" .q "tag" "go"}} builds a path with a query.
+func buildUrl(path string, kv ...interface{}) (string, error) {
+	q := url.Values{}
+	if _, err := setParams(q, kv); err != nil {
+		return "", err
+	}
+	if len(q) == 0 {
+		return path, nil
+	}
+	return path + "?" + q.Encode(), nil
+}
+
+// The current url with the given parameters changed. The page number parameters are dropped, unless they are set explicitly,
+// because the paging starts again when for example a filter changes: {{self_url "tag" "go"}}, {{self_url "page" 2}}.
+func selfUrl(path string, query url.Values, paging []string, kv ...interface{}) (string, error) {
+	q := url.Values{}
+	for i, v := range query {
+		q[i] = v
+	}
+	set, err := setParams(q, kv)
+	if err != nil {
+		return "", err
+	}
+	for _, v := range paging {
+		if _, has := set[v]; !has {
+			q.Del(v)
+		}
+	}
+	if len(q) == 0 {
+		return path, nil
+	}
+	return path + "?" + q.Encode(), nil
+}
+
+// Parameter names of the pagings found in the data (the navis of the queries), "page" is always one.
+func pagingKeys(dat map[string]interface{}) []string {
+	ret := []string{"page"}
+	for _, v := range dat {
+		if p, ok := v.(display_model.PagingInfo); ok && len(p.Paramkey) > 0 && p.Paramkey != "page" {
+			ret = append(ret, p.Paramkey)
+		}
+	}
+	return ret
+}
+
+// The forms of a word can be given as strings (one and other, or one, few and many), as a list of those,
+// or as a map from the categories to the forms, like the ones in the localization files:
+//
+//	"comments": {"zero": "No comments", "one": "%v comment", "other": "%v comments"}
+//
+// "zero" is used for 0 in every language if present. A "%v" in the form is replaced with the number.
+func pluralForm(lang string, n float64, forms []interface{}) (string, error) {
+	if len(forms) == 1 {
+		if l, err := toList(forms[0]); err == nil {
+			forms = l
+		}
+	}
+	cat := display_model.PluralCategory(lang, n)
+	var form interface{}
+	switch {
+	case len(forms) == 1:
+		m, ok := forms[0].(map[string]interface{})
+		if !ok {
+			return "", fmt.Errorf("Plural forms must be a map or at least two strings.")
+		}
+		form = display_model.PickPlural(lang, n, m)
+	case len(forms) == 2:
+		form = forms[1]
+		if cat == "one" {
+			form = forms[0]
+		}
+	case len(forms) == 3:
+		switch cat {
+		case "one":
+			form = forms[0]
+		case "few":
+			form = forms[1]
+		default:
+			form = forms[2]
+		}
+	default:
+		return "", fmt.Errorf("Wrong number of plural forms: %v.", len(forms))
+	}
+	s := str(form)
+	if strings.Contains(s, "%v") {
+		s = strings.Replace(s, "%v", str(number(n)), -1)
+	}
+	return s, nil
+}
+
+// Converts unix timestamps (what the modules store) and times to time.Time.
+func toTime(i interface{}) (time.Time, error) {
+	if t, ok := i.(time.Time); ok {
+		return t, nil
+	}
+	f, err := display_model.ToFloat(i)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%v is not a time.", i)
+	}
+	return time.Unix(int64(f), 0), nil
+}
+
+var time_units = []struct {
+	name string
+	dur  time.Duration
+}{
+	{"year", 365 * 24 * time.Hour},
+	{"month", 30 * 24 * time.Hour},
+	{"week", 7 * 24 * time.Hour},
+	{"day", 24 * time.Hour},
+	{"hour", time.Hour},
+	{"minute", time.Minute},
+}
+
+// English defaults of the relative time texts, can be replaced by a localization file, see ago.
+var ago_texts = map[string]interface{}{
+	"now":    "just now",
+	"ago":    "%v ago",
+	"in":     "in %v",
+	"year":   map[string]interface{}{"one": "%v year", "other": "%v years"},
+	"month":  map[string]interface{}{"one": "%v month", "other": "%v months"},
+	"week":   map[string]interface{}{"one": "%v week", "other": "%v weeks"},
+	"day":    map[string]interface{}{"one": "%v day", "other": "%v days"},
+	"hour":   map[string]interface{}{"one": "%v hour", "other": "%v hours"},
+	"minute": map[string]interface{}{"one": "%v minute", "other": "%v minutes"},
+}
+
+// Relative time, like "3 hours ago" or "in 2 days". texts can override the keys of ago_texts, eg. {{ago .created .loc.time}}.
+func relTime(lang string, now time.Time, t interface{}, texts map[string]interface{}) (string, error) {
+	tm, err := toTime(t)
+	if err != nil {
+		return "", err
+	}
+	text := func(key string) interface{} {
+		if v, has := texts[key]; has {
+			return v
+		}
+		return ago_texts[key]
+	}
+	d := now.Sub(tm)
+	wrap := "ago"
+	if d < 0 {
+		d, wrap = -d, "in"
+	}
+	for _, v := range time_units {
+		if d < v.dur {
+			continue
+		}
+		n := float64(d / v.dur)
+		s, err := pluralForm(lang, n, []interface{}{text(v.name)})
+		if err != nil {
+			return "", err
+		}
+		return strings.Replace(str(text(wrap)), "%v", s, -1), nil
+	}
+	return str(text("now")), nil
+}
@@ -0,0 +1,157 @@
+package display
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestStrings(t *testing.T) {
+	if s := truncate(12, "Hello wonderful world"); s != "Hello..." {
+		t.Fatal(s)
+	}
+	if s := truncate(30, "Short"); s != "Short" {
+		t.Fatal(s)
+	}
+	if s := truncate(4, "Árvíztűrő"); s != "Árví..." {
+		t.Fatal(s)
+	}
+	if s := replace("-", " ", "a-b-c"); s != "a b c" {
+		t.Fatal(s)
+	}
+	if s := upper(nil); s != "" {
+		t.Fatal(s)
+	}
+}
+
+func TestMath(t *testing.T) {
+	if v, _ := add(1, 2.0); v != 3 {
+		t.Fatal(v)
+	}
+	if v, _ := div(7, "2"); v != 3.5 {
+		t.Fatal(v)
+	}
+	if _, err := div(1, 0); err == nil {
+		t.Fatal("Division by zero should fail.")
+	}
+	if v, _ := mod(int64(7), 3); v != 1 {
+		t.Fatal(v)
+	}
+	if _, err := mul("x", 1); err == nil {
+		t.Fatal("x is not a number.")
+	}
+}
+
+func TestLists(t *testing.T) {
+	l := []string{"a", "b", "c"}
+	if v, _ := first(l); v != "a" {
+		t.Fatal(v)
+	}
+	if v, _ := last(l); v != "c" {
+		t.Fatal(v)
+	}
+	if v, _ := first(nil); v != nil {
+		t.Fatal(v)
+	}
+	if v, _ := slice(l, 1, 10); !reflect.DeepEqual(v, []interface{}{"b", "c"}) {
+		t.Fatal(v)
+	}
+	if v, _ := slice("Árvíz", 1, 3); v != "rv" {
+		t.Fatal(v)
+	}
+	if v, _ := join(", ", l); v != "a, b, c" {
+		t.Fatal(v)
+	}
+	m := func(n interface{}, name string) map[string]interface{} {
+		return map[string]interface{}{"n": map[string]interface{}{"v": n}, "name": name}
+	}
+	in := []interface{}{m(10, "x"), m(2, "y"), m(nil, "z"), m(5.5, "w")}
+	sorted, _ := sortBy("-n.v", in)
+	names, _ := join("", mapField(sorted, "name"))
+	if names != "xwyz" {
+		t.Fatal(names)
+	}
+	if in[0].(map[string]interface{})["name"] != "x" || in[1].(map[string]interface{})["name"] != "y" {
+		t.Fatal("sort_by must not modify its input.")
+	}
+	if _, err := dict("a", 1, "b"); err == nil {
+		t.Fatal("Odd number of arguments.")
+	}
+}
+
+func mapField(l []interface{}, f string) []interface{} {
+	ret := []interface{}{}
+	for _, v := range l {
+		ret = append(ret, dig(v, f))
+	}
+	return ret
+}
+
+func TestUrls(t *testing.T) {
+	if u, _ := buildUrl("/search", "q", "a b", "empty", ""); u != "/search?q=a+b" {
+		t.Fatal(u)
+	}
+	q := url.Values{"tag": {"go"}, "page": {"3"}, "cp": {"2"}}
+	if u, _ := selfUrl("/blog", q, []string{"page", "cp"}, "tag", "js"); u != "/blog?tag=js" {
+		t.Fatal(u)
+	}
+	if u, _ := selfUrl("/blog", q, []string{"page", "cp"}, "page", 4); u != "/blog?page=4&tag=go" {
+		t.Fatal(u)
+	}
+	if q.Get("page") != "3" {
+		t.Fatal("self_url must not modify the query.")
+	}
+}
+
+func TestPlural(t *testing.T) {
+	loc := map[string]interface{}{"zero": "no comments", "one": "%v comment", "other": "%v comments"}
+	cases := []struct {
+		lang  string
+		n     float64
+		forms []interface{}
+		out   string
+	}{
+		{"en", 1, []interface{}{loc}, "1 comment"},
+		{"en", 0, []interface{}{loc}, "no comments"},
+		{"en", 2, []interface{}{loc}, "2 comments"},
+		{"en", 1.5, []interface{}{"item", "items"}, "items"},
+		{"fr", 0, []interface{}{"%v chat", "%v chats"}, "0 chat"},
+		{"ru", 22, []interface{}{"one", "few", "many"}, "few"},
+		{"ru", 12, []interface{}{"one", "few", "many"}, "many"},
+		{"ru-RU", 21, []interface{}{[]interface{}{"one", "few", "many"}}, "one"},
+		{"ja", 1, []interface{}{"one", "other"}, "other"},
+	}
+	for _, v := range cases {
+		out, err := pluralForm(v.lang, v.n, v.forms)
+		if err != nil || out != v.out {
+			t.Errorf("%v %v: %v %v", v.lang, v.n, out, err)
+		}
+	}
+	if _, err := pluralForm("en", 1, []interface{}{"a", "b", "c", "d"}); err == nil {
+		t.Fatal("Too many forms.")
+	}
+}
+
+func TestRelTime(t *testing.T) {
+	now := time.Unix(1000000000, 0)
+	cases := []struct {
+		t   interface{}
+		out string
+	}{
+		{now.Unix() - 20, "just now"},
+		{now.Unix() - 60, "1 minute ago"},
+		{float64(now.Unix() - 3*3600 - 100), "3 hours ago"},
+		{now.Add(49 * time.Hour), "in 2 days"},
+		{now.Unix() - 400*86400, "1 year ago"},
+	}
+	for _, v := range cases {
+		if out, err := relTime("en", now, v.t, nil); err != nil || out != v.out {
+			t.Errorf("%v: %v %v", v.t, out, err)
+		}
+	}
+	hu := map[string]interface{}{"ago": "%v", "hour": map[string]interface{}{"other": "%v órája"}}
+	if out, _ := relTime("hu", now, now.Unix()-7200, hu); out != "2 órája" {
+		t.Fatal(out)
+	}
+}
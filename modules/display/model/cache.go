@@ -0,0 +1,136 @@
+package display_model
+
+import (
+	"encoding/json"
+	"html/template"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A parsed template together with everything read from the disk while building it.
+// The entry goes stale when any of those files change, appear or disappear, or when the options version changes.
+type Template struct {
+	Src      string // The source after all requires, loads and conversions.
+	Err      error  // Parse error, if any, locate it with Locate. Cached too, so a broken template is not parsed again until it changes.
+	version  string
+	tpl      *template.Template
+	mut      sync.Mutex
+	files    map[string]time.Time // Zero time means the file did not exist.
+	locs     map[string]map[string]interface{}
+	loc_errs map[string]error
+}
+
+var (
+	cache     = map[string]*Template{}
+	cache_mut sync.Mutex
+)
+
+func modTime(s string) time.Time {
+	fi, err := os.Stat(s)
+	if err != nil {
+		return time.Time{}
+	}
+	return fi.ModTime()
+}
+
+func (t *Template) track(s string) {
+	t.mut.Lock()
+	defer t.mut.Unlock()
+	t.files[s] = modTime(s)
+}
+
+// A file reader which remembers the files it was asked for.
+func (t *Template) ReadFile(s string) ([]byte, error) {
+	t.track(s)
+	return ioutil.ReadFile(s)
+}
+
+func (t *Template) readLoc(s string) (map[string]interface{}, error) {
+	file, err := t.ReadFile(s)
+	if err != nil {
+		return nil, err
+	}
+	var v map[string]interface{}
+	err = json.Unmarshal(file, &v)
+	return v, err
+}
+
+func (t *Template) stale() bool {
+	t.mut.Lock()
+	defer t.mut.Unlock()
+	for s, mod := range t.files {
+		if !modTime(s).Equal(mod) {
+			return true
+		}
+	}
+	return false
+}
+
+// Gives back a copy of the parsed template, so the request dependent functions can be bound to it with Funcs.
+func (t *Template) Clone() (*template.Template, error) {
+	if t.Err != nil {
+		return nil, t.Err
+	}
+	return t.tpl.Clone()
+}
+
+// Loads the localization strings used by the template, the results (and the errors) are cached per language list.
+// The returned map is a copy, feel free to modify it. It is usable even if there is an error, see ReadFiles.
+// loaded tells if the strings were read from the disk now, so a cached error can be reported only once.
+func (t *Template) Loc(user_langs []string, root, tplpath string) (ret map[string]interface{}, loaded bool, err error) {
+	key := strings.Join(user_langs, ",")
+	t.mut.Lock()
+	loc, has := t.locs[key]
+	err = t.loc_errs[key]
+	t.mut.Unlock()
+	if !has {
+		loaded = true
+		loc, err = LoadLocTempl(t.Src, user_langs, root, tplpath, t.readLoc)
+		t.mut.Lock()
+		t.locs[key] = loc
+		t.loc_errs[key] = err
+		t.mut.Unlock()
+	}
+	ret = map[string]interface{}{}
+	for i, v := range loc {
+		ret[i] = v
+	}
+	return ret, loaded, err
+}
+
+// Gives back the template cached under key, or builds it if there is none, it is stale or it was built with an other version of the options.
+// build must read the files with t.ReadFile. funcs are only used for parsing, bind the real ones to the Clone.
+func GetTemplate(key, version string, funcs template.FuncMap, build func(t *Template) ([]byte, error)) (*Template, error) {
+	cache_mut.Lock()
+	t, has := cache[key]
+	cache_mut.Unlock()
+	if has && t.version == version && !t.stale() {
+		return t, nil
+	}
+	t = &Template{
+		version:  version,
+		files:    map[string]time.Time{},
+		locs:     map[string]map[string]interface{}{},
+		loc_errs: map[string]error{},
+	}
+	src, err := build(t)
+	if err != nil {
+		return nil, err
+	}
+	t.Src = string(src)
+	t.tpl, t.Err = template.New("tpl").Funcs(funcs).Parse(t.Src)
+	cache_mut.Lock()
+	cache[key] = t
+	cache_mut.Unlock()
+	return t, nil
+}
+
+// Empties the template cache, called after the templates are modified from the admin.
+func ClearCache() {
+	cache_mut.Lock()
+	defer cache_mut.Unlock()
+	cache = map[string]*Template{}
+}
@@ -11,6 +11,9 @@ import (
 	"strings"
 )
 
+// Documents loaded per result by a reverse lookup without a limit, see reverseLookups.
+const Default_rev_limit = 20
+
 // Cuts a long string at max_char_count, taking a word boundary into account.
 func Excerpt(s string, max_char_count int) string {
 	if len(s) < max_char_count {
@@ -56,6 +59,12 @@ type PagingInfo struct {
 // png = path and query
 // In the CMS you can access it from uni.P + "?" + uni.Req.URL.RawQuery.
 func DoPaging(db *mgo.Database, collection string, query map[string]interface{}, page_num_key string, get map[string][]string, pnq string, limit int) PagingInfo {
+	all_results, _ := db.C(collection).Find(query).Count() // TODO: think about the error here.
+	return Paging(all_results, page_num_key, get, pnq, limit)
+}
+
+// Same as DoPaging, but for the cases when the number of results is already known (eg. results not coming from a simple query).
+func Paging(all_results int, page_num_key string, get map[string][]string, pnq string, limit int) PagingInfo {
 	var current_page int
 	num_str, has := get[page_num_key]
 	if !has {
@@ -68,7 +77,6 @@ func DoPaging(db *mgo.Database, collection string, query map[string]interface{},
 			current_page = 1
 		}
 	}
-	all_results, _ := db.C(collection).Find(query).Count() // TODO: think about the error here.
 	nav, _ := paging.P(current_page, all_results/limit+1, 3, pnq)
 	skip := (current_page - 1) * limit
 	return PagingInfo{
@@ -100,6 +108,51 @@ func toInt(num interface{}) int {
 	panic(fmt.Sprintf("Unkown type %T.", num))
 }
 
+// Loads the documents referencing the given results, eg. the answers of questions. Example:
+// "rev": {"answers": {"c": "contents", "f": "_contents_question", "so": "-created", "l": 5}}
+// Every result gets an "answers" field with at most "l" (Default_rev_limit if not given) contents whose _contents_question field
+// is the id of the result. "r" is the projection of the loaded documents, password fields are left out by default, like at "r" of the query.
+// One query is run per result, so every result gets its own limit.
+func reverseLookups(db *mgo.Database, res []interface{}, rev map[string]interface{}) {
+	for name, z := range rev {
+		l, ok := z.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		coll, _ := l["c"].(string)
+		field, _ := l["f"].(string)
+		if len(coll) == 0 || len(field) == 0 {
+			continue
+		}
+		limit := Default_rev_limit
+		if lim, has := l["l"]; has && toInt(lim) > 0 {
+			limit = toInt(lim)
+		}
+		fields, ok := l["r"].(map[string]interface{})
+		if !ok {
+			fields = map[string]interface{}{"password": 0}
+		}
+		for _, v := range res {
+			doc, ok := v.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			q := db.C(coll).Find(map[string]interface{}{field: doc["_id"]}).Select(fields).Limit(limit)
+			if sort, has := l["so"].(string); has {
+				q.Sort(sort)
+			}
+			var children []interface{}
+			if q.All(&children) != nil {
+				continue
+			}
+			if children == nil {
+				children = []interface{}{}
+			}
+			doc[name] = basic.Convert(children)
+		}
+	}
+}
+
 // c: 		collection			string
 // q: 		query				map[string]interface{}
 // p:		page number key		string							This is used to extract the page nubver from get parameters. Also activates paging.	
@@ -107,6 +160,7 @@ func toInt(num interface{}) int {
 // sk: 		skip				float64/int						Hardcoded value, barely useful (see p instead)
 // l:		limit				float64/int
 // so:		sort				string							Example: "-created"
+// rev:		reverse lookups		map[string]interface{}			Loads the documents referencing the results, see reverseLookups.
 //
 // TODO: check for validity of type assertions.
 func RunQueries(db *mgo.Database, queries map[string]interface{}, get map[string][]string, path_n_query string) map[string]interface{} {
@@ -159,6 +213,11 @@ func RunQueries(db *mgo.Database, queries map[string]interface{}, get map[string
 			resolve_fields = map[string]interface{}{"password": 0}
 		}
 		resolver.ResolveAll(db, res, resolve_fields)
+		if rev, has := v["rev"]; has {
+			if rev_m, ok := rev.(map[string]interface{}); ok {
+				reverseLookups(db, res, rev_m)
+			}
+		}
 		qs[name] = res
 	}
 	return qs
@@ -0,0 +1,145 @@
+package display_model
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Every required file is wrapped between these template comments, so errors reported by the template package
+// (which only sees one big blob of text) can be traced back to the file and line they originate from.
+const (
+	mark_beg = "{{/*@file "
+	mark_end = "{{/*@end*/}}"
+)
+
+var (
+	mark_rx      = regexp.MustCompile(`\{\{/\*@file [^*]*\*/\}\}|\{\{/\*@end\*/\}\}`)
+	mark_line_rx = regexp.MustCompile(`^(.*):([0-9]+)$`)
+	pos_rx       = regexp.MustCompile(`^template: [^:]*:([0-9]+):(?:([0-9]+):)? ?`)
+)
+
+// Wraps the content of the required file fi into the markers.
+func Mark(fi string, file []byte) []byte {
+	ret := append([]byte(mark_beg+fi+"*/}}"), file...)
+	return append(ret, mark_end...)
+}
+
+// Removes the markers, eg. when the source is shown with ?src.
+func Unmark(src string) string {
+	return mark_rx.ReplaceAllString(src, "")
+}
+
+// An error which happened during the parsing or the execution of a template.
+type TemplateError struct {
+	Msg   string   // The original error message without the position.
+	File  string   // The file the error is in.
+	Line  int      // Line in that file, 0 if unknown.
+	Chain []string // The files requiring File, the entry point first.
+	Src   string   // A few lines around the error, markers removed.
+}
+
+func (e *TemplateError) Error() string {
+	s := fmt.Sprintf("%v:%v: %v", e.File, e.Line, e.Msg)
+	if len(e.Chain) > 0 {
+		s += " (required from " + strings.Join(e.Chain, " -> ") + ")"
+	}
+	return s
+}
+
+type srcPos struct {
+	file string
+	line int
+}
+
+// Markers may contain the line the content starts at, like "index.tpl:12".
+func markPos(s string) srcPos {
+	if sub := mark_line_rx.FindStringSubmatch(s); sub != nil {
+		line, _ := strconv.Atoi(sub[2])
+		return srcPos{sub[1], line}
+	}
+	return srcPos{s, 1}
+}
+
+// Finds out the file and the line of line:col of the concatenated source src, col being a byte offset in the line.
+// If col is unknown (-1), only the markers before the first non blank character of the line are taken into account.
+func locate(src string, line, col int) []srcPos {
+	stack := []srcPos{{"", 1}}
+	marks := mark_rx.FindAllStringIndex(src, -1)
+	cur_line, line_start := 1, 0
+	for i := 0; i < len(src); i++ {
+		at := cur_line == line && col >= 0 && i-line_start >= col
+		if len(marks) > 0 && marks[0][0] == i {
+			if at {
+				break
+			}
+			m := src[marks[0][0]:marks[0][1]]
+			if m == mark_end {
+				if len(stack) > 1 {
+					stack = stack[:len(stack)-1]
+				}
+			} else {
+				stack = append(stack, markPos(m[len(mark_beg):len(m)-len("*/}}")]))
+			}
+			i = marks[0][1] - 1
+			marks = marks[1:]
+			continue
+		}
+		if at || cur_line == line && col < 0 && src[i] != ' ' && src[i] != '\t' {
+			break
+		}
+		if src[i] == '\n' {
+			cur_line++
+			line_start = i + 1
+			stack[len(stack)-1].line++
+		}
+	}
+	return stack
+}
+
+// Lines around the line-th line of the source without the markers, the erroneous one is marked with a ">".
+func excerpt(src string, line int) string {
+	lines := strings.Split(src, "\n")
+	from, to := line-3, line+2
+	if from < 0 {
+		from = 0
+	}
+	if to > len(lines) {
+		to = len(lines)
+	}
+	ret := []string{}
+	for i := from; i < to; i++ {
+		prefix := "  "
+		if i == line-1 {
+			prefix = "> "
+		}
+		ret = append(ret, prefix+Unmark(lines[i]))
+	}
+	return strings.Join(ret, "\n")
+}
+
+// Turns an error coming from the template package into a *TemplateError, src is the source of the parsed template.
+func Locate(src string, err error) *TemplateError {
+	msg := err.Error()
+	sub := pos_rx.FindStringSubmatch(msg)
+	if sub == nil {
+		return &TemplateError{Msg: msg}
+	}
+	line, _ := strconv.Atoi(sub[1])
+	col := -1
+	if len(sub[2]) > 0 {
+		col, _ = strconv.Atoi(sub[2])
+	}
+	stack := locate(src, line, col)
+	te := &TemplateError{
+		Msg:  msg[len(sub[0]):],
+		File: stack[len(stack)-1].file,
+		Line: stack[len(stack)-1].line,
+		Src:  excerpt(src, line),
+	}
+	for i := 1; i < len(stack)-1; i++ {
+		te.Chain = append(te.Chain, fmt.Sprintf("%v:%v", stack[i].file, stack[i].line))
+	}
+	return te
+}
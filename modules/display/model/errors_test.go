@@ -0,0 +1,23 @@
+package display_model
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestLocate(t *testing.T) {
+	header := Mark("header.t", []byte("<html>\n<title>{{.title}}</title>\n"))
+	src := string(Mark("index.tpl", []byte(string(header)+"<p>\n{{.x.y}}\n</p>")))
+	// header.t is 2 lines and a half, so line 4 is the second line of index.tpl.
+	te := Locate(src, fmt.Errorf("template: tpl:4:2: executing \"tpl\" at <.x.y>: nil pointer"))
+	if te.File != "index.tpl" || te.Line != 2 || len(te.Chain) != 0 || te.Msg != "executing \"tpl\" at <.x.y>: nil pointer" {
+		t.Fatal(te)
+	}
+	te = Locate(src, fmt.Errorf("template: tpl:2: function \"x\" not defined"))
+	if te.File != "header.t" || te.Line != 2 || len(te.Chain) != 1 || te.Chain[0] != "index.tpl:1" {
+		t.Fatal(te)
+	}
+	if Unmark(src) != "<html>\n<title>{{.title}}</title>\n<p>\n{{.x.y}}\n</p>" {
+		t.Fatal(Unmark(src))
+	}
+}
@@ -0,0 +1,140 @@
+package display_model
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Layout inheritance on top of the require system. A page declares the layout it extends and overrides slots of it:
+//
+//	{{extends layout.t}}
+//	{{slot content}}<h2>Hello</h2>{{endslot}}
+//
+// The layout contains the slots with their default content:
+//
+//	{{require header.t}}{{slot content}}Nothing here.{{endslot}}{{require footer.t}}
+//
+// Everything in the page outside of the slots is dropped. Layouts can extend other layouts, slots can be nested.
+// They are not the block action of text/template, they are resolved before parsing, like the requires.
+// Layouts are read like required files, so they fall back to the module tpl directories the same way.
+const Max_extends_depth = 10
+
+var (
+	extends_rx = regexp.MustCompile(`\{\{extends ([a-zA-Z0-9_.:/-]+)\}\}`)
+	slot_rx    = regexp.MustCompile(`\{\{slot ([a-zA-Z0-9_-]+)\}\}|\{\{endslot\}\}`)
+)
+
+// Positions of the {{extends x}} tags in file, like require.RequirePositions does it with the requires.
+func ExtendsPositions(file string) [][]int {
+	return extends_rx.FindAllStringIndex(file, -1)
+}
+
+type slot struct {
+	name       string
+	beg, end   int // The content.
+	tbeg, tend int // The whole slot including the tags.
+	children   []*slot
+}
+
+// Parses the slot structure of src.
+func slots(src string) ([]*slot, error) {
+	root := &slot{}
+	stack := []*slot{root}
+	for _, v := range slot_rx.FindAllStringSubmatchIndex(src, -1) {
+		top := stack[len(stack)-1]
+		if v[2] == -1 { // {{endslot}}
+			if len(stack) == 1 {
+				return nil, fmt.Errorf("{{endslot}} without {{slot}}.")
+			}
+			top.end, top.tend = v[0], v[1]
+			stack = stack[:len(stack)-1]
+			continue
+		}
+		b := &slot{name: src[v[2]:v[3]], beg: v[1], tbeg: v[0]}
+		top.children = append(top.children, b)
+		stack = append(stack, b)
+	}
+	if len(stack) > 1 {
+		return nil, fmt.Errorf("Slot %v is not closed.", stack[len(stack)-1].name)
+	}
+	return root.children, nil
+}
+
+// Collects the content of all slots (nested ones too) into to, the ones already in there are kept, as they come from a more specific template.
+// The contents are marked with their position, so errors in them are still reported at the right place.
+func collectSlots(src string, bs []*slot, to map[string]string) {
+	for _, b := range bs {
+		if _, has := to[b.name]; !has {
+			to[b.name] = markAt(src, b.beg, src[b.beg:b.end])
+		}
+		collectSlots(src, b.children, to)
+	}
+}
+
+// Marks content with the file and line which can be found at offset in src.
+func markAt(src string, offset int, content string) string {
+	line := strings.Count(src[:offset], "\n") + 1
+	col := offset - strings.LastIndex(src[:offset], "\n") - 1
+	stack := locate(src, line, col)
+	pos := stack[len(stack)-1]
+	if len(pos.file) == 0 {
+		return content
+	}
+	return fmt.Sprintf("%v%v:%v*/}}%v%v", mark_beg, pos.file, pos.line, content, mark_end)
+}
+
+// Puts the overrides into the slots of src, keeping the slot tags so the result can be filled again.
+func fill(src string, bs []*slot, overrides map[string]string) string {
+	ret := ""
+	last := 0
+	for _, b := range bs {
+		ret += src[last:b.beg]
+		if o, has := overrides[b.name]; has {
+			// The override may contain slots too, overridden by an even more specific template.
+			obs, _ := slots(o)
+			sub := map[string]string{}
+			for i, v := range overrides {
+				if i != b.name {
+					sub[i] = v
+				}
+			}
+			ret += fill(o, obs, sub)
+		} else {
+			ret += fill(src[:b.end], b.children, overrides)[b.beg:]
+		}
+		ret += src[b.end:b.tend]
+		last = b.tend
+	}
+	return ret + src[last:]
+}
+
+// Resolves the layout inheritance of src. get reads a layout with all its requires resolved.
+// The slot tags are removed from the result.
+func Extend(src string, get func(string) (string, error)) (string, error) {
+	overrides := map[string]string{}
+	for i := 0; ; i++ {
+		m := extends_rx.FindStringSubmatchIndex(src)
+		if m == nil {
+			break
+		}
+		if i == Max_extends_depth {
+			return "", fmt.Errorf("Layouts are extending each other too deep.")
+		}
+		bs, err := slots(src)
+		if err != nil {
+			return "", err
+		}
+		collectSlots(src, bs, overrides)
+		layout, err := get(src[m[2]:m[3]])
+		if err != nil {
+			return "", fmt.Errorf("Can't read layout %v: %v", src[m[2]:m[3]], err)
+		}
+		src = layout
+	}
+	bs, err := slots(src)
+	if err != nil {
+		return "", err
+	}
+	return slot_rx.ReplaceAllString(fill(src, bs, overrides), ""), nil
+}
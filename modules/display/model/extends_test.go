@@ -0,0 +1,41 @@
+package display_model
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestExtend(t *testing.T) {
+	files := map[string]string{
+		"base.t": "<h1>{{slot title}}Base{{endslot}}</h1>{{slot main}}<p>{{slot text}}nothing{{endslot}}</p>{{endslot}}",
+		"mid.t":  "{{extends base.t}}{{slot title}}Mid{{endslot}}",
+	}
+	get := func(fi string) (string, error) {
+		f, has := files[fi]
+		if !has {
+			return "", fmt.Errorf("Not found.")
+		}
+		return f, nil
+	}
+	out, err := Extend("{{extends mid.t}}ignored{{slot text}}hello{{endslot}}", get)
+	if err != nil || out != "<h1>Mid</h1><p>hello</p>" {
+		t.Fatal(out, err)
+	}
+	out, err = Extend("no layout {{slot x}}here{{endslot}}", get)
+	if err != nil || out != "no layout here" {
+		t.Fatal(out, err)
+	}
+	if _, err = Extend("{{extends missing.t}}", get); err == nil {
+		t.Fatal("Missing layout should be an error.")
+	}
+	if _, err = Extend("{{extends base.t}}{{slot title}}unclosed", get); err == nil {
+		t.Fatal("Unclosed slot should be an error.")
+	}
+	// Overrides keep their original position for the error messages.
+	page := string(Mark("page.tpl", []byte("{{extends base.t}}\n\n{{slot text}}{{.x}}{{endslot}}")))
+	out, _ = Extend(page, get)
+	te := Locate(out, fmt.Errorf("template: tpl:1:40: executing \"tpl\" at <.x>: error"))
+	if te.File != "page.tpl" || te.Line != 3 {
+		t.Fatal(te, out)
+	}
+}
@@ -0,0 +1,220 @@
+package display_model
+
+import (
+	"fmt"
+	"github.com/opesun/hypecms/model/sanitize"
+	"github.com/russross/blackfriday"
+	"html/template"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Converts a source format into HTML. Template files are trusted, but when safe is true the source comes from the users (eg. a content field),
+// so raw HTML in it must not get trough.
+type Converter func(src []byte, safe bool) []byte
+
+var (
+	formats     = map[string]Converter{}
+	formats_mut sync.RWMutex
+)
+
+// Modules can add their own formats, preferably from an init function. The name is the file extension too,
+// and can be given in the first line of a tpl file, like "--md".
+func RegisterFormat(name string, c Converter) {
+	formats_mut.Lock()
+	defer formats_mut.Unlock()
+	formats[name] = c
+}
+
+func ValidFormat(name string) bool {
+	formats_mut.RLock()
+	defer formats_mut.RUnlock()
+	_, has := formats[name]
+	return has
+}
+
+// Names of the registered formats, sorted.
+func Formats() []string {
+	formats_mut.RLock()
+	defer formats_mut.RUnlock()
+	ret := []string{}
+	for i := range formats {
+		ret = append(ret, i)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+func Convert(name string, src []byte, safe bool) ([]byte, error) {
+	formats_mut.RLock()
+	c, has := formats[name]
+	formats_mut.RUnlock()
+	if !has {
+		return nil, fmt.Errorf("Unkown format %v.", name)
+	}
+	return c(src, safe), nil
+}
+
+func init() {
+	RegisterFormat("md", markdown)
+	RegisterFormat("html", rawHtml)
+	RegisterFormat("text", plainText)
+	RegisterFormat("adoc", asciidoc)
+}
+
+func markdown(src []byte, safe bool) []byte {
+	if !safe {
+		return blackfriday.MarkdownCommon(src)
+	}
+	flags := blackfriday.HTML_USE_XHTML | blackfriday.HTML_SKIP_HTML | blackfriday.HTML_SKIP_STYLE | blackfriday.HTML_SAFELINK | blackfriday.HTML_NOFOLLOW_LINKS
+	ext := blackfriday.EXTENSION_NO_INTRA_EMPHASIS | blackfriday.EXTENSION_FENCED_CODE | blackfriday.EXTENSION_AUTOLINK | blackfriday.EXTENSION_STRIKETHROUGH
+	return blackfriday.Markdown(src, blackfriday.HtmlRenderer(flags, "", ""), ext)
+}
+
+// User submitted HTML goes trough the default allowlist of the sanitize package.
+func rawHtml(src []byte, safe bool) []byte {
+	if safe {
+		return []byte(sanitize.Sanitize(string(src)))
+	}
+	return src
+}
+
+// Paragraphs separated by empty lines, the line breaks are kept.
+func plainText(src []byte, safe bool) []byte {
+	ret := []string{}
+	for _, v := range paragraphs(string(src)) {
+		ret = append(ret, "<p>"+strings.Join(escapeLines(v, safe), "<br />\n")+"</p>")
+	}
+	return []byte(strings.Join(ret, "\n"))
+}
+
+// Trusted sources are not escaped, they are templates and may contain template actions.
+func escape(s string, safe bool) string {
+	if safe {
+		return template.HTMLEscapeString(s)
+	}
+	return s
+}
+
+func escapeLines(lines []string, safe bool) []string {
+	ret := []string{}
+	for _, v := range lines {
+		ret = append(ret, escape(v, safe))
+	}
+	return ret
+}
+
+// Splits the source into blocks of lines at the empty lines.
+func paragraphs(src string) [][]string {
+	ret := [][]string{}
+	cur := []string{}
+	for _, v := range strings.Split(strings.Replace(src, "\r\n", "\n", -1), "\n") {
+		if len(strings.TrimSpace(v)) == 0 {
+			if len(cur) > 0 {
+				ret = append(ret, cur)
+				cur = []string{}
+			}
+			continue
+		}
+		cur = append(cur, v)
+	}
+	if len(cur) > 0 {
+		ret = append(ret, cur)
+	}
+	return ret
+}
+
+var (
+	adoc_title_rx  = regexp.MustCompile(`^(={1,6}) (.+)$`)
+	adoc_link_rx   = regexp.MustCompile(`(?:link:)?((?:https?://|/)[^\s\[]*)\[([^\]]*)\]`)
+	adoc_inline_rx = []struct {
+		rx   *regexp.Regexp
+		repl string
+	}{
+		{regexp.MustCompile(`\*([^*\s][^*]*)\*`), "<strong>$1</strong>"},
+		{regexp.MustCompile(`\b_([^_\s][^_]*)_\b`), "<em>$1</em>"},
+		{regexp.MustCompile("`([^`]+)`"), "<code>$1</code>"},
+	}
+)
+
+// Inline formatting of an already escaped line.
+func adocInline(s string) string {
+	for _, v := range adoc_inline_rx {
+		s = v.rx.ReplaceAllString(s, v.repl)
+	}
+	return adoc_link_rx.ReplaceAllStringFunc(s, func(m string) string {
+		sub := adoc_link_rx.FindStringSubmatch(m)
+		text := sub[2]
+		if len(text) == 0 {
+			text = sub[1]
+		}
+		return `<a href="` + sub[1] + `">` + text + `</a>`
+	})
+}
+
+func adocList(lines []string, prefix, tag string, safe bool) (string, bool) {
+	items := []string{}
+	for _, v := range lines {
+		if !strings.HasPrefix(v, prefix) {
+			return "", false
+		}
+		items = append(items, "<li>"+adocInline(escape(v[len(prefix):], safe))+"</li>")
+	}
+	return "<" + tag + ">\n" + strings.Join(items, "\n") + "\n</" + tag + ">", true
+}
+
+// A small subset of AsciiDoc: titles (= to ======), paragraphs, lists (* and .), listing blocks between ---- lines,
+// *strong*, _emphasis_, `code` and links like http://example.com[text]. Passthrough blocks between ++++ lines are only
+// kept as raw HTML if the source is trusted.
+func asciidoc(src []byte, safe bool) []byte {
+	out := []string{}
+	lines := strings.Split(strings.Replace(string(src), "\r\n", "\n", -1), "\n")
+	para := []string{}
+	flush := func() {
+		if len(para) == 0 {
+			return
+		}
+		if list, ok := adocList(para, "* ", "ul", safe); ok {
+			out = append(out, list)
+		} else if list, ok := adocList(para, ". ", "ol", safe); ok {
+			out = append(out, list)
+		} else {
+			ls := []string{}
+			for _, v := range escapeLines(para, safe) {
+				ls = append(ls, adocInline(v))
+			}
+			out = append(out, "<p>"+strings.Join(ls, "\n")+"</p>")
+		}
+		para = []string{}
+	}
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case trimmed == "----" || trimmed == "++++":
+			flush()
+			block := []string{}
+			for i++; i < len(lines) && strings.TrimSpace(lines[i]) != trimmed; i++ {
+				block = append(block, lines[i])
+			}
+			if trimmed == "----" || safe {
+				out = append(out, "<pre>"+strings.Join(escapeLines(block, true), "\n")+"</pre>")
+			} else {
+				out = append(out, strings.Join(block, "\n"))
+			}
+		case len(trimmed) == 0:
+			flush()
+		case adoc_title_rx.MatchString(line):
+			flush()
+			sub := adoc_title_rx.FindStringSubmatch(line)
+			n := fmt.Sprint(len(sub[1]))
+			out = append(out, "<h"+n+">"+adocInline(escape(sub[2], safe))+"</h"+n+">")
+		default:
+			para = append(para, line)
+		}
+	}
+	flush()
+	return []byte(strings.Join(out, "\n"))
+}
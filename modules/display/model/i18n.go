@@ -0,0 +1,253 @@
+package display_model
+
+// Localization: the strings of the templates come from JSON files, one per name and language:
+// <template>/loc/<name>.<lang> and modules/<name>/tpl/loc/<lang>.json, the first one wins key by key.
+// Every language of the user is read, a key missing in the preferred one falls back to the next language having it.
+//
+// Templates can access the strings as .loc.<name>.<key>, or format them with the t builtin:
+//
+//	"comments": {"zero": "No comments yet", "one": "{count} comment", "other": "{count} comments"},
+//	"welcome": "Welcome back, {name}!"
+//
+//	{{t "content.comments" "count" .content.comment_count}} {{t "user.welcome" "name" .user.name}}
+//
+// A map of plural categories (see PluralCategory) is chosen by the "count" argument.
+// In debug mode the keys t could not find are reported, see the display module.
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Converts any number, or a string holding one, to float64.
+func ToFloat(i interface{}) (float64, error) {
+	v := reflect.ValueOf(i)
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint()), nil
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), nil
+	case reflect.String:
+		return strconv.ParseFloat(strings.TrimSpace(v.String()), 64)
+	}
+	return 0, fmt.Errorf("%v is not a number.", i)
+}
+
+// Plural category of n in a language, following the CLDR names: "zero", "one", "few", "many" and "other".
+func PluralCategory(lang string, n float64) string {
+	if i := strings.IndexAny(lang, "-_"); i != -1 {
+		lang = lang[:i]
+	}
+	integer := n == math.Trunc(n)
+	i := int64(math.Abs(n))
+	switch lang {
+	case "ja", "zh", "ko", "vi", "th", "id", "tr":
+		return "other"
+	case "fr", "pt":
+		if integer && (i == 0 || i == 1) {
+			return "one"
+		}
+		return "other"
+	case "ru", "uk", "be", "sr", "hr", "bs":
+		if !integer {
+			return "other"
+		}
+		switch {
+		case i%10 == 1 && i%100 != 11:
+			return "one"
+		case i%10 >= 2 && i%10 <= 4 && (i%100 < 12 || i%100 > 14):
+			return "few"
+		}
+		return "many"
+	case "pl":
+		if !integer {
+			return "other"
+		}
+		switch {
+		case i == 1:
+			return "one"
+		case i%10 >= 2 && i%10 <= 4 && (i%100 < 12 || i%100 > 14):
+			return "few"
+		}
+		return "many"
+	case "cs", "sk":
+		switch {
+		case !integer:
+			return "many"
+		case i == 1:
+			return "one"
+		case i >= 2 && i <= 4:
+			return "few"
+		}
+		return "other"
+	}
+	if integer && i == 1 {
+		return "one"
+	}
+	return "other"
+}
+
+var plural_categories = map[string]bool{"zero": true, "one": true, "two": true, "few": true, "many": true, "other": true}
+
+// Tells if m holds the forms of a plural rather than nested strings.
+func IsPlural(m map[string]interface{}) bool {
+	if _, has := m["other"]; !has {
+		return false
+	}
+	for i := range m {
+		if !plural_categories[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Chooses the form of n. "zero" is used for 0 in every language if present, "other" if the category of n is missing.
+func PickPlural(lang string, n float64, forms map[string]interface{}) interface{} {
+	if zero, has := forms["zero"]; has && n == 0 {
+		return zero
+	}
+	if f, has := forms[PluralCategory(lang, n)]; has {
+		return f
+	}
+	return forms["other"]
+}
+
+// Fills the keys missing from to with the ones of from, nested maps are merged too.
+// Plurals are taken as a whole, the forms of two languages must not be mixed.
+func fillMissing(to, from map[string]interface{}) {
+	for i, v := range from {
+		cur, has := to[i]
+		if !has {
+			to[i] = v
+			continue
+		}
+		cm, ok1 := cur.(map[string]interface{})
+		fm, ok2 := v.(map[string]interface{})
+		if ok1 && ok2 && !IsPlural(cm) && !IsPlural(fm) {
+			fillMissing(cm, fm)
+		}
+	}
+}
+
+// Reading a file which does not exist is not an error here, most names have no file in most languages.
+func locErr(path string, err error) error {
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return fmt.Errorf("%v: %v", path, err)
+}
+
+// Replaces the {name} placeholders of msg with the params, the unknown ones are left as they are.
+func Format(msg string, params map[string]interface{}) string {
+	ret := ""
+	for {
+		beg := strings.IndexByte(msg, '{')
+		if beg == -1 {
+			break
+		}
+		end := strings.IndexByte(msg[beg:], '}')
+		if end == -1 {
+			break
+		}
+		if v, has := params[msg[beg+1:beg+end]]; has {
+			ret += msg[:beg] + fmt.Sprint(v)
+		} else {
+			ret += msg[:beg+end+1]
+		}
+		msg = msg[beg+end+1:]
+	}
+	return ret + msg
+}
+
+// Value of a dotted path in the loc map, nil if it is not there.
+func lookup(loc map[string]interface{}, key string) interface{} {
+	var i interface{} = loc
+	for _, v := range strings.Split(key, ".") {
+		m, ok := i.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		i = m[v]
+	}
+	return i
+}
+
+// Arguments come as key value pairs or as one map.
+func params(args []interface{}) (map[string]interface{}, error) {
+	if len(args) == 1 {
+		if m, ok := args[0].(map[string]interface{}); ok {
+			return m, nil
+		}
+	}
+	if len(args)%2 == 1 {
+		return nil, fmt.Errorf("Arguments of a message must come in key value pairs.")
+	}
+	ret := map[string]interface{}{}
+	for i := 0; i < len(args); i += 2 {
+		ret[fmt.Sprint(args[i])] = args[i+1]
+	}
+	return ret, nil
+}
+
+// Formats the messages of one page, remembering the keys it could not find.
+type Translator struct {
+	loc     map[string]interface{}
+	lang    string
+	mut     sync.Mutex
+	missing map[string]struct{}
+}
+
+// lang is the preferred language of the user, it decides the plural categories.
+func NewTranslator(loc map[string]interface{}, lang string) *Translator {
+	return &Translator{loc: loc, lang: lang, missing: map[string]struct{}{}}
+}
+
+// Looks up key ("<name>.<key>", can be nested deeper) and formats it with the arguments.
+// A missing key is returned as it is, so the page can still be displayed.
+func (t *Translator) T(key string, args ...interface{}) (string, error) {
+	p, err := params(args)
+	if err != nil {
+		return "", err
+	}
+	msg := lookup(t.loc, key)
+	if m, ok := msg.(map[string]interface{}); ok && IsPlural(m) {
+		c, has := p["count"]
+		if !has {
+			return "", fmt.Errorf("Message %v is a plural, it needs a count.", key)
+		}
+		n, err := ToFloat(c)
+		if err != nil {
+			return "", err
+		}
+		msg = PickPlural(t.lang, n, m)
+	}
+	s, ok := msg.(string)
+	if !ok {
+		t.mut.Lock()
+		t.missing[key] = struct{}{}
+		t.mut.Unlock()
+		return key, nil
+	}
+	return Format(s, p), nil
+}
+
+// The keys which were asked for but could not be found, sorted.
+func (t *Translator) Missing() []string {
+	t.mut.Lock()
+	defer t.mut.Unlock()
+	ret := []string{}
+	for i := range t.missing {
+		ret = append(ret, i)
+	}
+	sort.Strings(ret)
+	return ret
+}
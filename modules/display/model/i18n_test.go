@@ -0,0 +1,97 @@
+package display_model
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestReadFiles(t *testing.T) {
+	files := map[string]string{
+		"/r/tpl/loc/content.hu":              `{"title": "Cím", "menu": {"home": "Főoldal"}, "comments": {"one": "{count} hozzászólás", "other": "{count} hozzászólás"}}`,
+		"/r/modules/content/tpl/loc/hu.json": `{"title": "Modul cím", "save": "Mentés"}`,
+		"/r/tpl/loc/content.en":              `{"title": "Title", "menu": {"home": "Home", "about": "About"}, "comments": {"zero": "No comments", "one": "{count} comment", "other": "{count} comments"}, "back": "Back"}`,
+		"/r/tpl/loc/broken.en":               `{"a": `,
+	}
+	reader := func(s string) (map[string]interface{}, error) {
+		f, has := files[s]
+		if !has {
+			return nil, &os.PathError{Op: "open", Path: s, Err: os.ErrNotExist}
+		}
+		var v map[string]interface{}
+		err := json.Unmarshal([]byte(f), &v)
+		return v, err
+	}
+	names := map[string]struct{}{"content": {}, "broken": {}, "nothing": {}}
+	loc, err := ReadFiles("/r", "tpl", []string{"hu", "en"}, names, reader)
+	if err == nil {
+		t.Fatal("The broken file should be reported.")
+	}
+	expected := map[string]interface{}{
+		"title":    "Cím",
+		"save":     "Mentés",
+		"back":     "Back",
+		"menu":     map[string]interface{}{"home": "Főoldal", "about": "About"},
+		"comments": map[string]interface{}{"one": "{count} hozzászólás", "other": "{count} hozzászólás"},
+	}
+	if !reflect.DeepEqual(loc["content"], expected) {
+		t.Fatal(loc["content"])
+	}
+	if _, has := loc["nothing"]; has {
+		t.Fatal(loc)
+	}
+}
+
+func TestTranslator(t *testing.T) {
+	loc := map[string]interface{}{
+		"user": map[string]interface{}{
+			"welcome":  "Welcome back, {name}! {unknown}",
+			"comments": map[string]interface{}{"zero": "No comments", "one": "{count} comment", "other": "{count} comments"},
+			"files":    map[string]interface{}{"one": "{count} файл", "few": "{count} файла", "many": "{count} файлов", "other": "{count} файла"},
+		},
+	}
+	tr := NewTranslator(loc, "en")
+	cases := []struct {
+		key  string
+		args []interface{}
+		out  string
+	}{
+		{"user.welcome", []interface{}{"name", "Joe"}, "Welcome back, Joe! {unknown}"},
+		{"user.welcome", []interface{}{map[string]interface{}{"name": "Ann"}}, "Welcome back, Ann! {unknown}"},
+		{"user.comments", []interface{}{"count", 0}, "No comments"},
+		{"user.comments", []interface{}{"count", 1}, "1 comment"},
+		{"user.comments", []interface{}{"count", "5"}, "5 comments"},
+		{"user.missing", nil, "user.missing"},
+		{"nothing.at.all", nil, "nothing.at.all"},
+	}
+	for _, v := range cases {
+		out, err := tr.T(v.key, v.args...)
+		if err != nil || out != v.out {
+			t.Errorf("%v %v: %v %v", v.key, v.args, out, err)
+		}
+	}
+	if m := tr.Missing(); fmt.Sprint(m) != "[nothing.at.all user.missing]" {
+		t.Fatal(m)
+	}
+	if _, err := tr.T("user.comments"); err == nil {
+		t.Fatal("A plural needs a count.")
+	}
+	if _, err := tr.T("user.welcome", "name"); err == nil {
+		t.Fatal("Odd number of arguments.")
+	}
+	ru := NewTranslator(loc, "ru")
+	for n, s := range map[int]string{1: "1 файл", 3: "3 файла", 11: "11 файлов", 21: "21 файл"} {
+		if out, _ := ru.T("user.files", "count", n); out != s {
+			t.Errorf("%v: %v", n, out)
+		}
+	}
+}
+
+func TestCollectFromTempl(t *testing.T) {
+	c := CollectFromTempl(`{{.loc.menu.home}} {{t "content.comments" "count" 1}} {{ t "user.welcome"}}`)
+	if !reflect.DeepEqual(c, map[string]struct{}{"menu": {}, "content": {}, "user": {}}) {
+		t.Fatal(c)
+	}
+}
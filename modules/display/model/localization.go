@@ -2,9 +2,11 @@ package display_model
 
 import (
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
 	"path/filepath"
 	"regexp"
+	"sort"
 	"strings"
 )
 
@@ -45,12 +47,14 @@ func locReader(s string) (map[string]interface{}, error) {
 	if err != nil {
 		return nil, err
 	}
-	var v interface{}
+	var v map[string]interface{}
 	err = json.Unmarshal(file, &v)
-	return v.(map[string]interface{}), err
+	return v, err
 }
 
-// Extracts used multilingual variables from a template with regexp.
+var t_rx = regexp.MustCompile(`\bt "([a-zA-Z_:/-]+)\.`)
+
+// Extracts used multilingual variables from a template with regexp, the .loc.<name>.<key> ones and the names of the {{t "<name>.<key>"}} calls.
 func CollectFromTempl(file_content string) map[string]struct{} {
 	r := regexp.MustCompile(".loc.([a-zA-Z_.:/-])*")
 	s := r.FindAllString(file_content, -1)
@@ -61,6 +65,9 @@ func CollectFromTempl(file_content string) map[string]struct{} {
 			c[spl[2]] = struct{}{}
 		}
 	}
+	for _, v := range t_rx.FindAllStringSubmatch(file_content, -1) {
+		c[v[1]] = struct{}{}
+	}
 	return c
 }
 
@@ -73,24 +80,38 @@ func CollectFromMap(dat map[string]interface{}) map[string]struct{} {
 	return c
 }
 
-// Takes a list of localization filenames and tries to load every one of them, first from the template, then from the modules.
+// Takes a list of localization filenames and loads every one of them in all the languages of the user, from the template and from the modules.
+// The files are merged key by key: the earlier language wins, and in the same language the template wins over the module.
+// The files which do not exist are skipped, the ones which can't be read or parsed are reported in the error, the rest is still returned.
 func ReadFiles(root, tplpath string, user_langs []string, locfiles map[string]struct{}, loc_reader func(s string) (map[string]interface{}, error)) (map[string]interface{}, error) {
 	ret := map[string]interface{}{}
+	errs := []string{}
 	for i, _ := range locfiles {
+		var merged map[string]interface{}
 		for _, lang := range user_langs {
-			path := filepath.Join(root, tplpath, "loc", i+"."+lang)
-			ma, err := loc_reader(path)
-			if err == nil {
-				ret[i] = ma
-				break
-			}
-			path = filepath.Join(root, "modules", i, "tpl/loc", lang+".json")
-			ma, err = loc_reader(path)
-			if err == nil {
-				ret[i] = ma
-				break
+			paths := []string{filepath.Join(root, tplpath, "loc", i+"."+lang), filepath.Join(root, "modules", i, "tpl/loc", lang+".json")}
+			for _, path := range paths {
+				ma, err := loc_reader(path)
+				if err != nil {
+					if err = locErr(path, err); err != nil {
+						errs = append(errs, err.Error())
+					}
+					continue
+				}
+				if merged == nil {
+					merged = ma
+				} else {
+					fillMissing(merged, ma)
+				}
 			}
 		}
+		if merged != nil {
+			ret[i] = merged
+		}
+	}
+	if len(errs) > 0 {
+		sort.Strings(errs)
+		return ret, fmt.Errorf("Can't load localization: %v", strings.Join(errs, "; "))
 	}
 	return ret, nil
 }
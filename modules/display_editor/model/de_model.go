@@ -66,6 +66,8 @@ func Save(db *mgo.Database, ev ifaces.Event, inp map[string][]string) error {
 			case "so":
 			case "p":
 			case "ex":
+			case "r":
+			case "rev":
 			default:
 				return fmt.Errorf("Nonsensical field %v.", i)
 			}
@@ -0,0 +1,154 @@
+// Package feeds serves RSS and Atom feeds:
+//
+//	/rss, /atom                                   everything listed at a display point (index by default)
+//	/rss/type/{type}, /atom/type/{type}           contents of a type
+//	/rss/tag/{slug}, /atom/tag/{slug}             contents of a tag and its descendants
+//	/rss/comments/{slug}, /atom/comments/{slug}   comments of a content
+package feeds
+
+import (
+	"fmt"
+	"github.com/opesun/hypecms/api/context"
+	"github.com/opesun/hypecms/modules/content/model"
+	"github.com/opesun/hypecms/modules/feeds/model"
+	"github.com/opesun/jsonp"
+	"labix.org/v2/mgo/bson"
+	"net/http"
+	"strings"
+	"time"
+)
+
+func (h *H) Front() (bool, error) {
+	uni := h.uni
+	parts := strings.Split(strings.Trim(uni.P, "/"), "/")
+	format := parts[0]
+	if format != feeds_model.Rss && format != feeds_model.Atom {
+		return false, nil
+	}
+	title, point, limit, excerpt := feeds_model.Settings(uni.Opt)
+	base := "http://" + uni.Req.Host
+	feed := &feeds_model.Feed{
+		Title: title,
+		Link:  base + "/",
+		Self:  base + uni.P,
+	}
+	var err error
+	switch {
+	case len(parts) == 1:
+		err = h.contents(feed, feeds_model.SiteQuery(uni.Opt, point, limit), base, excerpt)
+	case len(parts) == 3 && parts[1] == "type":
+		if _, has := jsonp.Get(uni.Opt, "Modules.content.types."+parts[2]); !has {
+			return false, nil
+		}
+		feed.Title += " - " + parts[2]
+		err = h.contents(feed, feeds_model.TypeQuery(uni.Opt, point, parts[2], limit), base, excerpt)
+	case len(parts) == 3 && parts[1] == "tag":
+		q, terr := feeds_model.TagQuery(uni.Db, parts[2], limit)
+		if terr != nil {
+			return false, nil
+		}
+		feed.Title += " - " + parts[2]
+		feed.Link = base + "/tag/" + parts[2]
+		err = h.contents(feed, q, base, excerpt)
+	case len(parts) == 3 && parts[1] == "comments":
+		content, found := content_model.FindContent(uni.Db, []string{"slug"}, parts[2])
+		if !found {
+			return false, nil
+		}
+		err = h.comments(feed, content, base, limit, excerpt)
+	default:
+		return false, nil
+	}
+	if err != nil {
+		return true, err
+	}
+	return true, h.write(feed, format)
+}
+
+func (h *H) contents(feed *feeds_model.Feed, query map[string]interface{}, base string, excerpt int) error {
+	list, err := feeds_model.Run(h.uni.Db, query)
+	if err != nil {
+		return err
+	}
+	for _, v := range list {
+		feed.Items = append(feed.Items, feeds_model.ContentItem(base, v, excerpt))
+	}
+	return nil
+}
+
+func (h *H) comments(feed *feeds_model.Feed, content map[string]interface{}, base string, limit, excerpt int) error {
+	id, ok := content["_id"].(bson.ObjectId)
+	if !ok {
+		return fmt.Errorf("Content has no id.")
+	}
+	title, _ := content["title"].(string)
+	feed.Title = "Comments on " + title
+	feed.Link = base + content_model.UrlOf(content)
+	list, err := feeds_model.Run(h.uni.Db, feeds_model.CommentsQuery(id, limit))
+	if err != nil {
+		return err
+	}
+	for _, v := range list {
+		feed.Items = append(feed.Items, feeds_model.CommentItem(base, content, v, excerpt))
+	}
+	return nil
+}
+
+// Answers with 304 Not Modified if the client already has the current version.
+func (h *H) write(feed *feeds_model.Feed, format string) error {
+	uni := h.uni
+	etag := feed.ETag(format)
+	var updated time.Time // Stays zero for an empty feed.
+	header := uni.W.Header()
+	header.Set("ETag", etag)
+	if feed.Updated() > 0 {
+		updated = time.Unix(feed.Updated(), 0).UTC()
+		header.Set("Last-Modified", updated.Format(http.TimeFormat))
+	}
+	uni.Dat["_written"] = true
+	if notModified(uni.Req, etag, updated) {
+		uni.W.WriteHeader(http.StatusNotModified)
+		return nil
+	}
+	out, err := feed.Render(format)
+	if err != nil {
+		return err
+	}
+	header.Set("Content-Type", feeds_model.ContentType(format))
+	uni.W.Write(out)
+	return nil
+}
+
+// If-None-Match takes precedence over If-Modified-Since. The date is only compared when the feed has one,
+// a client could not have seen an empty feed at any date.
+func notModified(req *http.Request, etag string, updated time.Time) bool {
+	if inm := req.Header.Get("If-None-Match"); len(inm) > 0 {
+		for _, v := range strings.Split(inm, ",") {
+			if v = strings.TrimSpace(v); v == etag || v == "*" {
+				return true
+			}
+		}
+		return false
+	}
+	if updated.IsZero() {
+		return false
+	}
+	since, err := http.ParseTime(req.Header.Get("If-Modified-Since"))
+	return err == nil && !updated.After(since)
+}
+
+func (h *H) Install(id bson.ObjectId) error {
+	return feeds_model.Install(h.uni.Db, id)
+}
+
+func (h *H) Uninstall(id bson.ObjectId) error {
+	return feeds_model.Uninstall(h.uni.Db, id)
+}
+
+type H struct {
+	uni *context.Uni
+}
+
+func Hooks(uni *context.Uni) *H {
+	return &H{uni}
+}
@@ -0,0 +1,31 @@
+package feeds
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestNotModified(t *testing.T) {
+	updated := time.Date(2013, 4, 1, 12, 0, 0, 0, time.UTC)
+	req := func(header, val string) *http.Request {
+		r, _ := http.NewRequest("GET", "/feed/rss", nil)
+		r.Header.Set(header, val)
+		return r
+	}
+	if !notModified(req("If-None-Match", `"a", "b"`), `"b"`, updated) {
+		t.Fatal("Matching etag.")
+	}
+	if notModified(req("If-None-Match", `"a"`), `"b"`, updated) {
+		t.Fatal("Other etag.")
+	}
+	if !notModified(req("If-Modified-Since", updated.Format(http.TimeFormat)), `"b"`, updated) {
+		t.Fatal("Not modified since.")
+	}
+	if notModified(req("If-Modified-Since", updated.Add(-time.Hour).Format(http.TimeFormat)), `"b"`, updated) {
+		t.Fatal("Modified since.")
+	}
+	if notModified(req("If-Modified-Since", updated.Format(http.TimeFormat)), `"b"`, time.Time{}) {
+		t.Fatal("Empty feed has no date to compare.")
+	}
+}
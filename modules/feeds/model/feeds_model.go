@@ -0,0 +1,418 @@
+// Package feeds_model builds RSS 2.0 and Atom feeds out of contents and comments.
+package feeds_model
+
+import (
+	"crypto/md5"
+	"encoding/xml"
+	"fmt"
+	"github.com/opesun/hypecms/model/basic"
+	"github.com/opesun/hypecms/modules/content/model"
+	"github.com/opesun/hypecms/modules/display/model"
+	"github.com/opesun/jsonp"
+	"labix.org/v2/mgo"
+	"labix.org/v2/mgo/bson"
+	"regexp"
+	"strings"
+	"time"
+)
+
+type m map[string]interface{}
+
+const (
+	Rss  = "rss"
+	Atom = "atom"
+)
+
+type Item struct {
+	Title, Link, Id, Summary, Author string
+	Published, Updated               int64
+}
+
+type Feed struct {
+	Title, Link, Self string
+	Items             []Item
+}
+
+// Time of the last change in the feed, 0 if it is empty.
+func (f *Feed) Updated() int64 {
+	var u int64
+	for _, v := range f.Items {
+		if v.Updated > u {
+			u = v.Updated
+		}
+	}
+	return u
+}
+
+// Changes whenever an item is added, removed or modified.
+func (f *Feed) ETag(format string) string {
+	h := md5.New()
+	fmt.Fprint(h, format, f.Self)
+	for _, v := range f.Items {
+		fmt.Fprint(h, v.Id, v.Updated)
+	}
+	return fmt.Sprintf(`"%x"`, h.Sum(nil))
+}
+
+// Settings under "Modules.feeds": "limit" (number of items), "excerpt" (length of summaries in characters),
+// "title" and "point" (the display point whose queries the feeds use).
+func Settings(opt map[string]interface{}) (title, point string, limit, excerpt int) {
+	title, point, limit, excerpt = "hypeCMS", "index", 20, 300
+	if t, ok := jsonp.GetStr(opt, "Modules.feeds.title"); ok && len(t) > 0 {
+		title = t
+	}
+	if p, ok := jsonp.GetStr(opt, "Modules.feeds.point"); ok && len(p) > 0 {
+		point = p
+	}
+	if l, ok := jsonp.Get(opt, "Modules.feeds.limit"); ok && toInt64(l) > 0 {
+		limit = int(toInt64(l))
+	}
+	if e, ok := jsonp.Get(opt, "Modules.feeds.excerpt"); ok && toInt64(e) > 0 {
+		excerpt = int(toInt64(e))
+	}
+	return
+}
+
+func toInt64(i interface{}) int64 {
+	switch v := i.(type) {
+	case int64:
+		return v
+	case int:
+		return int64(v)
+	case float64:
+		return int64(v)
+	}
+	return 0
+}
+
+// Copies a display query and turns it into a feed query: no paging, newest first, at most limit items.
+func feedQuery(query map[string]interface{}, limit int) map[string]interface{} {
+	q := map[string]interface{}{}
+	for i, v := range query {
+		switch i {
+		case "p", "sk", "so", "ex", "rev":
+		default:
+			q[i] = v
+		}
+	}
+	q["c"] = content_model.Cname
+	q["so"] = "-" + basic.Created
+	q["l"] = limit
+	return q
+}
+
+// The query of the given content type at the display point, or the default listing if it has none.
+func TypeQuery(opt map[string]interface{}, point, typ string, limit int) map[string]interface{} {
+	q, ok := content_model.TypeQueries(opt, typ)[point].(map[string]interface{})
+	if !ok {
+		q = content_model.DefaultTypeQuery(typ)
+	}
+	return feedQuery(q, limit)
+}
+
+// Everything listed at the display point, merged into one query.
+func SiteQuery(opt map[string]interface{}, point string, limit int) map[string]interface{} {
+	queries, _ := jsonp.GetM(opt, "Display-points."+point+".queries")
+	or := []interface{}{}
+	for _, v := range queries {
+		vm, ok := v.(map[string]interface{})
+		if !ok || vm["c"] != content_model.Cname {
+			continue
+		}
+		if q, ok := vm["q"].(map[string]interface{}); ok {
+			or = append(or, q)
+		}
+	}
+	q := map[string]interface{}{}
+	if len(or) > 0 {
+		q["$or"] = or
+	}
+	return feedQuery(m{"q": q}, limit)
+}
+
+// Contents tagged with the tag having the given slug, or its descendants.
+// If there is no such tag, the tags with slugs starting with slug are used.
+func TagQuery(db *mgo.Database, slug string, limit int) (map[string]interface{}, error) {
+	tags := []interface{}{}
+	tag, err := content_model.FindTag(db, "slug", slug)
+	if err == nil {
+		tags = append(tags, tag)
+	} else {
+		err = db.C(content_model.Tag_cname).Find(content_model.TagSearchQuery("slug", regexp.QuoteMeta(slug))).Limit(limit).All(&tags)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(tags) == 0 {
+		return nil, fmt.Errorf("Can't find tag %v.", slug)
+	}
+	ids := []bson.ObjectId{}
+	for _, v := range tags {
+		tag_id, ok := basic.Convert(v).(map[string]interface{})["_id"].(bson.ObjectId)
+		if !ok {
+			continue
+		}
+		desc, err := content_model.TagAndDescendants(db, tag_id)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, desc...)
+	}
+	return feedQuery(m{"q": m{content_model.Tag_fieldname: m{"$in": ids}}}, limit), nil
+}
+
+// The newest approved comments of a content.
+func CommentsQuery(content_id bson.ObjectId, limit int) map[string]interface{} {
+	q := content_model.CommentsQuery(content_id, limit)
+	delete(q, "p")
+	q["so"] = "-" + basic.Created
+	return q
+}
+
+var tag_rx = regexp.MustCompile(`<[^>]*>`)
+
+// Summaries are plain text.
+func summary(html string, excerpt int) string {
+	text := strings.Join(strings.Fields(tag_rx.ReplaceAllString(html, " ")), " ")
+	short := display_model.Excerpt(text, excerpt)
+	if len(short) < len(text) {
+		short += "..."
+	}
+	return short
+}
+
+func authorOf(doc map[string]interface{}) string {
+	name, _ := jsonp.GetStr(doc, "_users_created_by.name")
+	return name
+}
+
+func dates(doc map[string]interface{}) (int64, int64) {
+	created := toInt64(doc[basic.Created])
+	modified := toInt64(doc[basic.Last_modified])
+	if modified < created {
+		modified = created
+	}
+	return created, modified
+}
+
+// base is the scheme and host of the site, eg. "http://example.com".
+func ContentItem(base string, content map[string]interface{}, excerpt int) Item {
+	link := base + content_model.UrlOf(content)
+	created, modified := dates(content)
+	title, _ := content["title"].(string)
+	text, _ := content["content"].(string)
+	return Item{
+		Title:     title,
+		Link:      link,
+		Id:        link,
+		Summary:   summary(text, excerpt),
+		Author:    authorOf(content),
+		Published: created,
+		Updated:   modified,
+	}
+}
+
+func CommentItem(base string, content, comment map[string]interface{}, excerpt int) Item {
+	link := base + content_model.UrlOf(content)
+	if id, ok := comment["_id"].(bson.ObjectId); ok {
+		link += "#comment-" + id.Hex()
+	}
+	created, modified := dates(comment)
+	author := authorOf(comment)
+	title, _ := content["title"].(string)
+	if len(author) > 0 {
+		title = author + " on " + title
+	}
+	text, _ := comment["comment_content"].(string)
+	return Item{
+		Title:     title,
+		Link:      link,
+		Id:        link,
+		Summary:   summary(text, excerpt),
+		Author:    author,
+		Published: created,
+		Updated:   modified,
+	}
+}
+
+// Runs a feed query, documents are resolved just like at display points.
+func Run(db *mgo.Database, query map[string]interface{}) ([]map[string]interface{}, error) {
+	res := display_model.RunQuery(db, "feed", query, nil, "")
+	list, ok := res["feed"].([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("Feed query failed: %v", res["feed"])
+	}
+	ret := []map[string]interface{}{}
+	for _, v := range list {
+		if vm, ok := v.(map[string]interface{}); ok {
+			ret = append(ret, vm)
+		}
+	}
+	return ret, nil
+}
+
+type rssGuid struct {
+	IsPermaLink string `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+type rssItem struct {
+	Title       string  `xml:"title"`
+	Link        string  `xml:"link"`
+	Description string  `xml:"description"`
+	Creator     string  `xml:"dc:creator,omitempty"`
+	Guid        rssGuid `xml:"guid"`
+	PubDate     string  `xml:"pubDate"`
+}
+
+type rssChannel struct {
+	Title         string    `xml:"title"`
+	Link          string    `xml:"link"`
+	Description   string    `xml:"description"`
+	LastBuildDate string    `xml:"lastBuildDate,omitempty"`
+	Items         []rssItem `xml:"item"`
+}
+
+type rss struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Dc      string     `xml:"xmlns:dc,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type atomLink struct {
+	Rel  string `xml:"rel,attr"`
+	Href string `xml:"href,attr"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type atomEntry struct {
+	Title     string      `xml:"title"`
+	Id        string      `xml:"id"`
+	Link      atomLink    `xml:"link"`
+	Published string      `xml:"published"`
+	Updated   string      `xml:"updated"`
+	Summary   string      `xml:"summary"`
+	Author    *atomAuthor `xml:"author,omitempty"`
+}
+
+type atom struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title   string      `xml:"title"`
+	Id      string      `xml:"id"`
+	Updated string      `xml:"updated"`
+	Links   []atomLink  `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+func rssDate(t int64) string {
+	return time.Unix(t, 0).UTC().Format(time.RFC1123Z)
+}
+
+func atomDate(t int64) string {
+	return time.Unix(t, 0).UTC().Format(time.RFC3339)
+}
+
+func (f *Feed) rss() interface{} {
+	ch := rssChannel{
+		Title:       f.Title,
+		Link:        f.Link,
+		Description: f.Title,
+	}
+	if u := f.Updated(); u > 0 {
+		ch.LastBuildDate = rssDate(u)
+	}
+	for _, v := range f.Items {
+		ch.Items = append(ch.Items, rssItem{
+			Title:       v.Title,
+			Link:        v.Link,
+			Description: v.Summary,
+			Creator:     v.Author,
+			Guid:        rssGuid{"true", v.Id},
+			PubDate:     rssDate(v.Published),
+		})
+	}
+	return rss{Version: "2.0", Dc: "http://purl.org/dc/elements/1.1/", Channel: ch}
+}
+
+func (f *Feed) atom() interface{} {
+	a := atom{
+		Title:   f.Title,
+		Id:      f.Self,
+		Updated: atomDate(f.Updated()),
+		Links:   []atomLink{{"self", f.Self}, {"alternate", f.Link}},
+	}
+	for _, v := range f.Items {
+		e := atomEntry{
+			Title:     v.Title,
+			Id:        v.Id,
+			Link:      atomLink{"alternate", v.Link},
+			Published: atomDate(v.Published),
+			Updated:   atomDate(v.Updated),
+			Summary:   v.Summary,
+		}
+		if len(v.Author) > 0 {
+			e.Author = &atomAuthor{v.Author}
+		}
+		a.Entries = append(a.Entries, e)
+	}
+	return a
+}
+
+// Renders the feed in the given format (Rss or Atom).
+func (f *Feed) Render(format string) ([]byte, error) {
+	var doc interface{}
+	switch format {
+	case Rss:
+		doc = f.rss()
+	case Atom:
+		doc = f.atom()
+	default:
+		return nil, fmt.Errorf("Unkown feed format %v.", format)
+	}
+	out, err := xml.MarshalIndent(doc, "", "\t")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), out...), nil
+}
+
+func ContentType(format string) string {
+	if format == Atom {
+		return "application/atom+xml; charset=utf-8"
+	}
+	return "application/rss+xml; charset=utf-8"
+}
+
+func Install(db *mgo.Database, id bson.ObjectId) error {
+	q := m{"_id": id}
+	upd := m{
+		"$addToSet": m{
+			"Hooks.Front": "feeds",
+		},
+		"$set": m{
+			"Modules.feeds": m{
+				"limit":   20,
+				"excerpt": 300,
+				"point":   "index",
+			},
+		},
+	}
+	return db.C("options").Update(q, upd)
+}
+
+func Uninstall(db *mgo.Database, id bson.ObjectId) error {
+	q := m{"_id": id}
+	upd := m{
+		"$pull": m{
+			"Hooks.Front": "feeds",
+		},
+		"$unset": m{
+			"Modules.feeds": 1,
+		},
+	}
+	return db.C("options").Update(q, upd)
+}
@@ -0,0 +1,215 @@
+// Package localization is the admin of the loc files of the current template and of the modules: translation coverage per language,
+// side by side editing, and import/export of a language as a gettext PO file.
+package localization
+
+import (
+	"fmt"
+	"github.com/opesun/hypecms/api/context"
+	"github.com/opesun/hypecms/model/scut"
+	"github.com/opesun/hypecms/modules/display/model"
+	"github.com/opesun/hypecms/modules/localization/model"
+	"github.com/opesun/routep"
+	"io/ioutil"
+	"labix.org/v2/mgo/bson"
+	"strings"
+)
+
+type m map[string]interface{}
+
+func tplPath(uni *context.Uni) string {
+	return scut.GetTPath(uni.Opt, uni.Req.Host)
+}
+
+func private(uni *context.Uni) bool {
+	return scut.TemplateType(uni.Opt) == "private"
+}
+
+func load(uni *context.Uni) (*localization_model.Table, error) {
+	return localization_model.Load(uni.Root, tplPath(uni), localization_model.ExtraLangs(uni.Opt))
+}
+
+// PO downloads, only for admins: /localization/export/{lang}?ref=en
+// The msgids are the texts in the ref language.
+func (h *H) Front() (bool, error) {
+	uni := h.uni
+	ma, err := routep.Comp("/localization/export/{lang}", uni.P)
+	if err != nil || len(ma["lang"]) == 0 {
+		return false, nil
+	}
+	if !scut.IsAdmin(uni.Dat["_user"]) {
+		return true, fmt.Errorf("Only admins can export translations.")
+	}
+	t, err := load(uni)
+	if err != nil {
+		return true, err
+	}
+	lang := ma["lang"]
+	known := false
+	for _, v := range t.Langs {
+		if v == lang {
+			known = true
+		}
+	}
+	if !known {
+		return true, fmt.Errorf("Unkown language %v.", lang)
+	}
+	ref := uni.Req.FormValue("ref")
+	if len(ref) == 0 {
+		ref = "en"
+	}
+	uni.W.Header().Set("Content-Type", "text/x-gettext-translation; charset=utf-8")
+	uni.W.Header().Set("Content-Disposition", "attachment; filename="+lang+".po")
+	uni.W.Write(localization_model.ExportPo(t, lang, ref))
+	uni.Dat["_written"] = true
+	return true, nil
+}
+
+func (h *H) Install(id bson.ObjectId) error {
+	return localization_model.Install(h.uni.Db, id)
+}
+
+func (h *H) Uninstall(id bson.ObjectId) error {
+	return localization_model.Uninstall(h.uni.Db, id)
+}
+
+// Saves the edit form of one name. The inputs are called "v:<lang>:<key>", a new key can be added with "new_key" and "new:<lang>".
+func (a *A) Save() error {
+	uni := a.uni
+	name := uni.Req.FormValue("name")
+	if len(name) == 0 {
+		return fmt.Errorf("No name given.")
+	}
+	by_lang := map[string]map[string]string{}
+	set := func(lang, key, val string) {
+		if _, has := by_lang[lang]; !has {
+			by_lang[lang] = map[string]string{}
+		}
+		by_lang[lang][key] = val
+	}
+	for i, v := range uni.Req.Form {
+		if !strings.HasPrefix(i, "v:") || len(v) == 0 {
+			continue
+		}
+		parts := strings.SplitN(i[2:], ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		set(parts[0], parts[1], v[0])
+	}
+	if new_key := strings.TrimSpace(uni.Req.FormValue("new_key")); len(new_key) > 0 {
+		for i, v := range uni.Req.Form {
+			if strings.HasPrefix(i, "new:") && len(v) > 0 && len(v[0]) > 0 {
+				set(i[4:], new_key, v[0])
+			}
+		}
+	}
+	err := localization_model.SaveChanges(uni.Root, tplPath(uni), private(uni), map[string]map[string]map[string]string{name: by_lang})
+	if err != nil {
+		return err
+	}
+	display_model.ClearCache()
+	return nil
+}
+
+// Imports an uploaded PO file ("file" field). The language is taken from the "lang" field, or from the header of the file.
+func (a *A) Import() error {
+	uni := a.uni
+	f, _, err := uni.Req.FormFile("file")
+	if err != nil {
+		return fmt.Errorf("No file was uploaded.")
+	}
+	defer f.Close()
+	data, err := ioutil.ReadAll(f)
+	if err != nil {
+		return err
+	}
+	lang, trs, err := localization_model.ImportPo(data)
+	if err != nil {
+		return err
+	}
+	if l := uni.Req.FormValue("lang"); len(l) > 0 {
+		lang = l
+	}
+	if len(lang) == 0 {
+		return fmt.Errorf("The language of the file is unknown, please specify it.")
+	}
+	changes := map[string]map[string]map[string]string{}
+	for name, values := range trs {
+		changes[name] = map[string]map[string]string{lang: values}
+	}
+	err = localization_model.SaveChanges(uni.Root, tplPath(uni), private(uni), changes)
+	if err != nil {
+		return err
+	}
+	display_model.ClearCache()
+	uni.Dat["_cont"] = map[string]interface{}{"lang": lang}
+	return nil
+}
+
+// Saves the comma separated "languages" field, these get a column even without loc files.
+func (a *A) SaveLangs() error {
+	uni := a.uni
+	return localization_model.SaveLangs(uni.Db, strings.Split(uni.Req.FormValue("languages"), ","))
+}
+
+func (v *V) Index() error {
+	uni := v.uni
+	t, err := load(uni)
+	if err != nil {
+		return err
+	}
+	names := []interface{}{}
+	for _, name := range t.Names {
+		names = append(names, m{"name": name, "keys": len(t.RowsOf(name)), "coverage": t.CoverageOf(name)})
+	}
+	uni.Dat["langs"] = t.Langs
+	uni.Dat["coverage"] = t.Coverage
+	uni.Dat["names"] = names
+	uni.Dat["errors"] = t.Errors
+	uni.Dat["private"] = private(uni)
+	uni.Dat["languages"] = strings.Join(localization_model.ExtraLangs(uni.Opt), ", ")
+	return nil
+}
+
+// The keys of one name with all languages side by side.
+func (v *V) Edit() error {
+	uni := v.uni
+	name := uni.Req.FormValue("name")
+	if len(name) == 0 {
+		return fmt.Errorf("No name given.")
+	}
+	t, err := load(uni)
+	if err != nil {
+		return err
+	}
+	uni.Dat["name"] = name
+	uni.Dat["langs"] = t.Langs
+	uni.Dat["rows"] = t.RowsOf(name)
+	uni.Dat["coverage"] = t.CoverageOf(name)
+	uni.Dat["private"] = private(uni)
+	return nil
+}
+
+type A struct {
+	uni *context.Uni
+}
+
+func Actions(uni *context.Uni) *A {
+	return &A{uni}
+}
+
+type H struct {
+	uni *context.Uni
+}
+
+func Hooks(uni *context.Uni) *H {
+	return &H{uni}
+}
+
+type V struct {
+	uni *context.Uni
+}
+
+func Views(uni *context.Uni) *V {
+	return &V{uni}
+}
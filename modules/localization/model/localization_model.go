@@ -0,0 +1,553 @@
+// Package localization_model reads and writes the loc files of the current template and of the modules, see display_model for the format.
+// All files are flattened into one table of dotted keys ("menu.home", plural forms are keys too: "comments.one"), with a column per language.
+package localization_model
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/opesun/hypecms/model/basic"
+	"github.com/opesun/jsonp"
+	"io/ioutil"
+	"labix.org/v2/mgo"
+	"labix.org/v2/mgo/bson"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type m map[string]interface{}
+
+// Where a loc file lives.
+const (
+	Template = "template" // <template>/loc/<name>.<lang>, only writable in private templates.
+	Module   = "module"   // modules/<name>/tpl/loc/<lang>.json
+)
+
+var ident_rx = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+
+// Names and languages end up in file paths.
+func checkIdent(what, s string) error {
+	if !ident_rx.MatchString(s) {
+		return fmt.Errorf("Invalid %v: %v", what, s)
+	}
+	return nil
+}
+
+type File struct {
+	Name, Lang string
+	Source     string // Template or Module.
+	Path       string
+}
+
+func path(root, tplpath, name, lang, source string) string {
+	if source == Template {
+		return filepath.Join(root, tplpath, "loc", name+"."+lang)
+	}
+	return filepath.Join(root, "modules", name, "tpl", "loc", lang+".json")
+}
+
+func readDir(dir string) ([]os.FileInfo, error) {
+	fis, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return fis, err
+}
+
+// Lists the loc files of the template at tplpath (relative to root) and of all modules. Files with strange names are skipped.
+func Files(root, tplpath string) ([]File, error) {
+	ret := []File{}
+	fis, err := readDir(filepath.Join(root, tplpath, "loc"))
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range fis {
+		dot := strings.LastIndex(v.Name(), ".")
+		if v.IsDir() || dot == -1 {
+			continue
+		}
+		f := File{Name: v.Name()[:dot], Lang: v.Name()[dot+1:], Source: Template}
+		if checkIdent("name", f.Name) == nil && checkIdent("language", f.Lang) == nil {
+			f.Path = path(root, tplpath, f.Name, f.Lang, Template)
+			ret = append(ret, f)
+		}
+	}
+	mods, err := readDir(filepath.Join(root, "modules"))
+	if err != nil {
+		return nil, err
+	}
+	for _, mod := range mods {
+		if !mod.IsDir() || checkIdent("name", mod.Name()) != nil {
+			continue
+		}
+		fis, err := readDir(filepath.Join(root, "modules", mod.Name(), "tpl", "loc"))
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range fis {
+			lang := strings.TrimSuffix(v.Name(), ".json")
+			if v.IsDir() || lang == v.Name() || checkIdent("language", lang) != nil {
+				continue
+			}
+			ret = append(ret, File{Name: mod.Name(), Lang: lang, Source: Module, Path: path(root, tplpath, mod.Name(), lang, Module)})
+		}
+	}
+	return ret, nil
+}
+
+func readLoc(path string) (map[string]interface{}, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	ret := map[string]interface{}{}
+	if err := json.Unmarshal(b, &ret); err != nil {
+		return nil, fmt.Errorf("%v: %v", path, err)
+	}
+	return ret, nil
+}
+
+func flatten(prefix string, from map[string]interface{}, to map[string]string) {
+	for i, v := range from {
+		switch val := v.(type) {
+		case map[string]interface{}:
+			flatten(prefix+i+".", val, to)
+		case string:
+			to[prefix+i] = val
+		case float64, bool:
+			to[prefix+i] = fmt.Sprint(val)
+		}
+	}
+}
+
+// Flattens the nested maps of a loc file into dotted keys. Only strings (and numbers, bools) are kept.
+func Flatten(loc map[string]interface{}) map[string]string {
+	ret := map[string]string{}
+	flatten("", loc, ret)
+	return ret
+}
+
+// Sets a dotted key in nested maps, creating the missing levels.
+func setKey(loc map[string]interface{}, key string, val string) error {
+	parts := strings.Split(key, ".")
+	for _, v := range parts[:len(parts)-1] {
+		switch sub := loc[v].(type) {
+		case nil:
+			n := map[string]interface{}{}
+			loc[v] = n
+			loc = n
+		case map[string]interface{}:
+			loc = sub
+		default:
+			return fmt.Errorf("Can't set %v, %v is not a group of keys.", key, v)
+		}
+	}
+	last := parts[len(parts)-1]
+	if _, is_map := loc[last].(map[string]interface{}); is_map {
+		return fmt.Errorf("Can't set %v, it is a group of keys.", key)
+	}
+	loc[last] = val
+	return nil
+}
+
+// Deletes a dotted key, the groups left empty go too.
+func deleteKey(loc map[string]interface{}, key string) {
+	parts := strings.SplitN(key, ".", 2)
+	if len(parts) == 1 {
+		delete(loc, key)
+		return
+	}
+	sub, ok := loc[parts[0]].(map[string]interface{})
+	if !ok {
+		return
+	}
+	deleteKey(sub, parts[1])
+	if len(sub) == 0 {
+		delete(loc, parts[0])
+	}
+}
+
+type Row struct {
+	Name, Key string
+	Values    map[string]string // By language.
+	Sources   map[string]string // Where the value of a language comes from, Template or Module.
+}
+
+type Coverage struct {
+	Lang              string
+	Translated, Total int
+	Percent           int
+}
+
+type Table struct {
+	Langs    []string
+	Names    []string
+	Rows     []*Row
+	Coverage []Coverage
+	Errors   []string // Files which could not be read, the rest of the table is still usable.
+}
+
+// Coverage of the languages in the given rows.
+func coverage(rows []*Row, langs []string) []Coverage {
+	ret := []Coverage{}
+	for _, lang := range langs {
+		c := Coverage{Lang: lang, Total: len(rows)}
+		for _, v := range rows {
+			if len(v.Values[lang]) > 0 {
+				c.Translated++
+			}
+		}
+		if c.Total > 0 {
+			c.Percent = c.Translated * 100 / c.Total
+		}
+		ret = append(ret, c)
+	}
+	return ret
+}
+
+// Coverage of the languages in the keys of one name.
+func (t *Table) CoverageOf(name string) []Coverage {
+	return coverage(t.RowsOf(name), t.Langs)
+}
+
+func (t *Table) RowsOf(name string) []*Row {
+	ret := []*Row{}
+	for _, v := range t.Rows {
+		if v.Name == name {
+			ret = append(ret, v)
+		}
+	}
+	return ret
+}
+
+func (t *Table) row(name, key string) *Row {
+	for _, v := range t.Rows {
+		if v.Name == name && v.Key == key {
+			return v
+		}
+	}
+	return nil
+}
+
+type byNameKey []*Row
+
+func (b byNameKey) Len() int      { return len(b) }
+func (b byNameKey) Swap(i, j int) { b[i], b[j] = b[j], b[i] }
+func (b byNameKey) Less(i, j int) bool {
+	return b[i].Name < b[j].Name || b[i].Name == b[j].Name && b[i].Key < b[j].Key
+}
+
+// Reads every loc file into one table sorted by name and key. The template files win over the module ones, like at display.
+// extra_langs get a column even if they have no files yet.
+func Load(root, tplpath string, extra_langs []string) (*Table, error) {
+	files, err := Files(root, tplpath)
+	if err != nil {
+		return nil, err
+	}
+	t := &Table{}
+	rows := map[string]*Row{}
+	langs := map[string]struct{}{}
+	names := map[string]struct{}{}
+	for _, v := range extra_langs {
+		if checkIdent("language", v) == nil {
+			langs[v] = struct{}{}
+		}
+	}
+	for _, f := range files { // The template files come first.
+		loc, err := readLoc(f.Path)
+		if err != nil {
+			t.Errors = append(t.Errors, err.Error())
+			continue
+		}
+		langs[f.Lang] = struct{}{}
+		names[f.Name] = struct{}{}
+		for key, val := range Flatten(loc) {
+			r, has := rows[f.Name+":"+key]
+			if !has {
+				r = &Row{Name: f.Name, Key: key, Values: map[string]string{}, Sources: map[string]string{}}
+				rows[f.Name+":"+key] = r
+				t.Rows = append(t.Rows, r)
+			}
+			if _, set := r.Values[f.Lang]; !set {
+				r.Values[f.Lang] = val
+				r.Sources[f.Lang] = f.Source
+			}
+		}
+	}
+	sort.Sort(byNameKey(t.Rows))
+	for i := range langs {
+		t.Langs = append(t.Langs, i)
+	}
+	sort.Strings(t.Langs)
+	for i := range names {
+		t.Names = append(t.Names, i)
+	}
+	sort.Strings(t.Names)
+	t.Coverage = coverage(t.Rows, t.Langs)
+	return t, nil
+}
+
+// The file a translation should go to: the template file if it exists (or there is no such module) and the template is private, the module file otherwise.
+func Target(root, tplpath string, private bool, name, lang string) (string, error) {
+	_, err := os.Stat(path(root, tplpath, name, lang, Template))
+	tpl_exists := err == nil
+	fi, err := os.Stat(filepath.Join(root, "modules", name))
+	is_mod := err == nil && fi.IsDir()
+	switch {
+	case private && (tpl_exists || !is_mod):
+		return Template, nil
+	case is_mod:
+		return Module, nil
+	}
+	return "", fmt.Errorf("%v is not a module and the current template is not private, the translations can't be saved.", name)
+}
+
+// Saves translations of one name in one language into the file of target. values are by dotted key, an empty value removes the key.
+// Template files can only be written if the template is private.
+func Save(root, tplpath string, private bool, name, lang, target string, values map[string]string) error {
+	if err := checkIdent("name", name); err != nil {
+		return err
+	}
+	if err := checkIdent("language", lang); err != nil {
+		return err
+	}
+	switch target {
+	case Template:
+		if !private {
+			return fmt.Errorf("Public templates can't be modified, fork it first.")
+		}
+	case Module:
+		if fi, err := os.Stat(filepath.Join(root, "modules", name)); err != nil || !fi.IsDir() {
+			return fmt.Errorf("There is no module called %v.", name)
+		}
+	default:
+		return fmt.Errorf("Unkown target %v.", target)
+	}
+	p := path(root, tplpath, name, lang, target)
+	loc, err := readLoc(p)
+	if os.IsNotExist(err) {
+		loc, err = map[string]interface{}{}, nil
+	}
+	if err != nil {
+		return err
+	}
+	for key, val := range values {
+		if len(val) == 0 {
+			deleteKey(loc, key)
+			continue
+		}
+		if err := setKey(loc, key, val); err != nil {
+			return err
+		}
+	}
+	b, err := json.MarshalIndent(loc, "", "\t")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(p, b, 0644)
+}
+
+// Saves the values of the table which differ from the current ones, each to its target file.
+// changes are by name, language, then key.
+func SaveChanges(root, tplpath string, private bool, changes map[string]map[string]map[string]string) error {
+	t, err := Load(root, tplpath, nil)
+	if err != nil {
+		return err
+	}
+	for name, by_lang := range changes {
+		for lang, values := range by_lang {
+			diff := map[string]string{}
+			for key, val := range values {
+				cur := ""
+				if r := t.row(name, key); r != nil {
+					cur = r.Values[lang]
+				}
+				if val != cur {
+					diff[key] = val
+				}
+			}
+			if len(diff) == 0 {
+				continue
+			}
+			target, err := Target(root, tplpath, private, name, lang)
+			if err != nil {
+				return err
+			}
+			if err := Save(root, tplpath, private, name, lang, target, diff); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func poQuote(s string) string {
+	s = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\t", `\t`, "\r", `\r`).Replace(s)
+	return `"` + s + `"`
+}
+
+// Exports a language as a gettext PO file. The context of a message is "<name>:<key>", the msgid is the text in the
+// reference language (the key if there is none), the msgstr is the translation, empty if there is none.
+func ExportPo(t *Table, lang, ref string) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "msgid \"\"\nmsgstr %v\n", poQuote("Language: "+lang+"\nMIME-Version: 1.0\nContent-Type: text/plain; charset=UTF-8\nContent-Transfer-Encoding: 8bit\n"))
+	for _, r := range t.Rows {
+		id := r.Values[ref]
+		if len(id) == 0 {
+			id = r.Key
+		}
+		fmt.Fprintf(&buf, "\nmsgctxt %v\nmsgid %v\nmsgstr %v\n", poQuote(r.Name+":"+r.Key), poQuote(id), poQuote(r.Values[lang]))
+	}
+	return buf.Bytes()
+}
+
+type poEntry struct {
+	ctxt, id, str string
+	fuzzy         bool
+}
+
+// Reads a PO file exported by ExportPo (or edited by any PO editor). Returns the language of the header and the translations
+// by name and key. Untranslated and fuzzy messages are left out.
+func ImportPo(data []byte) (string, map[string]map[string]string, error) {
+	entries := []*poEntry{}
+	cur := &poEntry{}
+	var field *string
+	flush := func() {
+		if field != nil {
+			entries = append(entries, cur)
+		}
+		cur, field = &poEntry{}, nil
+	}
+	for n, line := range strings.Split(strings.Replace(string(data), "\r\n", "\n", -1), "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case len(line) == 0:
+			flush()
+			continue
+		case strings.HasPrefix(line, "#,"):
+			cur.fuzzy = cur.fuzzy || strings.Contains(line, "fuzzy")
+			continue
+		case strings.HasPrefix(line, "#"):
+			continue
+		}
+		kw := line
+		rest := ""
+		if i := strings.Index(line, " "); i != -1 {
+			kw, rest = line[:i], strings.TrimSpace(line[i+1:])
+		}
+		switch kw {
+		case "msgctxt":
+			if field != nil {
+				flush()
+			}
+			field = &cur.ctxt
+		case "msgid":
+			if field == &cur.str {
+				flush()
+			}
+			field = &cur.id
+		case "msgstr":
+			field = &cur.str
+		default:
+			if !strings.HasPrefix(line, `"`) || field == nil {
+				return "", nil, fmt.Errorf("Line %v: can't understand %v", n+1, line)
+			}
+			rest = line
+		}
+		s, err := strconv.Unquote(rest)
+		if err != nil {
+			return "", nil, fmt.Errorf("Line %v: bad string %v", n+1, rest)
+		}
+		*field += s
+	}
+	flush()
+	lang := ""
+	ret := map[string]map[string]string{}
+	for _, e := range entries {
+		if len(e.id) == 0 && len(e.ctxt) == 0 {
+			for _, v := range strings.Split(e.str, "\n") {
+				if strings.HasPrefix(v, "Language:") {
+					lang = strings.TrimSpace(v[len("Language:"):])
+				}
+			}
+			continue
+		}
+		if e.fuzzy || len(e.str) == 0 {
+			continue
+		}
+		i := strings.Index(e.ctxt, ":")
+		if i == -1 {
+			return "", nil, fmt.Errorf("Message %v has no context of the form <name>:<key>.", e.id)
+		}
+		name, key := e.ctxt[:i], e.ctxt[i+1:]
+		if err := checkIdent("name", name); err != nil {
+			return "", nil, err
+		}
+		if ret[name] == nil {
+			ret[name] = map[string]string{}
+		}
+		ret[name][key] = e.str
+	}
+	return lang, ret, nil
+}
+
+// Languages which should have a column even without files, set on the admin page.
+func ExtraLangs(opt map[string]interface{}) []string {
+	langs, _ := jsonp.GetS(opt, "Modules.localization.languages")
+	ret := []string{}
+	for _, v := range langs {
+		if s, ok := v.(string); ok {
+			ret = append(ret, s)
+		}
+	}
+	return ret
+}
+
+func SaveLangs(db *mgo.Database, langs []string) error {
+	ls := []interface{}{}
+	for _, v := range langs {
+		v = strings.TrimSpace(v)
+		if len(v) == 0 {
+			continue
+		}
+		if err := checkIdent("language", v); err != nil {
+			return err
+		}
+		ls = append(ls, v)
+	}
+	id := basic.CreateOptCopy(db)
+	return db.C("options").Update(m{"_id": id}, m{"$set": m{"Modules.localization.languages": ls}})
+}
+
+func Install(db *mgo.Database, id bson.ObjectId) error {
+	q := m{"_id": id}
+	upd := m{
+		"$addToSet": m{
+			"Hooks.Front": "localization",
+		},
+		"$set": m{
+			"Modules.localization": m{},
+		},
+	}
+	return db.C("options").Update(q, upd)
+}
+
+func Uninstall(db *mgo.Database, id bson.ObjectId) error {
+	q := m{"_id": id}
+	upd := m{
+		"$pull": m{
+			"Hooks.Front": "localization",
+		},
+		"$unset": m{
+			"Modules.localization": 1,
+		},
+	}
+	return db.C("options").Update(q, upd)
+}
@@ -0,0 +1,139 @@
+package localization_model
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestKeys(t *testing.T) {
+	loc := map[string]interface{}{"title": "Title", "menu": map[string]interface{}{"home": "Home"}}
+	if err := setKey(loc, "comments.one", "{count} comment"); err != nil {
+		t.Fatal(err)
+	}
+	if err := setKey(loc, "title.x", "y"); err == nil {
+		t.Fatal("title is not a group.")
+	}
+	if err := setKey(loc, "menu", "y"); err == nil {
+		t.Fatal("menu is a group.")
+	}
+	deleteKey(loc, "menu.home")
+	expected := map[string]string{"title": "Title", "comments.one": "{count} comment"}
+	if f := Flatten(loc); !reflect.DeepEqual(f, expected) {
+		t.Fatal(f)
+	}
+	if _, has := loc["menu"]; has {
+		t.Fatal("Empty groups should be removed.")
+	}
+}
+
+func write(t *testing.T, path, content string) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadSave(t *testing.T) {
+	root, err := ioutil.TempDir("", "loc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	tpl := filepath.Join("templates", "private", "example.com", "default")
+	write(t, filepath.Join(root, tpl, "loc", "content.en"), `{"title": "My title"}`)
+	write(t, filepath.Join(root, "modules", "content", "tpl", "loc", "en.json"), `{"title": "Title", "save": "Save"}`)
+	write(t, filepath.Join(root, "modules", "content", "tpl", "loc", "hu.json"), `{"title": "Cím"}`)
+	write(t, filepath.Join(root, "modules", "user", "tpl", "loc", "en.json"), `{"broken": `)
+	tab, err := Load(root, tpl, []string{"de", "../x"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(tab.Langs, []string{"de", "en", "hu"}) || len(tab.Rows) != 2 || len(tab.Errors) != 1 {
+		t.Fatal(tab.Langs, tab.Rows, tab.Errors)
+	}
+	title := tab.row("content", "title")
+	if title.Values["en"] != "My title" || title.Sources["en"] != Template || title.Sources["hu"] != Module {
+		t.Fatal(title)
+	}
+	if c := tab.CoverageOf("content"); c[1].Percent != 100 || c[2].Translated != 1 || c[2].Percent != 50 {
+		t.Fatal(c)
+	}
+	// Unchanged values are not written, hu goes to the module as there is no template file, en to the template.
+	changes := map[string]map[string]map[string]string{
+		"content": {
+			"en": {"title": "My title", "save": "Save it"},
+			"hu": {"title": "Cím", "save": "Mentés"},
+			"de": {"title": ""},
+		},
+	}
+	if err := SaveChanges(root, tpl, true, changes); err != nil {
+		t.Fatal(err)
+	}
+	tab, _ = Load(root, tpl, nil)
+	save := tab.row("content", "save")
+	if save.Values["en"] != "Save it" || save.Sources["en"] != Template || save.Values["hu"] != "Mentés" || save.Sources["hu"] != Module {
+		t.Fatal(save)
+	}
+	if _, err := os.Stat(filepath.Join(root, tpl, "loc", "content.de")); err == nil {
+		t.Fatal("Nothing changed in de, no file should be created.")
+	}
+	if err := Save(root, tpl, false, "content", "en", Template, map[string]string{"x": "y"}); err == nil {
+		t.Fatal("Public templates are read only.")
+	}
+	if err := Save(root, tpl, true, "../../etc", "en", Template, map[string]string{"x": "y"}); err == nil {
+		t.Fatal("Names must not be paths.")
+	}
+	if _, err := Target(root, tpl, false, "nothing", "en"); err == nil {
+		t.Fatal("There is no place to save nothing.")
+	}
+}
+
+func TestPo(t *testing.T) {
+	tab := &Table{
+		Langs: []string{"en", "hu"},
+		Rows: []*Row{
+			{Name: "content", Key: "comments.one", Values: map[string]string{"en": "{count} comment", "hu": "{count} \"hozzászólás\""}},
+			{Name: "content", Key: "intro", Values: map[string]string{"en": "Line one\nLine two"}},
+			{Name: "user", Key: "welcome", Values: map[string]string{"hu": "Üdv"}},
+		},
+	}
+	po := ExportPo(tab, "hu", "en")
+	lang, tr, err := ImportPo(po)
+	if err != nil {
+		t.Fatal(err, string(po))
+	}
+	expected := map[string]map[string]string{
+		"content": {"comments.one": "{count} \"hozzászólás\""},
+		"user":    {"welcome": "Üdv"},
+	}
+	if lang != "hu" || !reflect.DeepEqual(tr, expected) {
+		t.Fatal(lang, tr, string(po))
+	}
+	edited := `# translator comment
+msgid ""
+msgstr ""
+"Language: de\n"
+
+#, fuzzy
+msgctxt "content:title"
+msgid "Title"
+msgstr "Titel?"
+
+msgctxt "content:intro"
+msgid "Line one\nLine two"
+msgstr "Zeile eins\n"
+"Zeile zwei"
+`
+	lang, tr, err = ImportPo([]byte(edited))
+	if err != nil || lang != "de" || !reflect.DeepEqual(tr, map[string]map[string]string{"content": {"intro": "Zeile eins\nZeile zwei"}}) {
+		t.Fatal(lang, tr, err)
+	}
+	if _, _, err = ImportPo([]byte("msgctxt \"x\"\nmsgid \"a\"\nmsgstr \"b\"\n")); err == nil {
+		t.Fatal("Context without a name.")
+	}
+}
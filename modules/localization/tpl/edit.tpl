@@ -0,0 +1,35 @@
+{{require admin/header.t}}
+{{require localization/sidebar.t}}
+
+<h4>{{.name}}</h4>
+{{range .coverage}}{{.Lang}}: {{.Percent}}% {{end}}<br />
+<br />
+<form action="/b/localization/save" method="post">
+	<input type="hidden" name="name" value="{{.name}}">
+	<table>
+		<tr>
+			<th>Key</th>
+			{{range .langs}}<th>{{.}}</th>{{end}}
+		</tr>
+		{{range $r := .rows}}
+			<tr>
+				<td>{{$r.Key}}</td>
+				{{range $.langs}}
+					<td>
+						<textarea name="v:{{.}}:{{$r.Key}}" rows="2" cols="30">{{index $r.Values .}}</textarea>
+						{{with index $r.Sources .}}<br /><small>{{.}}</small>{{end}}
+					</td>
+				{{end}}
+			</tr>
+		{{end}}
+		<tr>
+			<td><input type="text" name="new_key" placeholder="new.key"></td>
+			{{range .langs}}<td><textarea name="new:{{.}}" rows="2" cols="30"></textarea></td>{{end}}
+		</tr>
+	</table>
+	Emptying a value removes the key from the file.<br />
+	<input type="submit" value="Save">
+</form>
+
+{{require localization/footer.t}}
+{{require admin/footer.t}}
\ No newline at end of file
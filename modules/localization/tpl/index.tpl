@@ -0,0 +1,57 @@
+{{require admin/header.t}}
+{{require localization/sidebar.t}}
+
+<h4>Coverage</h4>
+{{if .errors}}
+	{{range .errors}}{{.}}<br />{{end}}
+	<br />
+{{end}}
+{{if .names}}
+	<table>
+		<tr>
+			<th>Name</th>
+			<th>Keys</th>
+			{{range .langs}}<th>{{.}} (<a href="/localization/export/{{.}}">PO</a>)</th>{{end}}
+		</tr>
+		{{range .names}}
+			<tr>
+				<td><a href="/admin/localization/edit?name={{.name}}">{{.name}}</a></td>
+				<td>{{.keys}}</td>
+				{{range .coverage}}<td>{{.Percent}}% ({{.Translated}}/{{.Total}})</td>{{end}}
+			</tr>
+		{{end}}
+		<tr>
+			<td><b>All</b></td>
+			<td></td>
+			{{range .coverage}}<td><b>{{.Percent}}%</b> ({{.Translated}}/{{.Total}})</td>{{end}}
+		</tr>
+	</table>
+{{else}}
+	No loc files yet.<br />
+{{end}}
+{{if not .private}}
+	<br />
+	The current template is public, only the module translations can be saved. Fork the template to translate its own texts.<br />
+{{end}}
+<br />
+
+<h4>Import</h4>
+A PO file, the messages are matched by their context (name:key). Fuzzy and empty translations are skipped.<br />
+<br />
+<form action="/b/localization/import" method="post" enctype="multipart/form-data">
+	<input type="file" name="file"><br />
+	Language (leave empty to use the one in the file): <input type="text" name="lang" size="5"><br />
+	<input type="submit" value="Import">
+</form>
+<br />
+
+<h4>Languages</h4>
+Languages to translate to, besides the ones already having files, separated by commas.<br />
+<br />
+<form action="/b/localization/save_langs" method="post">
+	<input type="text" name="languages" value="{{.languages}}">
+	<input type="submit" value="Save">
+</form>
+
+{{require localization/footer.t}}
+{{require admin/footer.t}}
\ No newline at end of file
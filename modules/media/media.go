@@ -0,0 +1,150 @@
+// Package media implements a media library: file uploads, image derivatives and a browser for editors.
+// Original files are served from uploads/<host>/media/, derivatives (resized or cropped images) from /media/{id}/{size}.
+package media
+
+import (
+	"fmt"
+	"github.com/opesun/hypecms/api/context"
+	"github.com/opesun/hypecms/model/basic"
+	"github.com/opesun/hypecms/modules/display/model"
+	"github.com/opesun/hypecms/modules/media/model"
+	"github.com/opesun/jsonp"
+	"github.com/opesun/routep"
+	"labix.org/v2/mgo/bson"
+	"net/http"
+	"regexp"
+)
+
+type m map[string]interface{}
+
+func (h *H) Front() (bool, error) {
+	uni := h.uni
+	ma, err := routep.Comp("/media/{id}/{size}", uni.P)
+	if err != nil || len(ma) != 2 || !bson.IsObjectIdHex(ma["id"]) {
+		return false, nil
+	}
+	path, err := media_model.Derivative(uni.Db, uni.Opt, uni.Root, uni.Req.Host, bson.ObjectIdHex(ma["id"]), ma["size"])
+	if err != nil {
+		return true, err
+	}
+	uni.W.Header().Set("Cache-Control", "public, max-age=31536000")
+	http.ServeFile(uni.W, uni.Req, path)
+	uni.Dat["_written"] = true // Nothing else should be displayed.
+	return true, nil
+}
+
+func (h *H) Install(id bson.ObjectId) error {
+	return media_model.Install(h.uni.Db, id)
+}
+
+func (h *H) Uninstall(id bson.ObjectId) error {
+	return media_model.Uninstall(h.uni.Db, id)
+}
+
+// Multipart form, files must come in the "files" field.
+func (a *A) Upload() error {
+	uni := a.uni
+	limits := media_model.LimitsOf(uni.Opt)
+	uni.Req.Body = http.MaxBytesReader(uni.W, uni.Req.Body, limits.MaxRequestSize)
+	err := uni.Req.ParseMultipartForm(1 << 20)
+	if err != nil {
+		return fmt.Errorf("Can't read upload, maybe it is bigger than %v bytes.", limits.MaxRequestSize)
+	}
+	uid, has := jsonp.Get(uni.Dat, "_user._id")
+	if !has {
+		return fmt.Errorf("You must have user id to upload.")
+	}
+	files := uni.Req.MultipartForm.File["files"]
+	ids, err := media_model.Upload(uni.Db, limits, uni.Root, uni.Req.Host, files, uid.(bson.ObjectId))
+	hexes := []string{}
+	for _, v := range ids {
+		hexes = append(hexes, v.Hex())
+	}
+	uni.Dat["_cont"] = map[string]interface{}{"uploaded": len(ids), "ids": hexes}
+	return err
+}
+
+func (a *A) Update() error {
+	uni := a.uni
+	id := basic.ToIdWithCare(uni.Req.Form["id"][0])
+	var title, alt string
+	if v, has := uni.Req.Form["title"]; has {
+		title = v[0]
+	}
+	if v, has := uni.Req.Form["alt"]; has {
+		alt = v[0]
+	}
+	return media_model.Update(uni.Db, id, title, alt)
+}
+
+func (a *A) Delete() error {
+	uni := a.uni
+	id := basic.ToIdWithCare(uni.Req.Form["id"][0])
+	return media_model.Delete(uni.Db, uni.Root, uni.Req.Host, id)
+}
+
+func (v *V) list(limit int) {
+	uni := v.uni
+	q := map[string]interface{}{}
+	if _, only_img := uni.Req.Form["images"]; only_img {
+		q["is_image"] = true
+	}
+	if s, has := uni.Req.Form["search"]; has && len(s[0]) > 0 {
+		q["title"] = bson.RegEx{Pattern: "^" + regexp.QuoteMeta(s[0]), Options: "i"}
+		uni.Dat["search"] = s[0]
+	}
+	query := map[string]interface{}{
+		"c":  media_model.Cname,
+		"q":  q,
+		"so": "-created",
+		"p":  "page",
+		"l":  limit,
+	}
+	pnq := uni.P + "?" + uni.Req.URL.RawQuery
+	ml := display_model.RunQuery(uni.Db, "media_list", query, uni.Req.Form, pnq)
+	uni.Dat["media_list"] = ml["media_list"]
+	uni.Dat["media_list_navi"] = ml["media_list_navi"]
+	sizes, _ := jsonp.GetM(uni.Opt, "Modules.media.sizes")
+	uni.Dat["sizes"] = sizes
+	uni.Dat["limits"] = media_model.LimitsOf(uni.Opt)
+}
+
+// Browse and upload.
+func (v *V) Index() error {
+	v.list(20)
+	return nil
+}
+
+// Popup for editors to pick media for a content field. The "field" get parameter is the id of the input to be filled.
+func (v *V) Select() error {
+	uni := v.uni
+	v.list(30)
+	if f, has := uni.Req.Form["field"]; has {
+		uni.Dat["field"] = f[0]
+	}
+	return nil
+}
+
+type A struct {
+	uni *context.Uni
+}
+
+func Actions(uni *context.Uni) *A {
+	return &A{uni}
+}
+
+type H struct {
+	uni *context.Uni
+}
+
+func Hooks(uni *context.Uni) *H {
+	return &H{uni}
+}
+
+type V struct {
+	uni *context.Uni
+}
+
+func Views(uni *context.Uni) *V {
+	return &V{uni}
+}
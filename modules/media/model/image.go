@@ -0,0 +1,194 @@
+package media_model
+
+import (
+	"fmt"
+	"github.com/opesun/jsonp"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"labix.org/v2/mgo"
+	"labix.org/v2/mgo/bson"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// A derivative spec looks like "200x150" (fit into 200x150), "200x" or "x150" (fit to one side), "200x150c" (fill 200x150, crop the rest).
+// Only the named sizes from "Modules.media.sizes" can be requested from the outside, so nobody can fill up the disk with random sizes.
+type Spec struct {
+	Width, Height int
+	Crop          bool
+}
+
+func ParseSpec(s string) (*Spec, error) {
+	sp := &Spec{}
+	if strings.HasSuffix(s, "c") {
+		sp.Crop = true
+		s = s[:len(s)-1]
+	}
+	parts := strings.Split(s, "x")
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("Bad size spec %v.", s)
+	}
+	var err error
+	if len(parts[0]) > 0 {
+		sp.Width, err = strconv.Atoi(parts[0])
+		if err != nil {
+			return nil, fmt.Errorf("Bad width in size spec %v.", s)
+		}
+	}
+	if len(parts[1]) > 0 {
+		sp.Height, err = strconv.Atoi(parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("Bad height in size spec %v.", s)
+		}
+	}
+	if sp.Width <= 0 && sp.Height <= 0 {
+		return nil, fmt.Errorf("Size spec %v has no dimensions.", s)
+	}
+	if sp.Crop && (sp.Width <= 0 || sp.Height <= 0) {
+		return nil, fmt.Errorf("Cropping needs both dimensions in %v.", s)
+	}
+	return sp, nil
+}
+
+func SpecOf(opt map[string]interface{}, size_name string) (*Spec, error) {
+	s, has := jsonp.GetStr(opt, "Modules.media.sizes."+size_name)
+	if !has {
+		return nil, fmt.Errorf("Unkown size %v.", size_name)
+	}
+	return ParseSpec(s)
+}
+
+// Target dimensions and the source rectangle to be scaled into them.
+func (sp *Spec) plan(w, h int) (int, int, image.Rectangle) {
+	src := image.Rect(0, 0, w, h)
+	if sp.Crop {
+		// Cut the largest centered area which has the aspect ratio of the target.
+		cw, ch := w, w*sp.Height/sp.Width
+		if ch > h {
+			cw, ch = h*sp.Width/sp.Height, h
+		}
+		x0, y0 := (w-cw)/2, (h-ch)/2
+		src = image.Rect(x0, y0, x0+cw, y0+ch)
+		tw, th := sp.Width, sp.Height
+		if tw > cw { // Never upscale.
+			tw, th = cw, ch
+		}
+		return tw, th, src
+	}
+	tw, th := w, h
+	if sp.Width > 0 && tw > sp.Width {
+		tw, th = sp.Width, h*sp.Width/w
+	}
+	if sp.Height > 0 && th > sp.Height {
+		tw, th = tw*sp.Height/th, sp.Height
+	}
+	if tw < 1 {
+		tw = 1
+	}
+	if th < 1 {
+		th = 1
+	}
+	return tw, th, src
+}
+
+// Box filter resize: every target pixel is the average of the source pixels it covers. Good enough for downscaling.
+func resize(img image.Image, src image.Rectangle, tw, th int) *image.RGBA {
+	dst := image.NewRGBA(image.Rect(0, 0, tw, th))
+	sw, sh := src.Dx(), src.Dy()
+	for y := 0; y < th; y++ {
+		y0 := src.Min.Y + y*sh/th
+		y1 := src.Min.Y + (y+1)*sh/th
+		if y1 <= y0 {
+			y1 = y0 + 1
+		}
+		for x := 0; x < tw; x++ {
+			x0 := src.Min.X + x*sw/tw
+			x1 := src.Min.X + (x+1)*sw/tw
+			if x1 <= x0 {
+				x1 = x0 + 1
+			}
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, ca := img.At(sx, sy).RGBA()
+					r += uint64(cr)
+					g += uint64(cg)
+					b += uint64(cb)
+					a += uint64(ca)
+					n++
+				}
+			}
+			dst.Set(x, y, color.RGBA64{uint16(r / n), uint16(g / n), uint16(b / n), uint16(a / n)})
+		}
+	}
+	return dst
+}
+
+func Transform(img image.Image, sp *Spec) image.Image {
+	b := img.Bounds()
+	tw, th, src := sp.plan(b.Dx(), b.Dy())
+	src = src.Add(b.Min)
+	return resize(img, src, tw, th)
+}
+
+func derivativePath(root, host, size_name string, id bson.ObjectId, ext string) string {
+	return filepath.Join(Dir(root, host), "cache", size_name, id.Hex()+ext)
+}
+
+// Returns the path of the requested derivative of an image, generating it first if it is not cached on disk yet.
+func Derivative(db *mgo.Database, opt map[string]interface{}, root, host string, id bson.ObjectId, size_name string) (string, error) {
+	sp, err := SpecOf(opt, size_name)
+	if err != nil {
+		return "", err
+	}
+	doc, err := Find(db, id)
+	if err != nil {
+		return "", err
+	}
+	if is_img, _ := doc["is_image"].(bool); !is_img {
+		return "", fmt.Errorf("Media %v is not an image.", id.Hex())
+	}
+	ext := filepath.Ext(doc["file"].(string))
+	if ext == ".gif" { // Animations would be lost anyway.
+		ext = ".png"
+	}
+	path := derivativePath(root, host, size_name, id, ext)
+	if _, err := os.Stat(path); err == nil {
+		return path, nil
+	}
+	f, err := os.Open(filepath.Join(Dir(root, host), doc["file"].(string)))
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	img, err := decodeLimited(f, LimitsOf(opt).MaxPixels)
+	if err != nil {
+		return "", err
+	}
+	out := Transform(img, sp)
+	err = os.MkdirAll(filepath.Dir(path), os.ModePerm)
+	if err != nil {
+		return "", err
+	}
+	// Write to a temporary file first, so a concurrent request never serves a half written image.
+	tmp := path + ".tmp" + bson.NewObjectId().Hex()
+	dst, err := os.Create(tmp)
+	if err != nil {
+		return "", err
+	}
+	if ext == ".jpg" {
+		err = jpeg.Encode(dst, out, &jpeg.Options{Quality: 85})
+	} else {
+		err = png.Encode(dst, out)
+	}
+	dst.Close()
+	if err != nil {
+		os.Remove(tmp)
+		return "", err
+	}
+	return path, os.Rename(tmp, path)
+}
@@ -0,0 +1,36 @@
+package media_model
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"testing"
+)
+
+func TestDecodeLimited(t *testing.T) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 300, 200))); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := decodeLimited(bytes.NewReader(buf.Bytes()), 50000); err == nil {
+		t.Fatal("Image above the pixel limit should not be decoded.")
+	}
+	img, err := decodeLimited(bytes.NewReader(buf.Bytes()), 60000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := img.Bounds(); b.Dx() != 300 || b.Dy() != 200 {
+		t.Fatal(b)
+	}
+}
+
+func TestPlan(t *testing.T) {
+	sp, err := ParseSpec("150x150c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, h, src := sp.plan(300, 200)
+	if w != 150 || h != 150 || src.Dx() != 200 || src.Dy() != 200 {
+		t.Fatal(w, h, src)
+	}
+}
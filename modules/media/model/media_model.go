@@ -0,0 +1,373 @@
+package media_model
+
+import (
+	"fmt"
+	"github.com/opesun/hypecms/model/basic"
+	"github.com/opesun/jsonp"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"labix.org/v2/mgo"
+	"labix.org/v2/mgo/bson"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	Cname = "media"
+	// Content fields starting with this are references to media documents, resolver resolves them.
+	Field_prefix = "_media_"
+	// 40 megapixels, about 160MB decoded.
+	Default_max_pixels = 40000000
+)
+
+type m map[string]interface{}
+
+type Limits struct {
+	MaxSize        int64 // Of one file, in bytes.
+	MaxRequestSize int64
+	MaxPixels      int64 // Width * height of images, a small file can decode into a huge image.
+	AllowedTypes   map[string]struct{}
+}
+
+// Reads "Modules.media.max_size", "Modules.media.max_request_size", "Modules.media.max_pixels" and "Modules.media.allowed_types".
+func LimitsOf(opt map[string]interface{}) *Limits {
+	l := &Limits{
+		MaxSize:      5 << 20,
+		MaxPixels:    Default_max_pixels,
+		AllowedTypes: map[string]struct{}{},
+	}
+	if mp, has := jsonp.Get(opt, "Modules.media.max_pixels"); has {
+		if f, ok := toInt64(mp); ok {
+			l.MaxPixels = f
+		}
+	}
+	if ms, has := jsonp.Get(opt, "Modules.media.max_size"); has {
+		if f, ok := toInt64(ms); ok {
+			l.MaxSize = f
+		}
+	}
+	l.MaxRequestSize = 4 * l.MaxSize
+	if ms, has := jsonp.Get(opt, "Modules.media.max_request_size"); has {
+		if f, ok := toInt64(ms); ok {
+			l.MaxRequestSize = f
+		}
+	}
+	types, has := jsonp.GetS(opt, "Modules.media.allowed_types")
+	if !has {
+		types = []interface{}{"image/jpeg", "image/png", "image/gif"}
+	}
+	for _, v := range jsonp.ToStringSlice(types) {
+		l.AllowedTypes[v] = struct{}{}
+	}
+	return l
+}
+
+func toInt64(i interface{}) (int64, bool) {
+	switch val := i.(type) {
+	case float64:
+		return int64(val), true
+	case int:
+		return int64(val), true
+	case int64:
+		return val, true
+	}
+	return 0, false
+}
+
+// Directory of the media files of a given site. Everything under uploads/<host> is served by main.go when SERVE_FILES is on.
+func Dir(root, host string) string {
+	return filepath.Join(root, "uploads", host, "media")
+}
+
+var extensions = map[string]string{
+	"image/jpeg":      ".jpg",
+	"image/png":       ".png",
+	"image/gif":       ".gif",
+	"application/pdf": ".pdf",
+}
+
+// We don't trust the Content-Type sent by the browser, we sniff it.
+func sniff(f multipart.File) (string, error) {
+	head := make([]byte, 512)
+	n, err := f.Read(head)
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+	_, err = f.Seek(0, 0)
+	if err != nil {
+		return "", err
+	}
+	typ := http.DetectContentType(head[:n])
+	if i := strings.Index(typ, ";"); i != -1 {
+		typ = typ[:i]
+	}
+	return typ, nil
+}
+
+func extOf(mime, filename string) string {
+	if ext, has := extensions[mime]; has {
+		return ext
+	}
+	ext := strings.ToLower(filepath.Ext(filename))
+	if len(ext) == 0 {
+		return ""
+	}
+	for _, r := range ext[1:] {
+		if !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9') {
+			return ""
+		}
+	}
+	return ext
+}
+
+// Copies the file to its place, returns its size.
+func saveFile(src multipart.File, path string, max_size int64) (int64, error) {
+	err := os.MkdirAll(filepath.Dir(path), os.ModePerm)
+	if err != nil {
+		return 0, err
+	}
+	dst, err := os.Create(path)
+	if err != nil {
+		return 0, err
+	}
+	defer dst.Close()
+	n, err := io.Copy(dst, io.LimitReader(src, max_size+1))
+	if err != nil {
+		os.Remove(path)
+		return 0, err
+	}
+	if n > max_size {
+		dst.Close()
+		os.Remove(path)
+		return 0, fmt.Errorf("File is too big, maximum size is %v bytes.", max_size)
+	}
+	return n, nil
+}
+
+func checkFile(f multipart.File, fh *multipart.FileHeader, limits *Limits) (string, error) {
+	mime, err := sniff(f)
+	if err != nil {
+		return "", err
+	}
+	if _, allowed := limits.AllowedTypes[mime]; !allowed {
+		return "", fmt.Errorf("File type %v of %v is not allowed.", mime, fh.Filename)
+	}
+	return mime, nil
+}
+
+// Saves one uploaded file and inserts its metadata into the media collection.
+func insertOne(db *mgo.Database, limits *Limits, root, host string, fh *multipart.FileHeader, user_id bson.ObjectId) (bson.ObjectId, error) {
+	f, err := fh.Open()
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	mime, err := checkFile(f, fh, limits)
+	if err != nil {
+		return "", err
+	}
+	id := bson.NewObjectId()
+	fname := id.Hex() + extOf(mime, fh.Filename)
+	path := filepath.Join(Dir(root, host), fname)
+	size, err := saveFile(f, path, limits.MaxSize)
+	if err != nil {
+		return "", err
+	}
+	doc := m{
+		"_id":            id,
+		"name":           filepath.Base(fh.Filename),
+		"title":          strings.TrimSuffix(filepath.Base(fh.Filename), filepath.Ext(fh.Filename)),
+		"alt":            "",
+		"file":           fname,
+		"url":            "/media/" + fname,
+		"mime":           mime,
+		"size":           size,
+		"is_image":       strings.HasPrefix(mime, "image/"),
+		basic.Created:    time.Now().Unix(),
+		basic.Created_by: user_id,
+	}
+	if doc["is_image"].(bool) {
+		if w, h, err := dimensions(path); err == nil {
+			if err := checkPixels(w, h, limits.MaxPixels); err != nil {
+				os.Remove(path)
+				return "", fmt.Errorf("%v: %v", fh.Filename, err)
+			}
+			doc["width"] = w
+			doc["height"] = h
+		}
+	}
+	err = db.C(Cname).Insert(doc)
+	if err != nil {
+		os.Remove(path)
+		return "", err
+	}
+	return id, nil
+}
+
+func dimensions(path string) (int, int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+	conf, _, err := image.DecodeConfig(f)
+	if err != nil {
+		return 0, 0, err
+	}
+	return conf.Width, conf.Height, nil
+}
+
+func checkPixels(w, h int, max int64) error {
+	if int64(w)*int64(h) > max {
+		return fmt.Errorf("Image is too large, %vx%v pixels, at most %v pixels are allowed.", w, h, max)
+	}
+	return nil
+}
+
+// Decodes an image, but only after checking its dimensions in the header.
+func decodeLimited(f io.ReadSeeker, max int64) (image.Image, error) {
+	conf, _, err := image.DecodeConfig(f)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkPixels(conf.Width, conf.Height, max); err != nil {
+		return nil, err
+	}
+	if _, err := f.Seek(0, 0); err != nil {
+		return nil, err
+	}
+	img, _, err := image.Decode(f)
+	return img, err
+}
+
+// Saves all uploaded files. Stops at the first bad file, but the ones before it stay saved.
+func Upload(db *mgo.Database, limits *Limits, root, host string, files []*multipart.FileHeader, user_id bson.ObjectId) ([]bson.ObjectId, error) {
+	ids := []bson.ObjectId{}
+	if len(files) == 0 {
+		return ids, fmt.Errorf("No files were uploaded.")
+	}
+	for _, v := range files {
+		id, err := insertOne(db, limits, root, host, v, user_id)
+		if err != nil {
+			return ids, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+func Find(db *mgo.Database, id bson.ObjectId) (map[string]interface{}, error) {
+	var v interface{}
+	err := db.C(Cname).Find(m{"_id": id}).One(&v)
+	if err != nil {
+		return nil, fmt.Errorf("Can't find media %v.", id.Hex())
+	}
+	return basic.Convert(v).(map[string]interface{}), nil
+}
+
+// Updates the editable metadata: title and alt text.
+func Update(db *mgo.Database, id bson.ObjectId, title, alt string) error {
+	return db.C(Cname).Update(m{"_id": id}, m{"$set": m{"title": title, "alt": alt}})
+}
+
+// Deletes the document, the file and all of its cached derivatives.
+func Delete(db *mgo.Database, root, host string, id bson.ObjectId) error {
+	doc, err := Find(db, id)
+	if err != nil {
+		return err
+	}
+	err = db.C(Cname).Remove(m{"_id": id})
+	if err != nil {
+		return err
+	}
+	dir := Dir(root, host)
+	os.Remove(filepath.Join(dir, doc["file"].(string)))
+	derivs, _ := filepath.Glob(filepath.Join(dir, "cache", "*", id.Hex()+".*"))
+	for _, v := range derivs {
+		os.Remove(v)
+	}
+	return nil
+}
+
+// Parses a media reference coming from a form: hex ids, comma separated if more than one.
+// Checks if the referenced media documents exist. Returns nil for an empty value, an ObjectId for one id, []bson.ObjectId for more.
+func ParseRefs(db *mgo.Database, key, str string) (interface{}, error) {
+	ids := []bson.ObjectId{}
+	for _, v := range strings.Split(str, ",") {
+		v = strings.TrimSpace(v)
+		if len(v) == 0 {
+			continue
+		}
+		if len(v) >= 38 { // ObjectIdHex("...") form.
+			v = basic.StripId(v)
+		}
+		if !bson.IsObjectIdHex(v) {
+			return nil, fmt.Errorf("Field %v: %v is not a valid media id.", key, v)
+		}
+		ids = append(ids, bson.ObjectIdHex(v))
+	}
+	count, err := db.C(Cname).Find(m{"_id": m{"$in": ids}}).Count()
+	if err != nil {
+		return nil, err
+	}
+	if count != len(ids) {
+		return nil, fmt.Errorf("Field %v references nonexisting media.", key)
+	}
+	switch len(ids) {
+	case 0:
+		return nil, nil
+	case 1:
+		return ids[0], nil
+	}
+	return ids, nil
+}
+
+func Install(db *mgo.Database, id bson.ObjectId) error {
+	media_options := m{
+		"max_size":      5 << 20,
+		"max_pixels":    Default_max_pixels,
+		"allowed_types": []string{"image/jpeg", "image/png", "image/gif", "application/pdf"},
+		"sizes": m{
+			"thumb":  "150x150c",
+			"small":  "320x",
+			"medium": "800x",
+		},
+		"actions": m{
+			"upload": m{
+				"auth": m{
+					"min_lev": 200,
+				},
+			},
+		},
+	}
+	q := m{"_id": id}
+	upd := m{
+		"$addToSet": m{
+			"Hooks.Front": "media",
+		},
+		"$set": m{
+			"Modules.media": media_options,
+		},
+	}
+	return db.C("options").Update(q, upd)
+}
+
+func Uninstall(db *mgo.Database, id bson.ObjectId) error {
+	q := m{"_id": id}
+	upd := m{
+		"$pull": m{
+			"Hooks.Front": "media",
+		},
+		"$unset": m{
+			"Modules.media": 1,
+		},
+	}
+	return db.C("options").Update(q, upd)
+}
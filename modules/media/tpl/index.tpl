@@ -0,0 +1,31 @@
+{{require admin/header.t}}
+{{require media/sidebar.t}}
+
+<h4>Media</h4>
+{{require media/upload-form.t}}
+<br />
+{{$sizes := .sizes}}
+{{if .media_list}}
+	{{range .media_list}}
+		<div class="list-item">
+			<a class="delete" href="/b/media/delete?id={{._id}}">-</a>
+			{{if .is_image}}
+				{{if $sizes.thumb}}<img src="/media/{{._id}}/thumb" alt="{{.alt}}">{{end}}
+			{{end}}
+			<a href="{{.url}}">{{.name}}</a> ({{.mime}}, {{.size}} bytes{{if .width}}, {{.width}}x{{.height}}{{end}})
+			<form action="/b/media/update" style="display: inline;">
+				<input type="hidden" name="id" value="{{._id}}">
+				Title: <input name="title" value="{{.title}}">
+				Alt: <input name="alt" value="{{.alt}}">
+				<input type="submit" value="Save">
+			</form>
+		</div>
+	{{end}}
+	{{$navi := .media_list_navi}}
+	{{require admin/navi.t}}
+{{else}}
+	No media yet.
+{{end}}
+
+{{require media/footer.t}}
+{{require admin/footer.t}}
\ No newline at end of file
@@ -0,0 +1,39 @@
+<!DOCTYPE html>
+<html>
+<head>
+<script src="/shared/jquery.min.1.7.js"></script>
+<link rel="stylesheet" type="text/css" href="/tpl/admin/style.css" />
+<script>
+$(function() {
+	// Puts the id of the clicked media into the field of the editor window. Holding shift appends it instead.
+	$(".select-media").on("click", function(e) {
+		var field = window.opener.document.getElementById({{.field}})
+		var id = $(this).data("id")
+		if (e.shiftKey && field.value.length > 0) {
+			field.value = field.value + "," + id
+		} else {
+			field.value = id
+		}
+		if (!e.shiftKey) {
+			window.close()
+		}
+		return false
+	})
+})
+</script>
+</head>
+<body>
+{{require media/upload-form.t}}
+<br />
+{{$sizes := .sizes}}
+{{range .media_list}}
+	<a href="#" class="select-media" data-id="{{._id}}" title="{{.title}}">
+		{{if .is_image}}{{if $sizes.thumb}}<img src="/media/{{._id}}/thumb" alt="{{.alt}}">{{else}}{{.name}}{{end}}{{else}}{{.name}}{{end}}
+	</a>
+{{else}}
+	No media yet.
+{{end}}
+{{$navi := .media_list_navi}}
+{{require admin/navi.t}}
+</body>
+</html>
\ No newline at end of file
@@ -0,0 +1,8 @@
+<div id="left-sidebar">
+	<ul>
+		<li><a href="/admin/media">All media</a></li>
+		<li><a href="/admin/media?images=1">Images</a></li>
+	</ul>
+</div>
+
+<div id="inner-content">
\ No newline at end of file
@@ -0,0 +1,10 @@
+<form action="/b/media/upload" method="post" enctype="multipart/form-data">
+	<input type="file" name="files" multiple>
+	<input type="submit" value="Upload">
+	<span class="info">Max. {{.limits.MaxSize}} bytes per file.</span>
+</form>
+<form>
+	<input name="search" value="{{.search}}">
+	{{if .field}}<input type="hidden" name="field" value="{{.field}}">{{end}}
+	<input type="submit" value="Search">
+</form>
\ No newline at end of file
@@ -0,0 +1,472 @@
+// Package output_cache_model stores rendered pages and fragments for visitors below a given user level.
+// Entries are tagged, the hooks of the module drop them by tag when the data they were built from changes.
+package output_cache_model
+
+import (
+	"container/list"
+	"fmt"
+	"github.com/opesun/extract"
+	"github.com/opesun/hypecms/model/basic"
+	"github.com/opesun/jsonp"
+	"github.com/opesun/numcon"
+	"labix.org/v2/mgo"
+	"labix.org/v2/mgo/bson"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	Cname         = "output_cache"
+	Default_level = 1   // Only strangers get cached pages by default.
+	Default_ttl   = 600 // Seconds.
+	// Entries the memory backend holds at most, the least recently used ones go first.
+	Default_max_entries = 1000
+)
+
+// Query parameters which make a different page by default, pages with other parameters are not cached, so clients can't fill
+// the cache with ?x=1, ?x=2...
+var Default_params = []string{"page", "comments-page", "search", "lang"}
+
+// Every page depends on the contents (there may be a list of the latest posts in the sidebar), content pages get the Content_tag too,
+// so a comment only invalidates the page of its content.
+const (
+	Contents_tag = "contents"
+	Content_tag  = "content:" // + hex id of the content.
+)
+
+type m map[string]interface{}
+
+type Entry struct {
+	Key         string   `bson:"_id"`
+	Body        []byte   `bson:"body"`
+	ContentType string   `bson:"content_type"`
+	Tags        []string `bson:"tags"`
+	Version     string   `bson:"version"` // Options version the entry was built with, saving the options invalidates everything this way.
+	Expires     int64    `bson:"expires"`
+}
+
+func (e *Entry) valid(version string) bool {
+	return e.Version == version && e.Expires > time.Now().Unix()
+}
+
+func (e *Entry) tagged(tags []string) bool {
+	for _, v := range e.Tags {
+		for _, x := range tags {
+			if v == x {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+type Backend interface {
+	// Returns nil if there is no valid entry under key.
+	Get(key, version string) (*Entry, error)
+	Set(e *Entry) error
+	// Drops every entry having any of the tags.
+	Invalidate(tags ...string) error
+}
+
+// Memory backend, shared by all requests of the process. Holds at most max entries, the least recently used ones are evicted
+// (after the expired ones) when it is full.
+type memory struct {
+	mut     sync.Mutex
+	max     int
+	entries map[string]*list.Element // Values are *Entry.
+	order   *list.List               // Most recently used first.
+}
+
+func newMemory(max int) *memory {
+	return &memory{max: max, entries: map[string]*list.Element{}, order: list.New()}
+}
+
+var mem = newMemory(Default_max_entries)
+
+func (c *memory) remove(el *list.Element) {
+	delete(c.entries, el.Value.(*Entry).Key)
+	c.order.Remove(el)
+}
+
+func (c *memory) setMax(max int) {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	c.max = max
+}
+
+func (c *memory) Get(key, version string) (*Entry, error) {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	el, has := c.entries[key]
+	if !has {
+		return nil, nil
+	}
+	e := el.Value.(*Entry)
+	if !e.valid(version) {
+		c.remove(el)
+		return nil, nil
+	}
+	c.order.MoveToFront(el)
+	return e, nil
+}
+
+// Drops the expired entries, called only when the cache is full.
+func (c *memory) sweep() {
+	now := time.Now().Unix()
+	for el := c.order.Back(); el != nil; {
+		prev := el.Prev()
+		if el.Value.(*Entry).Expires <= now {
+			c.remove(el)
+		}
+		el = prev
+	}
+}
+
+func (c *memory) Set(e *Entry) error {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	if el, has := c.entries[e.Key]; has {
+		el.Value = e
+		c.order.MoveToFront(el)
+		return nil
+	}
+	if len(c.entries) >= c.max {
+		c.sweep()
+	}
+	for len(c.entries) >= c.max && c.order.Len() > 0 {
+		c.remove(c.order.Back())
+	}
+	if c.max < 1 {
+		return nil
+	}
+	c.entries[e.Key] = c.order.PushFront(e)
+	return nil
+}
+
+func (c *memory) Invalidate(tags ...string) error {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	for _, el := range c.entries {
+		if el.Value.(*Entry).tagged(tags) {
+			c.remove(el)
+		}
+	}
+	return nil
+}
+
+func (c *memory) clear() {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	c.entries = map[string]*list.Element{}
+	c.order.Init()
+}
+
+// MongoDB backend, useful when more processes serve the same site.
+type mongo struct {
+	db *mgo.Database
+}
+
+func (c *mongo) Get(key, version string) (*Entry, error) {
+	var e Entry
+	err := c.db.C(Cname).Find(m{"_id": key}).One(&e)
+	if err == mgo.ErrNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !e.valid(version) {
+		return nil, nil
+	}
+	return &e, nil
+}
+
+func (c *mongo) Set(e *Entry) error {
+	_, err := c.db.C(Cname).Upsert(m{"_id": e.Key}, e)
+	return err
+}
+
+func (c *mongo) Invalidate(tags ...string) error {
+	_, err := c.db.C(Cname).RemoveAll(m{"tags": m{"$in": tags}})
+	return err
+}
+
+func setting(opt map[string]interface{}, key string) (interface{}, bool) {
+	return jsonp.Get(opt, "Modules.output_cache."+key)
+}
+
+func Installed(opt map[string]interface{}) bool {
+	_, has := jsonp.Get(opt, "Modules.output_cache")
+	return has
+}
+
+// Users below this level get cached pages.
+func Level(opt map[string]interface{}) int {
+	if v, has := setting(opt, "level"); has {
+		return numcon.IntP(v)
+	}
+	return Default_level
+}
+
+// Tells if the pages of a user with a given level can be cached at all.
+func Cacheable(opt map[string]interface{}, ulev int) bool {
+	return ulev < Level(opt)
+}
+
+// Separates the entries of users: empty for strangers, the hex id for anyone else (guests included), so a page rendered for one
+// user (with their name, a logout link...) is never served to an other one.
+func UserKey(ulev int, user map[string]interface{}) string {
+	if ulev == 0 {
+		return ""
+	}
+	switch id := user["_id"].(type) {
+	case bson.ObjectId:
+		return id.Hex()
+	case string:
+		return id
+	}
+	return ""
+}
+
+// Seconds an entry lives at most.
+func Ttl(opt map[string]interface{}) int64 {
+	if v, has := setting(opt, "ttl"); has {
+		if n := numcon.IntP(v); n > 0 {
+			return int64(n)
+		}
+	}
+	return Default_ttl
+}
+
+// Number of entries the memory backend holds at most.
+func MaxEntries(opt map[string]interface{}) int {
+	if v, has := setting(opt, "max_entries"); has {
+		if n := numcon.IntP(v); n > 0 {
+			return n
+		}
+	}
+	return Default_max_entries
+}
+
+// Query parameters which make a different page.
+func Params(opt map[string]interface{}) []string {
+	if v, has := jsonp.GetS(opt, "Modules.output_cache.params"); has {
+		return jsonp.ToStringSlice(v)
+	}
+	return Default_params
+}
+
+// The backend set in the options, "memory" (default) or "mongo".
+func GetBackend(db *mgo.Database, opt map[string]interface{}) Backend {
+	if b, _ := setting(opt, "backend"); b == "mongo" {
+		return &mongo{db}
+	}
+	mem.setMax(MaxEntries(opt))
+	return mem
+}
+
+// Key of a page: host, path, the query string (parameters sorted), the language and the user (see UserKey).
+// Returns false if the query has a parameter not among params, such pages are not cached.
+func Key(host, path string, query url.Values, lang, user string, params []string) (string, bool) {
+	for i, _ := range query {
+		known := false
+		for _, v := range params {
+			if v == i {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return "", false
+		}
+	}
+	return userSuffix(host+path+"?"+query.Encode()+"|"+lang, user), true
+}
+
+func userSuffix(key, user string) string {
+	if len(user) == 0 {
+		return key
+	}
+	return key + "|u:" + user
+}
+
+// Key of a fragment, name is the name of the template it is rendered from.
+func FragmentKey(host, name, lang, user string) string {
+	return userSuffix(host+"#"+name+"|"+lang, user)
+}
+
+func NewEntry(opt map[string]interface{}, version, key string, body []byte, content_type string, tags []string) *Entry {
+	return &Entry{
+		Key:         key,
+		Body:        body,
+		ContentType: content_type,
+		Tags:        tags,
+		Version:     version,
+		Expires:     time.Now().Unix() + Ttl(opt),
+	}
+}
+
+// Drops the entries depending on a given content.
+func InvalidateContent(db *mgo.Database, opt map[string]interface{}, content_id bson.ObjectId) error {
+	return GetBackend(db, opt).Invalidate(Content_tag + content_id.Hex())
+}
+
+// Drops everything built from the contents.
+func InvalidateContents(db *mgo.Database, opt map[string]interface{}) error {
+	return GetBackend(db, opt).Invalidate(Contents_tag)
+}
+
+// Drops every entry, from both backends.
+func Clear(db *mgo.Database) error {
+	mem.clear()
+	_, err := db.C(Cname).RemoveAll(nil)
+	return err
+}
+
+func SaveConfig(db *mgo.Database, inp map[string][]string) error {
+	rule := map[string]interface{}{
+		"level":       "must",
+		"ttl":         "must",
+		"backend":     "must",
+		"max_entries": 1,
+		"params":      1,
+	}
+	dat, err := extract.New(rule).Extract(inp)
+	if err != nil {
+		return err
+	}
+	level, err := strconv.Atoi(strings.TrimSpace(dat["level"].(string)))
+	if err != nil || level < 0 {
+		return fmt.Errorf("Level must be a non-negative number.")
+	}
+	ttl, err := strconv.Atoi(strings.TrimSpace(dat["ttl"].(string)))
+	if err != nil || ttl < 1 {
+		return fmt.Errorf("Time to live must be a positive number.")
+	}
+	backend := dat["backend"].(string)
+	if backend != "memory" && backend != "mongo" {
+		return fmt.Errorf("Unkown backend %v.", backend)
+	}
+	max_entries := Default_max_entries
+	if v, _ := dat["max_entries"].(string); len(strings.TrimSpace(v)) > 0 {
+		max_entries, err = strconv.Atoi(strings.TrimSpace(v))
+		if err != nil || max_entries < 1 {
+			return fmt.Errorf("Maximum number of entries must be a positive number.")
+		}
+	}
+	params := []interface{}{}
+	pstr, _ := dat["params"].(string)
+	for _, v := range strings.Split(pstr, ",") {
+		if v = strings.TrimSpace(v); len(v) > 0 {
+			params = append(params, v)
+		}
+	}
+	id := basic.CreateOptCopy(db)
+	front, err := frontFirst(db, id)
+	if err != nil {
+		return err
+	}
+	upd := m{
+		"$set": m{
+			"Hooks.Front":                      front,
+			"Modules.output_cache.level":       level,
+			"Modules.output_cache.ttl":         ttl,
+			"Modules.output_cache.backend":     backend,
+			"Modules.output_cache.max_entries": max_entries,
+			"Modules.output_cache.params":      params,
+		},
+	}
+	return db.C("options").Update(m{"_id": id}, upd)
+}
+
+// Tells if the output cache is the first Front hook. Modules installed later are appended after it, but one can still get
+// in front of it by editing the options.
+func IsFirst(opt map[string]interface{}) bool {
+	hooks, _ := jsonp.GetS(opt, "Hooks.Front")
+	return len(hooks) > 0 && hooks[0] == "output_cache"
+}
+
+// The Front hooks of the option document id with the output cache moved to the first place.
+func frontFirst(db *mgo.Database, id bson.ObjectId) ([]string, error) {
+	var opt m
+	err := db.C("options").Find(m{"_id": id}).One(&opt)
+	if err != nil {
+		return nil, err
+	}
+	front := []string{"output_cache"}
+	hooks, _ := jsonp.GetS(map[string]interface{}(opt), "Hooks.Front")
+	for _, v := range jsonp.ToStringSlice(hooks) {
+		if v != "output_cache" {
+			front = append(front, v)
+		}
+	}
+	return front, nil
+}
+
+// The output cache must be the first Front hook, so it can answer before the others do any work.
+// Saving the config puts it back to the first place if something got in front of it.
+func Install(db *mgo.Database, id bson.ObjectId) error {
+	front, err := frontFirst(db, id)
+	if err != nil {
+		return err
+	}
+	params := []interface{}{}
+	for _, v := range Default_params {
+		params = append(params, v)
+	}
+	upd := m{
+		"$set": m{
+			"Hooks.Front": front,
+			"Modules.output_cache": m{
+				"level":       Default_level,
+				"ttl":         Default_ttl,
+				"backend":     "memory",
+				"max_entries": Default_max_entries,
+				"params":      params,
+			},
+		},
+		"$addToSet": m{
+			"Hooks.AfterDisplay":    "output_cache",
+			"Hooks.contents.insert": "output_cache",
+			"Hooks.contents.update": "output_cache",
+			"Hooks.contents.delete": "output_cache",
+			"Hooks.comments.insert": "output_cache",
+			"Hooks.comments.final":  "output_cache",
+			"Hooks.comments.update": "output_cache",
+			"Hooks.comments.delete": "output_cache",
+		},
+	}
+	err = db.C("options").Update(m{"_id": id}, upd)
+	if err != nil {
+		return err
+	}
+	return db.C(Cname).EnsureIndex(mgo.Index{Key: []string{"tags"}})
+}
+
+func Uninstall(db *mgo.Database, id bson.ObjectId) error {
+	q := m{"_id": id}
+	upd := m{
+		"$pull": m{
+			"Hooks.Front":           "output_cache",
+			"Hooks.AfterDisplay":    "output_cache",
+			"Hooks.contents.insert": "output_cache",
+			"Hooks.contents.update": "output_cache",
+			"Hooks.contents.delete": "output_cache",
+			"Hooks.comments.insert": "output_cache",
+			"Hooks.comments.final":  "output_cache",
+			"Hooks.comments.update": "output_cache",
+			"Hooks.comments.delete": "output_cache",
+		},
+		"$unset": m{
+			"Modules.output_cache": 1,
+		},
+	}
+	err := db.C("options").Update(q, upd)
+	if err != nil {
+		return err
+	}
+	return Clear(db)
+}
@@ -0,0 +1,94 @@
+package output_cache_model
+
+import (
+	"labix.org/v2/mgo/bson"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func entry(key string, ttl int64, tags ...string) *Entry {
+	return &Entry{Key: key, Version: "v", Expires: time.Now().Unix() + ttl, Tags: tags}
+}
+
+func TestMemoryBound(t *testing.T) {
+	c := newMemory(3)
+	for i := 0; i < 3; i++ {
+		c.Set(entry(strconv.Itoa(i), 60))
+	}
+	c.Get("0", "v") // 1 is the least recently used now.
+	c.Set(entry("3", 60))
+	if len(c.entries) != 3 || c.order.Len() != 3 {
+		t.Fatal(len(c.entries), c.order.Len())
+	}
+	if e, _ := c.Get("1", "v"); e != nil {
+		t.Fatal("Least recently used entry should be evicted.")
+	}
+	for _, v := range []string{"0", "2", "3"} {
+		if e, _ := c.Get(v, "v"); e == nil {
+			t.Fatal(v, "should be kept.")
+		}
+	}
+	// Expired ones go first.
+	c.Set(entry("2", -1))
+	c.Get("2", "x") // Wrong version, dropped.
+	c.Set(entry("4", 60))
+	c.Set(entry("5", 60))
+	if len(c.entries) != 3 {
+		t.Fatal(len(c.entries))
+	}
+	s := newMemory(2)
+	s.Set(entry("fresh", 60))
+	s.Set(entry("expired", -1)) // Most recently used, but expired.
+	s.Set(entry("new", 60))
+	if _, has := s.entries["fresh"]; !has || len(s.entries) != 2 {
+		t.Fatal("Expired entries should be evicted first.")
+	}
+	c.Set(entry("6", 60, "contents"))
+	c.Invalidate("contents")
+	if e, _ := c.Get("6", "v"); e != nil || len(c.entries) != c.order.Len() {
+		t.Fatal("Invalidated entry is still there.")
+	}
+}
+
+func TestKey(t *testing.T) {
+	params := []string{"page", "search"}
+	k1, ok1 := Key("example.com", "/blog", url.Values{"search": {"a"}, "page": {"2"}}, "en", "", params)
+	k2, ok2 := Key("example.com", "/blog", url.Values{"page": {"2"}, "search": {"a"}}, "en", "", params)
+	if !ok1 || !ok2 || k1 != k2 {
+		t.Fatal(k1, k2)
+	}
+	if _, ok := Key("example.com", "/blog", url.Values{"x": {"1"}}, "en", "", params); ok {
+		t.Fatal("Unknown parameters should not be cached.")
+	}
+	if k, ok := Key("example.com", "/blog", url.Values{}, "en", "", nil); !ok || k != "example.com/blog?|en" {
+		t.Fatal(k, ok)
+	}
+}
+
+func TestGuest(t *testing.T) {
+	stranger := map[string]interface{}{"level": 0}
+	guest := map[string]interface{}{"_id": bson.NewObjectId(), "level": 1, "guest_name": "Joe"}
+	if !Cacheable(nil, 0) || Cacheable(nil, 1) {
+		t.Fatal("By default only strangers should be cached.")
+	}
+	// Even if the level is raised, the guest must not read or fill the entry of strangers.
+	c := newMemory(10)
+	sk, _ := Key("example.com", "/", url.Values{}, "en", UserKey(0, stranger), nil)
+	gk, _ := Key("example.com", "/", url.Values{}, "en", UserKey(1, guest), nil)
+	if sk == gk {
+		t.Fatal("Guest and stranger share a key:", sk)
+	}
+	c.Set(entry(sk, 60))
+	if e, _ := c.Get(gk, "v"); e != nil {
+		t.Fatal("Guest read the page of strangers.")
+	}
+	c.Set(entry(gk, 60))
+	if e, _ := c.Get(sk, "v"); e == nil || e.Key != sk {
+		t.Fatal("Guest overwrote the page of strangers.")
+	}
+	if FragmentKey("example.com", "sidebar", "en", UserKey(1, guest)) == FragmentKey("example.com", "sidebar", "en", UserKey(0, stranger)) {
+		t.Fatal("Guest and stranger share a fragment key.")
+	}
+}
@@ -0,0 +1,198 @@
+// Package output_cache serves whole pages from a cache for users below a configured level (strangers by default).
+// Users above level 0 get entries of their own, so a personalised page is never served to someone else.
+// It must be the first Front hook: on a hit it writes the page right away, on a miss it records the response and stores it in the AfterDisplay hook.
+// Install puts it to the first place, saving the config on the admin puts it back there if something got in front of it.
+// Templates can cache parts of the page too with the fragment builtin, see the display module.
+package output_cache
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/opesun/hypecms/api/context"
+	"github.com/opesun/hypecms/model/scut"
+	"github.com/opesun/hypecms/modules/output_cache/model"
+	"github.com/opesun/jsonp"
+	"io"
+	"labix.org/v2/mgo/bson"
+	"net/http"
+	"strings"
+)
+
+// Passes everything trough to the original ResponseWriter while keeping a copy of the page.
+type recorder struct {
+	http.ResponseWriter
+	status int
+	buf    bytes.Buffer
+	key    string
+}
+
+func (r *recorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *recorder) Write(b []byte) (int, error) {
+	r.buf.Write(b)
+	return r.ResponseWriter.Write(b)
+}
+
+func lang(uni *context.Uni) string {
+	langs, _ := jsonp.GetS(uni.Dat, "_user.languages")
+	if len(langs) == 0 {
+		return ""
+	}
+	l, _ := langs[0].(string)
+	return l
+}
+
+func userKey(uni *context.Uni) string {
+	user, _ := uni.Dat["_user"].(map[string]interface{})
+	return output_cache_model.UserKey(scut.Ulev(user), user)
+}
+
+func cacheable(uni *context.Uni) bool {
+	return uni.Req.Method == "GET" && output_cache_model.Cacheable(uni.Opt, scut.Ulev(uni.Dat["_user"]))
+}
+
+func (h *H) Front() (bool, error) {
+	uni := h.uni
+	if !cacheable(uni) {
+		return false, nil
+	}
+	key, ok := output_cache_model.Key(uni.Req.Host, uni.P, uni.Req.Form, lang(uni), userKey(uni), output_cache_model.Params(uni.Opt))
+	if !ok {
+		return false, nil
+	}
+	e, err := output_cache_model.GetBackend(uni.Db, uni.Opt).Get(key, scut.OptVersion(uni.OriginalOpt()))
+	if err != nil {
+		return false, nil // The page can still be built without the cache.
+	}
+	if e != nil {
+		uni.W.Header().Set("Content-Type", e.ContentType)
+		uni.W.Header().Set("X-Cache", "hit")
+		uni.W.Write(e.Body)
+		uni.Dat["_written"] = true
+		return true, nil
+	}
+	uni.W.Header().Set("X-Cache", "miss")
+	rec := &recorder{ResponseWriter: uni.W, status: 200, key: key}
+	uni.W = rec
+	uni.Put = func(a ...interface{}) {
+		io.WriteString(rec, fmt.Sprint(a...)+"\n")
+	}
+	return false, nil
+}
+
+// Stores the recorded page if everything went fine.
+func (h *H) AfterDisplay() error {
+	uni := h.uni
+	rec, ok := uni.W.(*recorder)
+	if !ok || rec.status != 200 {
+		return nil
+	}
+	if _, missing := uni.Dat["missing_file"]; missing {
+		return nil // 404 pages are displayed with a 200.
+	}
+	tags := []string{output_cache_model.Contents_tag}
+	if id, has := jsonp.GetStr(uni.Dat, "content._id"); has {
+		tags = append(tags, output_cache_model.Content_tag+id)
+	}
+	e := output_cache_model.NewEntry(uni.Opt, scut.OptVersion(uni.OriginalOpt()), rec.key, rec.buf.Bytes(), rec.Header().Get("Content-Type"), tags)
+	return output_cache_model.GetBackend(uni.Db, uni.Opt).Set(e)
+}
+
+func (h *H) ContentsInsert(dat map[string]interface{}) error {
+	return output_cache_model.InvalidateContents(h.uni.Db, h.uni.Opt)
+}
+
+func (h *H) ContentsUpdate(dat map[string]interface{}) error {
+	return h.ContentsInsert(dat)
+}
+
+func (h *H) ContentsDelete(dat map[string]interface{}) error {
+	return h.ContentsInsert(dat)
+}
+
+// All comment events end up here, only the page of the content is affected.
+func (h *H) comment(dat map[string]interface{}) error {
+	id, ok := dat["_contents_parent"].(bson.ObjectId)
+	if !ok {
+		return nil
+	}
+	return output_cache_model.InvalidateContent(h.uni.Db, h.uni.Opt, id)
+}
+
+// Comments waiting for moderation are not shown anyway.
+func (h *H) CommentsInsert(dat map[string]interface{}) error {
+	if in_mod, _ := dat["in_moderation"].(bool); in_mod {
+		return nil
+	}
+	return h.comment(dat)
+}
+
+func (h *H) CommentsFinal(dat map[string]interface{}) error {
+	return h.comment(dat)
+}
+
+func (h *H) CommentsUpdate(dat map[string]interface{}) error {
+	return h.comment(dat)
+}
+
+func (h *H) CommentsDelete(dat map[string]interface{}) error {
+	return h.comment(dat)
+}
+
+func (h *H) Install(id bson.ObjectId) error {
+	return output_cache_model.Install(h.uni.Db, id)
+}
+
+func (h *H) Uninstall(id bson.ObjectId) error {
+	return output_cache_model.Uninstall(h.uni.Db, id)
+}
+
+func (a *A) SaveConfig() error {
+	return output_cache_model.SaveConfig(a.uni.Db, a.uni.Req.Form)
+}
+
+func (a *A) Clear() error {
+	return output_cache_model.Clear(a.uni.Db)
+}
+
+func (v *V) Index() error {
+	uni := v.uni
+	uni.Dat["level"] = output_cache_model.Level(uni.Opt)
+	uni.Dat["ttl"] = output_cache_model.Ttl(uni.Opt)
+	backend, has := jsonp.GetStr(uni.Opt, "Modules.output_cache.backend")
+	if !has {
+		backend = "memory"
+	}
+	uni.Dat["backend"] = backend
+	uni.Dat["max_entries"] = output_cache_model.MaxEntries(uni.Opt)
+	uni.Dat["params"] = strings.Join(output_cache_model.Params(uni.Opt), ", ")
+	uni.Dat["first"] = output_cache_model.IsFirst(uni.Opt)
+	return nil
+}
+
+type A struct {
+	uni *context.Uni
+}
+
+func Actions(uni *context.Uni) *A {
+	return &A{uni}
+}
+
+type H struct {
+	uni *context.Uni
+}
+
+func Hooks(uni *context.Uni) *H {
+	return &H{uni}
+}
+
+type V struct {
+	uni *context.Uni
+}
+
+func Views(uni *context.Uni) *V {
+	return &V{uni}
+}
@@ -0,0 +1,40 @@
+{{require admin/header.t}}
+{{require output_cache/sidebar.t}}
+
+<h4>Output cache</h4>
+Pages (and fragments, see the fragment template function) are cached for users below the given level, level 1 caches only strangers.<br />
+Users above level 0 (guests too) get entries of their own, a high level can fill the cache quickly.<br />
+Contents, comments and saving the options invalidate the affected entries automatically.<br />
+<br />
+{{if not .first}}
+	The output cache is not the first Front hook, other modules run before a cached page is served. Save the config to fix this.<br />
+	<br />
+{{end}}
+<form action="/b/output_cache/save_config" method="post">
+	Cache pages for users below level:<br />
+	<input name="level" type="text" value="{{.level}}" /><br />
+	<br />
+	Time to live in seconds:<br />
+	<input name="ttl" type="text" value="{{.ttl}}" /><br />
+	<br />
+	Maximum number of entries in memory:<br />
+	<input name="max_entries" type="text" value="{{.max_entries}}" /><br />
+	<br />
+	Query parameters making a different page, comma separated (pages with other parameters are not cached):<br />
+	<input name="params" type="text" value="{{.params}}" /><br />
+	<br />
+	Backend:<br />
+	<select name="backend">
+		<option value="memory"{{if eq .backend "memory"}} selected="selected"{{end}}>memory</option>
+		<option value="mongo"{{if eq .backend "mongo"}} selected="selected"{{end}}>MongoDB</option>
+	</select><br />
+	<br />
+	<input type="submit" value="Save">
+</form>
+<br />
+<form action="/b/output_cache/clear" method="post">
+	<input type="submit" value="Clear the cache">
+</form>
+
+{{require output_cache/footer.t}}
+{{require admin/footer.t}}
\ No newline at end of file
@@ -0,0 +1,7 @@
+<div id="left-sidebar">
+	<ul>
+		<li><a href="/admin/output_cache">Output cache</a></li>
+	</ul>
+</div>
+
+<div id="inner-content">
\ No newline at end of file
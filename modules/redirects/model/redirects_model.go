@@ -0,0 +1,359 @@
+package redirects_model
+
+import (
+	"fmt"
+	"github.com/opesun/extract"
+	"github.com/opesun/hypecms/model/basic"
+	"labix.org/v2/mgo"
+	"labix.org/v2/mgo/bson"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	Cname              = "redirects"
+	Slug_history_cname = "slug_history"
+)
+
+// Kinds of redirect rules.
+const (
+	Exact    = "exact"    // "from" is a path, eg. "old-page".
+	Wildcard = "wildcard" // "from" can contain *s, they are substituted in order into the *s of "to". Eg. "blog/*" -> "news/*".
+	Regex    = "regex"    // "from" is a regexp matching the whole path, "to" can reference its groups: "archive/([0-9]+)" -> "year/$1".
+)
+
+type m map[string]interface{}
+
+// Paths are stored without leading and trailing slashes.
+func CleanPath(p string) string {
+	return strings.Trim(p, "/")
+}
+
+// Saves a redirect created by the system, eg. when a page is moved in the page tree.
+// Existing redirects pointing to from are updated too, so there are no redirect chains.
+func AddAuto(db *mgo.Database, from, to string) error {
+	c := db.C(Cname)
+	_, err := c.UpdateAll(m{"kind": Exact, "to": from}, m{"$set": m{"to": to}})
+	if err != nil {
+		return err
+	}
+	_, err = c.Upsert(m{"kind": Exact, "from": from}, m{
+		"$set": m{
+			"to":   to,
+			"code": 301,
+			"auto": true,
+		},
+		"$setOnInsert": m{
+			"hits":        0,
+			basic.Created: time.Now().Unix(),
+		},
+	})
+	if err != nil {
+		return err
+	}
+	// When something is moved back to its old place.
+	_, err = c.RemoveAll(m{"kind": Exact, "from": to})
+	return err
+}
+
+func wildcardToRegexp(from string) string {
+	parts := strings.Split(from, "*")
+	for i, v := range parts {
+		parts[i] = regexp.QuoteMeta(v)
+	}
+	return "^" + strings.Join(parts, "(.*)") + "$"
+}
+
+func substituteWildcards(to string, groups []string) string {
+	for _, v := range groups {
+		if !strings.Contains(to, "*") {
+			break
+		}
+		to = strings.Replace(to, "*", v, 1)
+	}
+	return to
+}
+
+// Compiled patterns of the wildcard and regex rules, so they are not compiled again on every request.
+var (
+	rx_cache = map[string]*regexp.Regexp{}
+	rx_lock  sync.RWMutex
+)
+
+func compile(pattern string) (*regexp.Regexp, error) {
+	rx_lock.RLock()
+	rx, has := rx_cache[pattern]
+	rx_lock.RUnlock()
+	if has {
+		return rx, nil
+	}
+	rx, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	rx_lock.Lock()
+	rx_cache[pattern] = rx
+	rx_lock.Unlock()
+	return rx, nil
+}
+
+type rule struct {
+	Id   bson.ObjectId `bson:"_id"`
+	From string        `bson:"from"`
+	To   string        `bson:"to"`
+	Kind string        `bson:"kind"`
+	Code int           `bson:"code"`
+}
+
+// Returns the target and the status code of the rule matching the path, if any.
+func (r *rule) match(path string) (string, bool) {
+	switch r.Kind {
+	case Exact:
+		return r.To, r.From == path
+	case Wildcard:
+		rx, err := compile(wildcardToRegexp(r.From))
+		if err != nil {
+			return "", false
+		}
+		groups := rx.FindStringSubmatch(path)
+		if groups == nil {
+			return "", false
+		}
+		return substituteWildcards(r.To, groups[1:]), true
+	case Regex:
+		rx, err := compile("^(?:" + r.From + ")$")
+		if err != nil {
+			return "", false
+		}
+		loc := rx.FindStringSubmatchIndex(path)
+		if loc == nil {
+			return "", false
+		}
+		return string(rx.ExpandString(nil, r.To, path, loc)), true
+	}
+	return "", false
+}
+
+func hit(db *mgo.Database, id bson.ObjectId) {
+	db.C(Cname).Update(m{"_id": id}, m{"$inc": m{"hits": 1}, "$set": m{"last_hit": time.Now().Unix()}})
+}
+
+// Finds the redirect rule matching a path and counts the hit.
+// Exact rules are checked first, then the wildcard and regex rules in the order of their creation.
+// Returns the target path (or url) and the HTTP status code.
+func Find(db *mgo.Database, path string) (string, int, bool) {
+	path = CleanPath(path)
+	var r rule
+	err := db.C(Cname).Find(m{"kind": Exact, "from": path}).One(&r)
+	if err != nil {
+		var rules []rule
+		db.C(Cname).Find(m{"kind": m{"$in": []string{Wildcard, Regex}}}).Sort(basic.Created).All(&rules)
+		found := false
+		for _, v := range rules {
+			if _, ok := v.match(path); ok {
+				r = v
+				found = true
+				break
+			}
+		}
+		if !found {
+			return "", 0, false
+		}
+	}
+	to, _ := r.match(path)
+	hit(db, r.Id)
+	code := r.Code
+	if code == 0 {
+		code = 301
+	}
+	return to, code, true
+}
+
+// Finds where an old path should go: the matching redirect rule, or the current place of the content which had the path as its slug.
+// Returns the location to redirect to and the HTTP status code.
+func Resolve(db *mgo.Database, path string) (string, int, bool) {
+	path = CleanPath(path)
+	if len(path) == 0 {
+		return "", 0, false
+	}
+	if to, code, found := Find(db, path); found {
+		return Location(to), code, true
+	}
+	if strings.Contains(path, "/") {
+		return "", 0, false
+	}
+	content_id, has := SlugOwner(db, path)
+	if !has {
+		return "", 0, false
+	}
+	content := basic.Find(db, "contents", content_id)
+	if content == nil {
+		return "", 0, false
+	}
+	to, _ := content["path"].(string) // Pages are accessed by their full path.
+	if len(to) == 0 {
+		to, _ = content["slug"].(string)
+	}
+	if len(to) == 0 || to == path {
+		return "", 0, false
+	}
+	return Location(to), 301, true
+}
+
+// Full urls are left alone, everything else is treated as a path on the site.
+func Location(to string) string {
+	if strings.HasPrefix(to, "http://") || strings.HasPrefix(to, "https://") {
+		return to
+	}
+	return "/" + CleanPath(to)
+}
+
+// Saves a manual redirect. Updates the existing one if id is sent.
+func Save(db *mgo.Database, inp map[string][]string) error {
+	r := map[string]interface{}{
+		"id":   1,
+		"from": "must",
+		"to":   "must",
+		"kind": "must",
+		"code": 1,
+	}
+	dat, err := extract.New(r).Extract(inp)
+	if err != nil {
+		return err
+	}
+	from := CleanPath(dat["from"].(string))
+	to := dat["to"].(string)
+	kind := dat["kind"].(string)
+	if len(from) == 0 || len(to) == 0 {
+		return fmt.Errorf("Both from and to must be given.")
+	}
+	switch kind {
+	case Exact, Wildcard:
+	case Regex:
+		if _, err := regexp.Compile(from); err != nil {
+			return fmt.Errorf("Bad regexp: %v", err)
+		}
+	default:
+		return fmt.Errorf("Unkown redirect kind %v.", kind)
+	}
+	code := 301
+	if c, has := dat["code"].(string); has && len(c) > 0 {
+		code, err = strconv.Atoi(c)
+		if err != nil || (code != 301 && code != 302) {
+			return fmt.Errorf("Status code must be 301 or 302.")
+		}
+	}
+	doc := m{
+		"from": from,
+		"to":   to,
+		"kind": kind,
+		"code": code,
+		"auto": false,
+	}
+	id, _ := dat["id"].(string)
+	if kind == Exact {
+		q := m{"kind": Exact, "from": from}
+		if len(id) > 0 {
+			q["_id"] = m{"$ne": basic.ToIdWithCare(id)}
+		}
+		count, err := db.C(Cname).Find(q).Count()
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			return fmt.Errorf("There is already a redirect from %v.", from)
+		}
+	}
+	if len(id) > 0 {
+		return db.C(Cname).Update(m{"_id": basic.ToIdWithCare(id)}, m{"$set": doc})
+	}
+	doc["hits"] = 0
+	doc[basic.Created] = time.Now().Unix()
+	return db.C(Cname).Insert(doc)
+}
+
+func Delete(db *mgo.Database, id bson.ObjectId) error {
+	return db.C(Cname).Remove(m{"_id": id})
+}
+
+// Remembers that a content was accessible at old_slug, so the old urls keep working after a rename.
+func RecordSlug(db *mgo.Database, content_id bson.ObjectId, old_slug, new_slug string) error {
+	c := db.C(Slug_history_cname)
+	if len(old_slug) > 0 && old_slug != new_slug {
+		_, err := c.Upsert(m{"slug": old_slug}, m{"$set": m{"content_id": content_id, basic.Created: time.Now().Unix()}})
+		if err != nil {
+			return err
+		}
+	}
+	// The new slug belongs to a living content now.
+	_, err := c.RemoveAll(m{"slug": new_slug})
+	return err
+}
+
+// Returns the content which was accessible at slug earlier.
+func SlugOwner(db *mgo.Database, slug string) (bson.ObjectId, bool) {
+	var h struct {
+		ContentId bson.ObjectId `bson:"content_id"`
+	}
+	err := db.C(Slug_history_cname).Find(m{"slug": slug}).One(&h)
+	if err != nil {
+		return "", false
+	}
+	return h.ContentId, true
+}
+
+// Old slugs of a content, newest first.
+func SlugHistory(db *mgo.Database, content_id bson.ObjectId) []string {
+	var res []struct {
+		Slug string `bson:"slug"`
+	}
+	db.C(Slug_history_cname).Find(m{"content_id": content_id}).Sort("-" + basic.Created).All(&res)
+	ret := []string{}
+	for _, v := range res {
+		ret = append(ret, v.Slug)
+	}
+	return ret
+}
+
+func Install(db *mgo.Database, id bson.ObjectId) error {
+	err := db.C(Cname).EnsureIndex(mgo.Index{Key: []string{"kind", "from"}})
+	if err != nil {
+		return err
+	}
+	err = db.C(Slug_history_cname).EnsureIndex(mgo.Index{Key: []string{"slug"}, Unique: true})
+	if err != nil {
+		return err
+	}
+	q := m{"_id": id}
+	upd := m{
+		"$addToSet": m{
+			"Hooks.Front":                "redirects",
+			"Hooks.contents.pathChanged": "redirects",
+			"Hooks.contents.slugChanged": "redirects",
+			"Hooks.contents.editForm":    "redirects",
+		},
+		"$set": m{
+			"Modules.redirects": m{},
+		},
+	}
+	return db.C("options").Update(q, upd)
+}
+
+func Uninstall(db *mgo.Database, id bson.ObjectId) error {
+	q := m{"_id": id}
+	upd := m{
+		"$pull": m{
+			"Hooks.Front":                "redirects",
+			"Hooks.contents.pathChanged": "redirects",
+			"Hooks.contents.slugChanged": "redirects",
+			"Hooks.contents.editForm":    "redirects",
+		},
+		"$unset": m{
+			"Modules.redirects": 1,
+		},
+	}
+	return db.C("options").Update(q, upd)
+}
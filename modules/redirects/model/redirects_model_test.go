@@ -0,0 +1,41 @@
+package redirects_model
+
+import (
+	"testing"
+)
+
+func TestMatch(t *testing.T) {
+	cases := []struct {
+		r        rule
+		path, to string
+		ok       bool
+	}{
+		{rule{Kind: Exact, From: "old-page", To: "new-page"}, "old-page", "new-page", true},
+		{rule{Kind: Exact, From: "old-page", To: "new-page"}, "old-page/x", "", false},
+		{rule{Kind: Wildcard, From: "blog/*", To: "news/*"}, "blog/first-post", "news/first-post", true},
+		{rule{Kind: Wildcard, From: "a/*/b/*", To: "*-*"}, "a/1/b/2", "1-2", true},
+		{rule{Kind: Wildcard, From: "blog/*", To: "news/*"}, "blogs/x", "", false},
+		{rule{Kind: Regex, From: "archive/([0-9]+)", To: "year/$1"}, "archive/2012", "year/2012", true},
+		{rule{Kind: Regex, From: "archive/([0-9]+)", To: "year/$1"}, "archive/2012/x", "", false},
+	}
+	for _, v := range cases {
+		for i := 0; i < 2; i++ { // Second time from the cache.
+			to, ok := v.r.match(v.path)
+			if ok != v.ok || ok && to != v.to {
+				t.Fatal(v.r, v.path, to, ok)
+			}
+		}
+	}
+	if len(rx_cache) != 3 {
+		t.Fatal("Patterns should be compiled once:", len(rx_cache))
+	}
+}
+
+func TestLocation(t *testing.T) {
+	if l := Location("/a/b/"); l != "/a/b" {
+		t.Fatal(l)
+	}
+	if l := Location("http://example.com/x"); l != "http://example.com/x" {
+		t.Fatal(l)
+	}
+}
@@ -0,0 +1,107 @@
+// Package redirects sends permanent redirects for old urls: manual rules (exact, wildcard or regex) set on the admin,
+// paths of moved pages and the old slugs of renamed contents.
+// The content module knows nothing about it, the paths and slugs are recorded in the contents.pathChanged and contents.slugChanged hooks.
+package redirects
+
+import (
+	"github.com/opesun/hypecms/api/context"
+	"github.com/opesun/hypecms/model/basic"
+	"github.com/opesun/hypecms/modules/display/model"
+	"github.com/opesun/hypecms/modules/redirects/model"
+	"labix.org/v2/mgo/bson"
+	"net/http"
+)
+
+type m map[string]interface{}
+
+// Should run after the content Front hook, so existing contents always win.
+func (h *H) Front() (bool, error) {
+	uni := h.uni
+	to, code, found := redirects_model.Resolve(uni.Db, uni.P)
+	if !found {
+		return false, nil
+	}
+	h.redirect(to, code)
+	return true, nil
+}
+
+func (h *H) redirect(to string, code int) {
+	uni := h.uni
+	http.Redirect(uni.W, uni.Req, to, code)
+	uni.Dat["_written"] = true
+}
+
+// A page (or an ancestor of it) was moved or renamed.
+func (h *H) ContentsPathChanged(old_path, new_path string) error {
+	return redirects_model.AddAuto(h.uni.Db, old_path, new_path)
+}
+
+func (h *H) ContentsSlugChanged(id bson.ObjectId, old_slug, new_slug string) error {
+	return redirects_model.RecordSlug(h.uni.Db, id, old_slug, new_slug)
+}
+
+// Shows the old slugs on the edit form of a content.
+func (h *H) ContentsEditForm(id bson.ObjectId) {
+	h.uni.Dat["slug_history"] = redirects_model.SlugHistory(h.uni.Db, id)
+}
+
+func (h *H) Install(id bson.ObjectId) error {
+	return redirects_model.Install(h.uni.Db, id)
+}
+
+func (h *H) Uninstall(id bson.ObjectId) error {
+	return redirects_model.Uninstall(h.uni.Db, id)
+}
+
+func (a *A) Save() error {
+	return redirects_model.Save(a.uni.Db, a.uni.Req.Form)
+}
+
+func (a *A) Delete() error {
+	uni := a.uni
+	return redirects_model.Delete(uni.Db, basic.ToIdWithCare(uni.Req.Form["id"][0]))
+}
+
+func (v *V) Index() error {
+	uni := v.uni
+	q := m{}
+	if _, only_auto := uni.Req.Form["auto"]; only_auto {
+		q["auto"] = true
+	}
+	query := map[string]interface{}{
+		"c":  redirects_model.Cname,
+		"q":  q,
+		"so": "-hits",
+		"p":  "page",
+		"l":  30,
+	}
+	pnq := uni.P + "?" + uni.Req.URL.RawQuery
+	rl := display_model.RunQuery(uni.Db, "redirects", query, uni.Req.Form, pnq)
+	uni.Dat["redirects"] = rl["redirects"]
+	uni.Dat["redirects_navi"] = rl["redirects_navi"]
+	return nil
+}
+
+type A struct {
+	uni *context.Uni
+}
+
+func Actions(uni *context.Uni) *A {
+	return &A{uni}
+}
+
+type H struct {
+	uni *context.Uni
+}
+
+func Hooks(uni *context.Uni) *H {
+	return &H{uni}
+}
+
+type V struct {
+	uni *context.Uni
+}
+
+func Views(uni *context.Uni) *V {
+	return &V{uni}
+}
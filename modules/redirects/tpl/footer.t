@@ -0,0 +1,2 @@
+</div>
+<div style="clear: both;">
\ No newline at end of file
@@ -0,0 +1,45 @@
+{{require admin/header.t}}
+{{require redirects/sidebar.t}}
+
+<h4>Redirects</h4>
+<form action="/b/redirects/save" method="post">
+	From: <input name="from" type="text" />
+	To: <input name="to" type="text" />
+	<select name="kind">
+		<option value="exact">exact</option>
+		<option value="wildcard">wildcard</option>
+		<option value="regex">regex</option>
+	</select>
+	<select name="code">
+		<option value="301">301 permanent</option>
+		<option value="302">302 temporary</option>
+	</select>
+	<input type="submit" value="Add">
+</form>
+Paths are written without the leading slash. Wildcard: blog/* -> news/*. Regex: archive/([0-9]+) -> year/$1.<br />
+<br />
+{{if .redirects}}
+	{{range .redirects}}
+		<div class="list-item">
+			<a class="delete" href="/b/redirects/delete?id={{._id}}">-</a>
+			<form action="/b/redirects/save" method="post" style="display: inline;">
+				<input type="hidden" name="id" value="{{._id}}">
+				<input type="hidden" name="kind" value="{{.kind}}">
+				<input type="hidden" name="code" value="{{.code}}">
+				{{.kind}}{{if .auto}} (moved page){{end}}:
+				<input name="from" value="{{.from}}">
+				-&gt;
+				<input name="to" value="{{.to}}">
+				{{.code}}, {{.hits}} hits{{if .last_hit}}, last at {{date .last_hit}}{{end}}
+				<input type="submit" value="Save">
+			</form>
+		</div>
+	{{end}}
+	{{$navi := .redirects_navi}}
+	{{require admin/navi.t}}
+{{else}}
+	No redirects yet.
+{{end}}
+
+{{require redirects/footer.t}}
+{{require admin/footer.t}}
\ No newline at end of file
@@ -0,0 +1,8 @@
+<div id="left-sidebar">
+	<ul>
+		<li><a href="/admin/redirects">All redirects</a></li>
+		<li><a href="/admin/redirects?auto=1">Moved pages</a></li>
+	</ul>
+</div>
+
+<div id="inner-content">
\ No newline at end of file
@@ -0,0 +1,429 @@
+// Package sitemap_model keeps the list of urls for the XML sitemap and the contents of robots.txt.
+package sitemap_model
+
+import (
+	"encoding/xml"
+	"fmt"
+	"github.com/opesun/extract"
+	"github.com/opesun/hypecms/model/basic"
+	"github.com/opesun/hypecms/modules/content/model"
+	"github.com/opesun/jsonp"
+	"labix.org/v2/mgo"
+	"labix.org/v2/mgo/bson"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Contents have one entry each in the sitemap collection, kept up to date by the content hooks.
+// Tag pages and display points are cheap to list, they are not stored.
+const (
+	Cname        = "sitemap"
+	Max_per_file = 50000 // Limit of the sitemap protocol.
+)
+
+type m map[string]interface{}
+
+// Meta document in the sitemap collection. Stale is set when entries were dropped and must be added again before the next build.
+const meta_id = "meta"
+
+type Url struct {
+	Loc     string `xml:"loc" bson:"loc"`
+	Lastmod string `xml:"lastmod,omitempty" bson:"-"`
+	Mod     int64  `xml:"-" bson:"lastmod"`
+}
+
+func setting(opt map[string]interface{}, key string) (interface{}, bool) {
+	return jsonp.Get(opt, "Modules.sitemap."+key)
+}
+
+// Paths of display points listed in the sitemap, eg. "/" and "/tag-search".
+func Points(opt map[string]interface{}) []string {
+	p, _ := jsonp.GetS(opt, "Modules.sitemap.points")
+	return jsonp.ToStringSlice(p)
+}
+
+// Contents of these types are left out.
+func excludedTypes(opt map[string]interface{}) []string {
+	e, _ := jsonp.GetS(opt, "Modules.sitemap.exclude_types")
+	return jsonp.ToStringSlice(e)
+}
+
+func PerFile(opt map[string]interface{}) int {
+	if v, has := setting(opt, "per_file"); has {
+		var n int
+		switch val := v.(type) {
+		case int:
+			n = val
+		case int64:
+			n = int(val)
+		case float64:
+			n = int(val)
+		}
+		if n > 0 && n <= Max_per_file {
+			return n
+		}
+	}
+	return Max_per_file
+}
+
+func lastMod(content map[string]interface{}) int64 {
+	var ret int64
+	for _, key := range []string{basic.Last_modified, basic.Created} {
+		switch v := content[key].(type) {
+		case int64:
+			ret = v
+		case int:
+			ret = int64(v)
+		case float64:
+			ret = int64(v)
+		}
+		if ret > 0 {
+			break
+		}
+	}
+	return ret
+}
+
+func excluded(opt map[string]interface{}, typ string) bool {
+	for _, v := range excludedTypes(opt) {
+		if v == typ {
+			return true
+		}
+	}
+	return false
+}
+
+// Saves the entry of one content. Contents of excluded types and the ones hidden from search engines are removed.
+func refresh(db *mgo.Database, opt map[string]interface{}, id bson.ObjectId) (map[string]interface{}, error) {
+	content := basic.Find(db, content_model.Cname, id)
+	if content == nil {
+		return nil, Remove(db, id)
+	}
+	typ, _ := content["type"].(string)
+	if excluded(opt, typ) || content_model.Noindex(content) {
+		return nil, Remove(db, id)
+	}
+	_, err := db.C(Cname).UpsertId(id, m{
+		"loc":     content_model.UrlOf(content),
+		"lastmod": lastMod(content),
+		"type":    typ,
+	})
+	return content, err
+}
+
+// Called when a content was inserted or updated.
+func Refresh(db *mgo.Database, opt map[string]interface{}, id bson.ObjectId) error {
+	content, err := refresh(db, opt, id)
+	if err != nil || content == nil {
+		return err
+	}
+	// The paths of subpages change when a page is moved, but only after this hook ran, so they are readded at the next build.
+	if _, is_page := content[content_model.Path_fieldname]; is_page {
+		info, err := db.C(Cname).RemoveAll(m{"_id": m{"$in": descendants(db, id)}})
+		if err != nil {
+			return err
+		}
+		if info.Removed > 0 {
+			return MarkStale(db)
+		}
+	}
+	return nil
+}
+
+func descendants(db *mgo.Database, id bson.ObjectId) []bson.ObjectId {
+	var res []struct {
+		Id bson.ObjectId `bson:"_id"`
+	}
+	db.C(content_model.Cname).Find(m{content_model.Page_ancestors_fieldname: id}).Select(m{"_id": 1}).All(&res)
+	ret := []bson.ObjectId{}
+	for _, v := range res {
+		ret = append(ret, v.Id)
+	}
+	return ret
+}
+
+func Remove(db *mgo.Database, id bson.ObjectId) error {
+	err := db.C(Cname).RemoveId(id)
+	if err == mgo.ErrNotFound {
+		return nil
+	}
+	return err
+}
+
+// Called when contents were changed without triggering the content hooks, eg. at an import.
+func MarkStale(db *mgo.Database) error {
+	_, err := db.C(Cname).UpsertId(meta_id, m{"$set": m{"stale": true}})
+	return err
+}
+
+// Adds the contents missing from the sitemap collection, if any entries were dropped since the last build.
+// Right after installing the module all contents are missing.
+func Fill(db *mgo.Database, opt map[string]interface{}) error {
+	count, err := db.C(Cname).Find(m{"_id": meta_id, "stale": true}).Count()
+	if err != nil || count == 0 {
+		return err
+	}
+	_, err = db.C(Cname).UpsertId(meta_id, m{"$set": m{"stale": false}})
+	if err != nil {
+		return err
+	}
+	iter := db.C(content_model.Cname).Find(m{"type": m{"$nin": excludedTypes(opt)}}).Select(m{"_id": 1}).Iter()
+	var doc struct {
+		Id bson.ObjectId `bson:"_id"`
+	}
+	for iter.Next(&doc) {
+		count, err := db.C(Cname).FindId(doc.Id).Count()
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			continue
+		}
+		_, err = refresh(db, opt, doc.Id)
+		if err != nil {
+			return err
+		}
+	}
+	return iter.Close()
+}
+
+func tagUrls(db *mgo.Database) ([]Url, error) {
+	var tags []struct {
+		Slug string `bson:"slug"`
+	}
+	err := db.C(content_model.Tag_cname).Find(nil).Select(m{"slug": 1}).Sort("slug").All(&tags)
+	if err != nil {
+		return nil, err
+	}
+	ret := []Url{}
+	for _, v := range tags {
+		if len(v.Slug) > 0 {
+			ret = append(ret, Url{Loc: "/tag/" + v.Slug})
+		}
+	}
+	return ret, nil
+}
+
+// Display points and tag pages come first, contents after them.
+func fixedUrls(db *mgo.Database, opt map[string]interface{}) ([]Url, error) {
+	ret := []Url{}
+	for _, v := range Points(opt) {
+		ret = append(ret, Url{Loc: "/" + strings.TrimLeft(v, "/")})
+	}
+	tags, err := tagUrls(db)
+	if err != nil {
+		return nil, err
+	}
+	return append(ret, tags...), nil
+}
+
+// Number of sitemap files, a sitemap index is needed if it is more than one.
+func Files(db *mgo.Database, opt map[string]interface{}) (int, error) {
+	fixed, err := fixedUrls(db, opt)
+	if err != nil {
+		return 0, err
+	}
+	count, err := db.C(Cname).Find(m{"_id": m{"$ne": meta_id}}).Count()
+	if err != nil {
+		return 0, err
+	}
+	per := PerFile(opt)
+	files := (len(fixed) + count + per - 1) / per
+	if files == 0 {
+		files = 1
+	}
+	return files, nil
+}
+
+// Urls in the nth (starting from 1) sitemap file.
+func Urls(db *mgo.Database, opt map[string]interface{}, n int) ([]Url, error) {
+	err := Fill(db, opt)
+	if err != nil {
+		return nil, err
+	}
+	fixed, err := fixedUrls(db, opt)
+	if err != nil {
+		return nil, err
+	}
+	per := PerFile(opt)
+	from := (n - 1) * per
+	ret := []Url{}
+	if from < len(fixed) {
+		to := from + per
+		if to > len(fixed) {
+			to = len(fixed)
+		}
+		ret = append(ret, fixed[from:to]...)
+	}
+	if len(ret) >= per {
+		return ret, nil
+	}
+	skip := from - len(fixed)
+	if skip < 0 {
+		skip = 0
+	}
+	var contents []Url
+	err = db.C(Cname).Find(m{"_id": m{"$ne": meta_id}}).Sort("loc").Skip(skip).Limit(per - len(ret)).All(&contents)
+	if err != nil {
+		return nil, err
+	}
+	return append(ret, contents...), nil
+}
+
+type urlset struct {
+	XMLName xml.Name `xml:"http://www.sitemaps.org/schemas/sitemap/0.9 urlset"`
+	Urls    []Url    `xml:"url"`
+}
+
+type sitemap struct {
+	Loc string `xml:"loc"`
+}
+
+type sitemapindex struct {
+	XMLName  xml.Name  `xml:"http://www.sitemaps.org/schemas/sitemap/0.9 sitemapindex"`
+	Sitemaps []sitemap `xml:"sitemap"`
+}
+
+func render(doc interface{}) ([]byte, error) {
+	out, err := xml.MarshalIndent(doc, "", "\t")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), out...), nil
+}
+
+// base is the scheme and host of the site, eg. "http://example.com".
+func RenderUrls(base string, urls []Url) ([]byte, error) {
+	for i, v := range urls {
+		urls[i].Loc = base + v.Loc
+		if v.Mod > 0 {
+			urls[i].Lastmod = time.Unix(v.Mod, 0).UTC().Format("2006-01-02")
+		}
+	}
+	return render(urlset{Urls: urls})
+}
+
+func RenderIndex(base string, files int) ([]byte, error) {
+	idx := sitemapindex{}
+	for i := 1; i <= files; i++ {
+		idx.Sitemaps = append(idx.Sitemaps, sitemap{fmt.Sprintf("%v/sitemap-%v.xml", base, i)})
+	}
+	return render(idx)
+}
+
+func DefaultRobots() string {
+	return "User-agent: *\nDisallow: /admin\nDisallow: /b/\n"
+}
+
+// The Sitemap line is added automatically, the host is not known in advance.
+func Robots(opt map[string]interface{}, base string) string {
+	r, has := jsonp.GetStr(opt, "Modules.sitemap.robots")
+	if !has {
+		r = DefaultRobots()
+	}
+	if !strings.HasSuffix(r, "\n") {
+		r += "\n"
+	}
+	return r + "Sitemap: " + base + "/sitemap.xml\n"
+}
+
+func splitList(s string) []string {
+	ret := []string{}
+	for _, v := range strings.FieldsFunc(s, func(r rune) bool { return r == ',' || r == '\n' || r == '\r' }) {
+		if v = strings.TrimSpace(v); len(v) > 0 {
+			ret = append(ret, v)
+		}
+	}
+	return ret
+}
+
+// Saves robots.txt and the sitemap settings. Points and excluded types are comma or newline separated lists.
+func SaveConfig(db *mgo.Database, opt map[string]interface{}, inp map[string][]string) error {
+	rule := map[string]interface{}{
+		"robots":        1,
+		"points":        1,
+		"exclude_types": 1,
+		"per_file":      1,
+	}
+	dat, err := extract.New(rule).Extract(inp)
+	if err != nil {
+		return err
+	}
+	str := func(key string) string {
+		s, _ := dat[key].(string)
+		return s
+	}
+	per_file := Max_per_file
+	if s := strings.TrimSpace(str("per_file")); len(s) > 0 {
+		per_file, err = strconv.Atoi(s)
+		if err != nil || per_file < 1 || per_file > Max_per_file {
+			return fmt.Errorf("Urls per file must be a number between 1 and %v.", Max_per_file)
+		}
+	}
+	robots := strings.Replace(str("robots"), "\r\n", "\n", -1)
+	id := basic.CreateOptCopy(db)
+	upd := m{
+		"$set": m{
+			"Modules.sitemap.robots":        robots,
+			"Modules.sitemap.points":        splitList(str("points")),
+			"Modules.sitemap.exclude_types": splitList(str("exclude_types")),
+			"Modules.sitemap.per_file":      per_file,
+		},
+	}
+	err = db.C("options").Update(m{"_id": id}, upd)
+	if err != nil {
+		return err
+	}
+	_, err = db.C(Cname).RemoveAll(m{"type": m{"$in": splitList(str("exclude_types"))}})
+	if err != nil {
+		return err
+	}
+	return MarkStale(db) // Types which are not excluded anymore must be added.
+}
+
+func Install(db *mgo.Database, id bson.ObjectId) error {
+	q := m{"_id": id}
+	upd := m{
+		"$addToSet": m{
+			"Hooks.Front":           "sitemap",
+			"Hooks.contents.insert": "sitemap",
+			"Hooks.contents.update": "sitemap",
+			"Hooks.contents.delete": "sitemap",
+		},
+		"$set": m{
+			"Modules.sitemap": m{
+				"robots":        DefaultRobots(),
+				"points":        []string{"/"},
+				"exclude_types": []string{},
+				"per_file":      Max_per_file,
+			},
+		},
+	}
+	err := db.C("options").Update(q, upd)
+	if err != nil {
+		return err
+	}
+	return MarkStale(db)
+}
+
+func Uninstall(db *mgo.Database, id bson.ObjectId) error {
+	q := m{"_id": id}
+	upd := m{
+		"$pull": m{
+			"Hooks.Front":           "sitemap",
+			"Hooks.contents.insert": "sitemap",
+			"Hooks.contents.update": "sitemap",
+			"Hooks.contents.delete": "sitemap",
+		},
+		"$unset": m{
+			"Modules.sitemap": 1,
+		},
+	}
+	err := db.C("options").Update(q, upd)
+	if err != nil {
+		return err
+	}
+	_, err = db.C(Cname).RemoveAll(nil)
+	return err
+}
@@ -0,0 +1,149 @@
+// Package sitemap serves /sitemap.xml (a sitemap index when the urls don't fit into one file, the files are at /sitemap-1.xml, /sitemap-2.xml...)
+// and /robots.txt.
+package sitemap
+
+import (
+	"github.com/opesun/hypecms/api/context"
+	"github.com/opesun/hypecms/modules/sitemap/model"
+	"github.com/opesun/jsonp"
+	"labix.org/v2/mgo/bson"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var file_rx = regexp.MustCompile(`^/sitemap-([0-9]+)\.xml$`)
+
+func (h *H) Front() (bool, error) {
+	uni := h.uni
+	base := "http://" + uni.Req.Host
+	switch {
+	case uni.P == "/robots.txt":
+		h.write("text/plain; charset=utf-8", []byte(sitemap_model.Robots(uni.Opt, base)))
+		return true, nil
+	case uni.P == "/sitemap.xml":
+		files, err := sitemap_model.Files(uni.Db, uni.Opt)
+		if err != nil {
+			return true, err
+		}
+		if files > 1 {
+			out, err := sitemap_model.RenderIndex(base, files)
+			if err != nil {
+				return true, err
+			}
+			h.write("application/xml; charset=utf-8", out)
+			return true, nil
+		}
+		return true, h.file(base, 1)
+	}
+	if sub := file_rx.FindStringSubmatch(uni.P); sub != nil {
+		n, _ := strconv.Atoi(sub[1])
+		files, err := sitemap_model.Files(uni.Db, uni.Opt)
+		if err != nil {
+			return true, err
+		}
+		if n < 1 || n > files {
+			return false, nil
+		}
+		return true, h.file(base, n)
+	}
+	return false, nil
+}
+
+func (h *H) file(base string, n int) error {
+	urls, err := sitemap_model.Urls(h.uni.Db, h.uni.Opt, n)
+	if err != nil {
+		return err
+	}
+	out, err := sitemap_model.RenderUrls(base, urls)
+	if err != nil {
+		return err
+	}
+	h.write("application/xml; charset=utf-8", out)
+	return nil
+}
+
+func (h *H) write(content_type string, out []byte) {
+	uni := h.uni
+	uni.W.Header().Set("Content-Type", content_type)
+	uni.W.Write(out)
+	uni.Dat["_written"] = true
+}
+
+func idOf(dat map[string]interface{}) (bson.ObjectId, bool) {
+	id, ok := dat["_id"].(bson.ObjectId)
+	return id, ok
+}
+
+func (h *H) ContentsInsert(dat map[string]interface{}) error {
+	if id, ok := idOf(dat); ok {
+		return sitemap_model.Refresh(h.uni.Db, h.uni.Opt, id)
+	}
+	return nil
+}
+
+func (h *H) ContentsUpdate(dat map[string]interface{}) error {
+	return h.ContentsInsert(dat)
+}
+
+func (h *H) ContentsDelete(dat map[string]interface{}) error {
+	if id, ok := idOf(dat); ok {
+		return sitemap_model.Remove(h.uni.Db, id)
+	}
+	return nil
+}
+
+func (h *H) Install(id bson.ObjectId) error {
+	return sitemap_model.Install(h.uni.Db, id)
+}
+
+func (h *H) Uninstall(id bson.ObjectId) error {
+	return sitemap_model.Uninstall(h.uni.Db, id)
+}
+
+func (a *A) SaveConfig() error {
+	return sitemap_model.SaveConfig(a.uni.Db, a.uni.Opt, a.uni.Req.Form)
+}
+
+func (v *V) Index() error {
+	uni := v.uni
+	robots, has := jsonp.GetStr(uni.Opt, "Modules.sitemap.robots")
+	if !has {
+		robots = sitemap_model.DefaultRobots()
+	}
+	uni.Dat["robots"] = robots
+	uni.Dat["points"] = strings.Join(sitemap_model.Points(uni.Opt), "\n")
+	excl, _ := jsonp.GetS(uni.Opt, "Modules.sitemap.exclude_types")
+	uni.Dat["exclude_types"] = strings.Join(jsonp.ToStringSlice(excl), ", ")
+	uni.Dat["per_file"] = sitemap_model.PerFile(uni.Opt)
+	files, err := sitemap_model.Files(uni.Db, uni.Opt)
+	if err != nil {
+		return err
+	}
+	uni.Dat["files"] = files
+	return nil
+}
+
+type A struct {
+	uni *context.Uni
+}
+
+func Actions(uni *context.Uni) *A {
+	return &A{uni}
+}
+
+type H struct {
+	uni *context.Uni
+}
+
+func Hooks(uni *context.Uni) *H {
+	return &H{uni}
+}
+
+type V struct {
+	uni *context.Uni
+}
+
+func Views(uni *context.Uni) *V {
+	return &V{uni}
+}
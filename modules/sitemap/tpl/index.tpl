@@ -0,0 +1,24 @@
+{{require admin/header.t}}
+{{require sitemap/sidebar.t}}
+
+<h4>Sitemap</h4>
+The sitemap has {{.files}} file(s). Contents are added and updated automatically, tag pages are always listed.<br />
+<br />
+<form action="/b/sitemap/save_config" method="post">
+	Display points to list, one path per line:<br />
+	<textarea name="points" rows="5" cols="60">{{.points}}</textarea><br />
+	<br />
+	Content types to leave out, comma separated:<br />
+	<input name="exclude_types" type="text" value="{{.exclude_types}}" /><br />
+	<br />
+	Urls per file (at most 50000):<br />
+	<input name="per_file" type="text" value="{{.per_file}}" /><br />
+	<br />
+	robots.txt (the Sitemap line is added automatically):<br />
+	<textarea name="robots" rows="10" cols="60">{{.robots}}</textarea><br />
+	<br />
+	<input type="submit" value="Save">
+</form>
+
+{{require sitemap/footer.t}}
+{{require admin/footer.t}}
\ No newline at end of file
@@ -0,0 +1,9 @@
+<div id="left-sidebar">
+	<ul>
+		<li><a href="/admin/sitemap">Sitemap and robots.txt</a></li>
+		<li><a href="/sitemap.xml">View sitemap</a></li>
+		<li><a href="/robots.txt">View robots.txt</a></li>
+	</ul>
+</div>
+
+<div id="inner-content">
\ No newline at end of file
@@ -6,12 +6,14 @@ import (
 	"github.com/opesun/extract"
 	"github.com/opesun/hypecms/model/basic"
 	"github.com/opesun/hypecms/model/scut"
+	"github.com/opesun/hypecms/modules/display/model"
 	"github.com/opesun/require"
 	"io/ioutil"
 	"labix.org/v2/mgo"
 	"labix.org/v2/mgo/bson"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 )
 
@@ -301,16 +303,23 @@ type ReqLink struct {
 	Filepath string
 }
 
-// Extracts all requires ( {{require example.t}} ) from a given file.
+type byOffset [][]int
+
+func (b byOffset) Len() int           { return len(b) }
+func (b byOffset) Less(i, j int) bool { return b[i][0] < b[j][0] }
+func (b byOffset) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+
+// Extracts all requires ( {{require example.t}} ) and extended layouts ( {{extends layout.t}} ) from a given file, in the order they appear in it.
 // Takes into account fallback files too.
 // First it checks if the file exists in the current template. If yes, the link will point to that file.
 // If not, then the link will point to the fallback module file.
 // TODO: Case when the required file does not exists anywhere is not handled.
 func ReqLinks(opt map[string]interface{}, file, root, host string) []ReqLink {
-	pos := require.RequirePositions(file)
+	pos := append(display_model.ExtendsPositions(file), require.RequirePositions(file)...)
+	sort.Sort(byOffset(pos))
 	ret := []ReqLink{}
 	for _, v := range pos {
-		fi := file[v[0]+10 : v[1]-2] // cut {{require anything/anything.t}} => anything/anything.t
+		fi := file[v[0]+10 : v[1]-2] // cut {{require anything/anything.t}} => anything/anything.t, {{extends x}} is just as long.
 		var typ, path, name string
 		exists_in_template, err := Exists(filepath.Join(root, scut.GetTPath(opt, host), fi))
 		if err != nil {
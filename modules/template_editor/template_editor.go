@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"github.com/opesun/hypecms/api/context"
 	"github.com/opesun/hypecms/model/scut"
+	"github.com/opesun/hypecms/modules/display/model"
 	te_model "github.com/opesun/hypecms/modules/template_editor/model"
 	"io/ioutil"
 	"labix.org/v2/mgo/bson"
@@ -13,44 +14,52 @@ import (
 	"strings"
 )
 
+// Drops the cached templates after a successful modification.
+func clearCache(err error) error {
+	if err == nil {
+		display_model.ClearCache()
+	}
+	return err
+}
+
 func (a *A) NewFile() error {
 	uni := a.uni
-	return te_model.NewFile(uni.Opt, uni.Req.Form, uni.Root, uni.Req.Host)
+	return clearCache(te_model.NewFile(uni.Opt, uni.Req.Form, uni.Root, uni.Req.Host))
 }
 
 func (a *A) SaveFile() error {
 	uni := a.uni
-	return te_model.SaveFile(uni.Opt, uni.Req.Form, uni.Root, uni.Req.Host)
+	return clearCache(te_model.SaveFile(uni.Opt, uni.Req.Form, uni.Root, uni.Req.Host))
 }
 
 func (a *A) DeleteFile() error {
 	uni := a.uni
-	return te_model.DeleteFile(uni.Opt, uni.Req.Form, uni.Root, uni.Req.Host)
+	return clearCache(te_model.DeleteFile(uni.Opt, uni.Req.Form, uni.Root, uni.Req.Host))
 }
 
 func (a *A) ForkPublic() error {
 	uni := a.uni
-	return te_model.ForkPublic(uni.Db, uni.Opt, uni.Root, uni.Req.Host)
+	return clearCache(te_model.ForkPublic(uni.Db, uni.Opt, uni.Root, uni.Req.Host))
 }
 
 func (a *A) PublishPrivate() error {
 	uni := a.uni
-	return te_model.PublishPrivate(uni.Db, uni.Opt, uni.Req.Form, uni.Root, uni.Req.Host)
+	return clearCache(te_model.PublishPrivate(uni.Db, uni.Opt, uni.Req.Form, uni.Root, uni.Req.Host))
 }
 
 func (a *A) DeletePrivate() error {
 	uni := a.uni
-	return te_model.DeletePrivate(uni.Opt, uni.Req.Form, uni.Root, uni.Req.Host)
+	return clearCache(te_model.DeletePrivate(uni.Opt, uni.Req.Form, uni.Root, uni.Req.Host))
 }
 
 func (a *A) ForkPrivate() error {
 	uni := a.uni
-	return te_model.ForkPrivate(uni.Db, uni.Opt, uni.Req.Form, uni.Root, uni.Req.Host)
+	return clearCache(te_model.ForkPrivate(uni.Db, uni.Opt, uni.Req.Form, uni.Root, uni.Req.Host))
 }
 
 func (a *A) SwitchToTemplate() error {
 	uni := a.uni
-	return te_model.SwitchToTemplate(uni.Db, uni.Req.Form, uni.Root, uni.Req.Host)
+	return clearCache(te_model.SwitchToTemplate(uni.Db, uni.Req.Form, uni.Root, uni.Req.Host))
 }
 
 func threePath(host, typ, name string) (string, error) {
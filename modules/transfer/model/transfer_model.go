@@ -0,0 +1,778 @@
+// Package transfer_model moves a whole site between installations in one zip archive.
+//
+// Layout of an archive:
+//
+//	manifest.json              see Manifest
+//	collections/<name>.bson    documents of a collection, one after the other (like mongodump)
+//	options.bson               the current option document
+//	templates/...              private templates of the host
+//	uploads/...                uploaded files of the host
+//
+// At import every ObjectId in the archive gets a new value, references between the documents are kept.
+package transfer_model
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"github.com/opesun/hypecms/model/basic"
+	"github.com/opesun/hypecms/modules/content/model"
+	"github.com/opesun/hypecms/modules/media/model"
+	"github.com/opesun/hypecms/modules/redirects/model"
+	"github.com/opesun/hypecms/modules/sitemap/model"
+	"io"
+	"io/ioutil"
+	"labix.org/v2/mgo"
+	"labix.org/v2/mgo/bson"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+type m map[string]interface{}
+
+const (
+	Format         = "hypecms-archive"
+	Version        = 1
+	Reports_cname  = "transfer_reports"
+	manifest_name  = "manifest.json"
+	options_name   = "options.bson"
+	coll_prefix    = "collections/"
+	tpl_prefix     = "templates/"
+	uploads_prefix = "uploads/"
+)
+
+// Collections in an archive, in the order of import.
+var Collections = []string{
+	"users",
+	content_model.Tag_cname,
+	media_model.Cname,
+	content_model.Cname,
+	content_model.Cname + basic.Version_collection_postfix,
+	content_model.Cname + content_model.Draft_collection_postfix,
+	content_model.Comment_cname,
+	redirects_model.Cname,
+	redirects_model.Slug_history_cname,
+}
+
+// Fields of users never leaving the site.
+var user_secrets = []string{"password", "password_again"}
+
+type Manifest struct {
+	Format      string         `json:"format"`
+	Version     int            `json:"version"`
+	Created     int64          `json:"created"`
+	Host        string         `json:"host"`
+	Collections map[string]int `json:"collections"` // Number of documents.
+	Options     bool           `json:"options"`
+	Files       int            `json:"files"`
+}
+
+// Exports are stored on the server, they can be downloaded or imported from the admin.
+func Dir(root, host string) string {
+	return filepath.Join(root, "exports", host)
+}
+
+func templateDir(root, host string) string {
+	return filepath.Join(root, "templates", "private", host)
+}
+
+func uploadDir(root, host string) string {
+	return filepath.Join(root, "uploads", host)
+}
+
+var name_rx = regexp.MustCompile(`^[a-zA-Z0-9_\-]+\.zip$`)
+
+// Path of an export by name, with the name checked so nothing outside of the export directory can be accessed.
+func Path(root, host, name string) (string, error) {
+	if !name_rx.MatchString(name) {
+		return "", fmt.Errorf("Invalid archive name %v.", name)
+	}
+	return filepath.Join(Dir(root, host), name), nil
+}
+
+type Export struct {
+	Name    string
+	Size    int64
+	Created int64
+}
+
+// Newest first.
+func Exports(root, host string) ([]Export, error) {
+	infos, err := ioutil.ReadDir(Dir(root, host))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	ret := []Export{}
+	for _, v := range infos {
+		if !v.IsDir() && name_rx.MatchString(v.Name()) {
+			ret = append(ret, Export{v.Name(), v.Size(), v.ModTime().Unix()})
+		}
+	}
+	sort.Sort(byNewest(ret))
+	return ret, nil
+}
+
+type byNewest []Export
+
+func (b byNewest) Len() int           { return len(b) }
+func (b byNewest) Less(i, j int) bool { return b[i].Created > b[j].Created }
+func (b byNewest) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+
+func DeleteExport(root, host, name string) error {
+	path, err := Path(root, host, name)
+	if err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+// Writes documents as a stream of BSON documents.
+func writeDocs(w io.Writer, docs []bson.M) error {
+	for _, v := range docs {
+		b, err := bson.Marshal(v)
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Reads a stream written by writeDocs.
+func readDocs(b []byte) ([]bson.M, error) {
+	ret := []bson.M{}
+	for len(b) > 0 {
+		if len(b) < 5 {
+			return nil, fmt.Errorf("Truncated BSON document.")
+		}
+		l := int(binary.LittleEndian.Uint32(b[:4]))
+		if l < 5 || l > len(b) {
+			return nil, fmt.Errorf("Bad BSON document length %v.", l)
+		}
+		doc := bson.M{}
+		if err := bson.Unmarshal(b[:l], &doc); err != nil {
+			return nil, err
+		}
+		ret = append(ret, doc)
+		b = b[l:]
+	}
+	return ret, nil
+}
+
+func addFile(z *zip.Writer, name string, content []byte) error {
+	w, err := z.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(content)
+	return err
+}
+
+// Adds the files under dir into the archive under prefix. Returns the number of files added.
+func addDir(z *zip.Writer, dir, prefix string) (int, error) {
+	count := 0
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil || info.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w, err := z.Create(prefix + filepath.ToSlash(rel))
+		if err != nil {
+			return err
+		}
+		count++
+		_, err = io.Copy(w, f)
+		return err
+	})
+	return count, err
+}
+
+// Creates an archive of the site and saves it among the exports. Returns the name of the archive.
+func ExportSite(db *mgo.Database, root, host string) (string, error) {
+	err := os.MkdirAll(Dir(root, host), os.ModePerm)
+	if err != nil {
+		return "", err
+	}
+	name := "export-" + time.Now().Format("20060102-150405") + ".zip"
+	path := filepath.Join(Dir(root, host), name)
+	f, err := os.Create(path)
+	if err != nil {
+		return "", err
+	}
+	err = writeArchive(db, f, root, host)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(path)
+		return "", err
+	}
+	return name, nil
+}
+
+// Writes the documents of a collection into the archive one by one as they are read, so big collections are never in the memory as a whole.
+// Returns the number of documents written.
+func addColl(z *zip.Writer, db *mgo.Database, coll string) (int, error) {
+	w, err := z.Create(coll_prefix + coll + ".bson")
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	iter := db.C(coll).Find(nil).Iter()
+	doc := bson.M{}
+	for iter.Next(&doc) {
+		if coll == "users" {
+			for _, s := range user_secrets {
+				delete(doc, s)
+			}
+		}
+		if err := writeDocs(w, []bson.M{doc}); err != nil {
+			iter.Close()
+			return 0, err
+		}
+		count++
+		doc = bson.M{}
+	}
+	return count, iter.Close()
+}
+
+func writeArchive(db *mgo.Database, w io.Writer, root, host string) error {
+	z := zip.NewWriter(w)
+	man := Manifest{
+		Format:      Format,
+		Version:     Version,
+		Created:     time.Now().Unix(),
+		Host:        host,
+		Collections: map[string]int{},
+	}
+	for _, coll := range Collections {
+		n, err := addColl(z, db, coll)
+		if err != nil {
+			return err
+		}
+		man.Collections[coll] = n
+	}
+	var opt bson.M
+	err := db.C("options").Find(nil).Sort("-created").One(&opt)
+	if err == nil {
+		buf := &bytes.Buffer{}
+		if err := writeDocs(buf, []bson.M{opt}); err != nil {
+			return err
+		}
+		if err := addFile(z, options_name, buf.Bytes()); err != nil {
+			return err
+		}
+		man.Options = true
+	}
+	tpls, err := addDir(z, templateDir(root, host), tpl_prefix)
+	if err != nil {
+		return err
+	}
+	uploads, err := addDir(z, uploadDir(root, host), uploads_prefix)
+	if err != nil {
+		return err
+	}
+	man.Files = tpls + uploads
+	mb, err := json.MarshalIndent(man, "", "\t")
+	if err != nil {
+		return err
+	}
+	if err := addFile(z, manifest_name, mb); err != nil {
+		return err
+	}
+	return z.Close()
+}
+
+// Replaces every ObjectId found in the map in the given value, recursively.
+func remap(i interface{}, ids map[bson.ObjectId]bson.ObjectId) interface{} {
+	switch v := i.(type) {
+	case bson.ObjectId:
+		if n, has := ids[v]; has {
+			return n
+		}
+	case bson.M:
+		for key, val := range v {
+			v[key] = remap(val, ids)
+		}
+	case map[string]interface{}:
+		for key, val := range v {
+			v[key] = remap(val, ids)
+		}
+	case []interface{}:
+		for j, val := range v {
+			v[j] = remap(val, ids)
+		}
+	}
+	return i
+}
+
+// Replaces the old hexes of ids in a string, used for file names containing ids (eg. uploaded media).
+func remapHexes(s string, ids map[bson.ObjectId]bson.ObjectId) string {
+	for _, hex := range hex_rx.FindAllString(s, -1) {
+		if n, has := ids[bson.ObjectIdHex(hex)]; has {
+			s = strings.Replace(s, hex, n.Hex(), -1)
+		}
+	}
+	return s
+}
+
+var hex_rx = regexp.MustCompile(`[0-9a-f]{24}`)
+
+func ofDropped(s string, dropped map[bson.ObjectId]bool) bool {
+	for _, hex := range hex_rx.FindAllString(s, -1) {
+		if dropped[bson.ObjectIdHex(hex)] {
+			return true
+		}
+	}
+	return false
+}
+
+// Options of the import.
+type ImportOptions struct {
+	Options bool // Import the option document too, it becomes the current one.
+	Files   bool // Import templates and uploads.
+	DryRun  bool // Only report what would happen.
+}
+
+type Report struct {
+	Archive   string         `bson:"archive"`
+	Created   int64          `bson:"created"`
+	DryRun    bool           `bson:"dry_run"`
+	Imported  map[string]int `bson:"imported"`
+	Conflicts []string       `bson:"conflicts"`
+	Files     int            `bson:"files"`
+}
+
+func (r *Report) conflict(format string, a ...interface{}) {
+	r.Conflicts = append(r.Conflicts, fmt.Sprintf(format, a...))
+}
+
+type archive struct {
+	manifest Manifest
+	files    map[string]*zip.File
+}
+
+func (a *archive) read(name string) ([]byte, bool, error) {
+	f, has := a.files[name]
+	if !has {
+		return nil, false, nil
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return nil, true, err
+	}
+	defer rc.Close()
+	b, err := ioutil.ReadAll(rc)
+	return b, true, err
+}
+
+func openArchive(z *zip.Reader) (*archive, error) {
+	a := &archive{files: map[string]*zip.File{}}
+	for _, v := range z.File {
+		a.files[v.Name] = v
+	}
+	mb, has, err := a.read(manifest_name)
+	if err != nil {
+		return nil, err
+	}
+	if !has {
+		return nil, fmt.Errorf("Archive has no manifest.")
+	}
+	if err := json.Unmarshal(mb, &a.manifest); err != nil {
+		return nil, fmt.Errorf("Bad manifest: %v", err)
+	}
+	if a.manifest.Format != Format {
+		return nil, fmt.Errorf("Not a hypeCMS archive.")
+	}
+	if a.manifest.Version > Version {
+		return nil, fmt.Errorf("Archive version %v is newer than the supported %v.", a.manifest.Version, Version)
+	}
+	return a, nil
+}
+
+// Documents already in the database which an imported document would clash with.
+// Users and tags are merged into the existing ones, every other clashing document is skipped.
+func existing(db *mgo.Database, coll string, doc bson.M) (bson.ObjectId, string) {
+	var q bson.M
+	var desc string
+	switch coll {
+	case "users":
+		q, desc = bson.M{"name": doc["name"]}, fmt.Sprintf("user %v", doc["name"])
+	case content_model.Tag_cname:
+		q, desc = bson.M{"slug": doc["slug"]}, fmt.Sprintf("tag %v", doc["slug"])
+	case content_model.Cname:
+		or := []interface{}{}
+		if slug, ok := doc["slug"].(string); ok && len(slug) > 0 {
+			or = append(or, bson.M{"slug": slug})
+		}
+		if path, ok := doc[content_model.Path_fieldname].(string); ok && len(path) > 0 {
+			or = append(or, bson.M{content_model.Path_fieldname: path})
+		}
+		if len(or) == 0 {
+			return "", ""
+		}
+		q, desc = bson.M{"$or": or}, fmt.Sprintf("content %v", doc["slug"])
+	case redirects_model.Cname:
+		if doc["kind"] != redirects_model.Exact {
+			return "", ""
+		}
+		q, desc = bson.M{"kind": redirects_model.Exact, "from": doc["from"]}, fmt.Sprintf("redirect from %v", doc["from"])
+	case redirects_model.Slug_history_cname:
+		q, desc = bson.M{"slug": doc["slug"]}, fmt.Sprintf("old slug %v", doc["slug"])
+	default:
+		return "", ""
+	}
+	var ex struct {
+		Id bson.ObjectId `bson:"_id"`
+	}
+	if err := db.C(coll).Find(q).Select(bson.M{"_id": 1}).One(&ex); err != nil {
+		return "", ""
+	}
+	return ex.Id, desc
+}
+
+func merged(coll string) bool {
+	return coll == "users" || coll == content_model.Tag_cname
+}
+
+// Tells if a document contains any of the ids.
+func references(i interface{}, ids map[bson.ObjectId]bool) bool {
+	switch v := i.(type) {
+	case bson.ObjectId:
+		return ids[v]
+	case bson.M:
+		for _, val := range v {
+			if references(val, ids) {
+				return true
+			}
+		}
+	case map[string]interface{}:
+		for _, val := range v {
+			if references(val, ids) {
+				return true
+			}
+		}
+	case []interface{}:
+		for _, val := range v {
+			if references(val, ids) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Documents referencing a skipped one would be orphans (eg. the versions, drafts, comments and old slugs of a clashing content),
+// so they are skipped too, and the ones referencing those, and so on.
+func dropOrphans(docs map[string][]bson.M, skip, dropped map[bson.ObjectId]bool, rep *Report) {
+	for changed := true; changed; {
+		changed = false
+		for _, coll := range Collections {
+			for _, doc := range docs[coll] {
+				old, _ := doc["_id"].(bson.ObjectId)
+				if skip[old] || !references(doc, dropped) {
+					continue
+				}
+				rep.conflict("Document %v of %v references a skipped document, skipped.", old.Hex(), coll)
+				skip[old], dropped[old] = true, true
+				changed = true
+			}
+		}
+	}
+}
+
+// Puts the imported contents into the search index and recounts the tags they have.
+func afterImport(db *mgo.Database, opt map[string]interface{}, contents []bson.ObjectId, rep *Report) error {
+	ix, err := content_model.NewIndexer(db, opt)
+	if err != nil {
+		return err
+	}
+	tags := map[bson.ObjectId]bool{}
+	for _, id := range contents {
+		if err := content_model.IndexContent(db, ix, opt, id); err != nil {
+			rep.conflict("Can't index content %v: %v", id.Hex(), err)
+		}
+		var c struct {
+			Tags []bson.ObjectId `bson:"_tags"`
+		}
+		if err := db.C(content_model.Cname).FindId(id).Select(bson.M{content_model.Tag_fieldname: 1}).One(&c); err != nil {
+			continue
+		}
+		for _, v := range c.Tags {
+			tags[v] = true
+		}
+	}
+	for id, _ := range tags {
+		if err := content_model.RecountTag(db, id); err != nil {
+			rep.conflict("Can't recount tag %v: %v", id.Hex(), err)
+		}
+	}
+	return nil
+}
+
+// Imports an archive into the site. opt is the current option document, it is used for indexing the imported contents,
+// unless the options are imported too.
+func ImportSite(db *mgo.Database, opt map[string]interface{}, root, host string, z *zip.Reader, name string, opts ImportOptions) (*Report, error) {
+	a, err := openArchive(z)
+	if err != nil {
+		return nil, err
+	}
+	rep := &Report{
+		Archive:  name,
+		Created:  time.Now().Unix(),
+		DryRun:   opts.DryRun,
+		Imported: map[string]int{},
+	}
+	docs := map[string][]bson.M{}
+	ids := map[bson.ObjectId]bson.ObjectId{}
+	skip := map[bson.ObjectId]bool{}    // Not inserted.
+	dropped := map[bson.ObjectId]bool{} // Not inserted and not merged either, nothing can refer to these.
+	// First every id gets its new value, so references can be rewritten regardless of the order of the collections.
+	for _, coll := range Collections {
+		b, has, err := a.read(coll_prefix + coll + ".bson")
+		if err != nil {
+			return nil, err
+		}
+		if !has {
+			continue
+		}
+		list, err := readDocs(b)
+		if err != nil {
+			return nil, fmt.Errorf("Collection %v: %v", coll, err)
+		}
+		for _, doc := range list {
+			old, ok := doc["_id"].(bson.ObjectId)
+			if !ok {
+				continue
+			}
+			if ex, desc := existing(db, coll, doc); len(ex) > 0 {
+				skip[old] = true
+				if merged(coll) {
+					rep.conflict("%v already exists, merged into the existing one.", desc)
+					ids[old] = ex
+					continue
+				}
+				rep.conflict("%v already exists, skipped.", desc)
+				dropped[old] = true
+				continue
+			}
+			ids[old] = bson.NewObjectId()
+		}
+		docs[coll] = list
+	}
+	dropOrphans(docs, skip, dropped, rep)
+	contents := []bson.ObjectId{}
+	for _, coll := range Collections {
+		for _, doc := range docs[coll] {
+			old, _ := doc["_id"].(bson.ObjectId)
+			if skip[old] {
+				continue
+			}
+			remap(doc, ids)
+			if coll == media_model.Cname {
+				for _, key := range []string{"file", "url"} {
+					if s, ok := doc[key].(string); ok {
+						doc[key] = remapHexes(s, ids)
+					}
+				}
+			}
+			if !opts.DryRun {
+				if err := db.C(coll).Insert(doc); err != nil {
+					rep.conflict("Can't insert into %v: %v", coll, err)
+					continue
+				}
+				if coll == content_model.Cname {
+					contents = append(contents, doc["_id"].(bson.ObjectId))
+				}
+			}
+			rep.Imported[coll]++
+		}
+	}
+	if opts.Options {
+		b, has, err := a.read(options_name)
+		if err != nil {
+			return nil, err
+		}
+		if has {
+			list, err := readDocs(b)
+			if err != nil || len(list) != 1 {
+				return nil, fmt.Errorf("Bad option document in archive.")
+			}
+			imp := list[0]
+			remap(imp, ids)
+			imp["_id"] = bson.NewObjectId()
+			imp["created"] = time.Now().Unix() // The newest one is the current.
+			if !opts.DryRun {
+				if err := db.C("options").Insert(imp); err != nil {
+					return nil, err
+				}
+			}
+			opt = basic.Convert(imp).(map[string]interface{})
+			rep.Imported["options"] = 1
+		}
+	}
+	if opts.Files {
+		n, err := importFiles(a, root, host, ids, dropped, opts.DryRun, rep)
+		if err != nil {
+			return nil, err
+		}
+		rep.Files = n
+	}
+	if !opts.DryRun {
+		err = sitemap_model.MarkStale(db)
+		if err != nil {
+			return nil, err
+		}
+		err = afterImport(db, opt, contents, rep)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return rep, nil
+}
+
+// Existing files are never overwritten, and the uploads of skipped documents are left out.
+func importFiles(a *archive, root, host string, ids map[bson.ObjectId]bson.ObjectId, dropped map[bson.ObjectId]bool, dry_run bool, rep *Report) (int, error) {
+	names := []string{}
+	for name, _ := range a.files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	count := 0
+	for _, name := range names {
+		var dir, rel string
+		switch {
+		case strings.HasPrefix(name, tpl_prefix):
+			dir, rel = templateDir(root, host), name[len(tpl_prefix):]
+		case strings.HasPrefix(name, uploads_prefix):
+			if ofDropped(name, dropped) {
+				rep.conflict("File %v belongs to a skipped document, skipped.", name)
+				continue
+			}
+			dir, rel = uploadDir(root, host), remapHexes(name[len(uploads_prefix):], ids)
+		default:
+			continue
+		}
+		clean := filepath.Clean(filepath.FromSlash(rel))
+		if len(rel) == 0 || strings.HasSuffix(rel, "/") || filepath.IsAbs(clean) || strings.HasPrefix(clean, "..") {
+			continue
+		}
+		path := filepath.Join(dir, clean)
+		if _, err := os.Stat(path); err == nil {
+			rep.conflict("File %v already exists, skipped.", name)
+			continue
+		}
+		count++
+		if dry_run {
+			continue
+		}
+		b, _, err := a.read(name)
+		if err != nil {
+			return count, err
+		}
+		if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+			return count, err
+		}
+		if err := ioutil.WriteFile(path, b, 0644); err != nil {
+			return count, err
+		}
+	}
+	return count, nil
+}
+
+// Imports an archive from the export directory.
+func ImportFile(db *mgo.Database, opt map[string]interface{}, root, host, name string, opts ImportOptions) (*Report, error) {
+	path, err := Path(root, host, name)
+	if err != nil {
+		return nil, err
+	}
+	r, err := zip.OpenReader(path)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return ImportSite(db, opt, root, host, &r.Reader, name, opts)
+}
+
+// Imports an uploaded archive.
+func ImportUpload(db *mgo.Database, opt map[string]interface{}, root, host string, f io.ReaderAt, size int64, name string, opts ImportOptions) (*Report, error) {
+	z, err := zip.NewReader(f, size)
+	if err != nil {
+		return nil, fmt.Errorf("Not a zip archive: %v", err)
+	}
+	return ImportSite(db, opt, root, host, z, name, opts)
+}
+
+// Reports are kept so the admin can read the conflicts later. Returns the id of the saved report.
+func SaveReport(db *mgo.Database, rep *Report) (bson.ObjectId, error) {
+	id := bson.NewObjectId()
+	doc := bson.M{}
+	b, err := bson.Marshal(rep)
+	if err != nil {
+		return "", err
+	}
+	if err := bson.Unmarshal(b, &doc); err != nil {
+		return "", err
+	}
+	doc["_id"] = id
+	return id, db.C(Reports_cname).Insert(doc)
+}
+
+func Reports(db *mgo.Database, limit int) ([]interface{}, error) {
+	var res []interface{}
+	err := db.C(Reports_cname).Find(nil).Sort("-created").Limit(limit).All(&res)
+	if err != nil {
+		return nil, err
+	}
+	return basic.Convert(res).([]interface{}), nil
+}
+
+func Install(db *mgo.Database, id bson.ObjectId) error {
+	q := m{"_id": id}
+	upd := m{
+		"$addToSet": m{
+			"Hooks.Front":          "transfer",
+			"Hooks.shellFunctions": "transfer",
+		},
+		"$set": m{
+			"Modules.transfer": m{},
+		},
+	}
+	return db.C("options").Update(q, upd)
+}
+
+func Uninstall(db *mgo.Database, id bson.ObjectId) error {
+	q := m{"_id": id}
+	upd := m{
+		"$pull": m{
+			"Hooks.Front":          "transfer",
+			"Hooks.shellFunctions": "transfer",
+		},
+		"$unset": m{
+			"Modules.transfer": 1,
+		},
+	}
+	return db.C("options").Update(q, upd)
+}
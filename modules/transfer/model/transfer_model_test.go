@@ -0,0 +1,64 @@
+package transfer_model
+
+import (
+	"bytes"
+	"labix.org/v2/mgo/bson"
+	"testing"
+)
+
+func TestDocStream(t *testing.T) {
+	docs := []bson.M{
+		{"_id": bson.NewObjectId(), "title": "first"},
+		{"_id": bson.NewObjectId(), "tags": []interface{}{"a", "b"}},
+	}
+	buf := &bytes.Buffer{}
+	if err := writeDocs(buf, docs); err != nil {
+		t.Fatal(err)
+	}
+	read, err := readDocs(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(read) != 2 || read[0]["_id"] != docs[0]["_id"] || read[0]["title"] != "first" {
+		t.Fatal(read)
+	}
+	if _, err := readDocs(buf.Bytes()[:10]); err == nil {
+		t.Fatal("Truncated stream should not be read.")
+	}
+}
+
+func TestRemap(t *testing.T) {
+	a, b, c := bson.NewObjectId(), bson.NewObjectId(), bson.NewObjectId()
+	ids := map[bson.ObjectId]bson.ObjectId{a: b}
+	doc := bson.M{
+		"_id":  a,
+		"refs": []interface{}{a, c},
+		"sub":  bson.M{"parent": a},
+	}
+	remap(doc, ids)
+	if doc["_id"] != b || doc["refs"].([]interface{})[0] != b || doc["refs"].([]interface{})[1] != c || doc["sub"].(bson.M)["parent"] != b {
+		t.Fatal(doc)
+	}
+	if s := remapHexes("media/"+a.Hex()+".jpg", ids); s != "media/"+b.Hex()+".jpg" {
+		t.Fatal(s)
+	}
+}
+
+func TestDropOrphans(t *testing.T) {
+	content, version, comment, other := bson.NewObjectId(), bson.NewObjectId(), bson.NewObjectId(), bson.NewObjectId()
+	docs := map[string][]bson.M{
+		Collections[3]: {{"_id": content}, {"_id": other}},
+		Collections[4]: {{"_id": version, "_parent": content}},
+		Collections[6]: {{"_id": comment, "_contents_parent": content, "reply_to": []interface{}{version}}},
+	}
+	skip := map[bson.ObjectId]bool{content: true}
+	dropped := map[bson.ObjectId]bool{content: true}
+	rep := &Report{}
+	dropOrphans(docs, skip, dropped, rep)
+	if !skip[version] || !skip[comment] || skip[other] || len(rep.Conflicts) != 2 {
+		t.Fatal(skip, rep.Conflicts)
+	}
+	if !ofDropped("media/"+content.Hex()+"/a.jpg", dropped) || ofDropped("media/"+other.Hex()+"/a.jpg", dropped) {
+		t.Fatal("Uploads of dropped documents should be recognized.")
+	}
+}
@@ -0,0 +1,52 @@
+{{require admin/header.t}}
+{{require transfer/sidebar.t}}
+
+<h4>Export</h4>
+Contents (with versions and drafts), comments, tags, users (without passwords), media, redirects, the option document, private templates and uploads go into one archive.<br />
+<br />
+<form action="/b/transfer/export" method="post">
+	<input type="submit" value="Export the site">
+</form>
+<br />
+{{if .exports}}
+	{{range .exports}}
+		<div class="list-item">
+			<a class="delete" href="/b/transfer/delete_export?name={{.Name}}">-</a>
+			<a href="/transfer/download?name={{.Name}}">{{.Name}}</a> ({{.Size}} bytes, {{date .Created}})
+			<form action="/b/transfer/import" method="post" style="display: inline;">
+				<input type="hidden" name="name" value="{{.Name}}">
+				<input type="hidden" name="dry_run" value="1">
+				<input type="submit" value="Try import">
+			</form>
+		</div>
+	{{end}}
+{{else}}
+	No exports yet.<br />
+{{end}}
+<br />
+
+<h4>Import</h4>
+Every document gets a new id. Users and tags already existing (by name and slug) are merged, other clashing documents and existing files are skipped.<br />
+<br />
+<form action="/b/transfer/import" method="post" enctype="multipart/form-data">
+	<input type="file" name="archive"><br />
+	<input type="checkbox" name="options"> Import the option document too (it becomes the current one)<br />
+	<input type="checkbox" name="files" CHECKED> Import templates and uploads<br />
+	<input type="checkbox" name="dry_run"> Only report what would happen<br />
+	<input type="submit" value="Import">
+</form>
+<br />
+
+{{if .reports}}
+	<h4>Import reports</h4>
+	{{range .reports}}
+		<div class="list-item">
+			<b>{{.archive}}</b>, {{date .created}}{{if .dry_run}} (dry run){{end}}:
+			{{range $coll, $count := .imported}}{{$coll}}: {{$count}} {{end}}files: {{.files}}<br />
+			{{range .conflicts}}{{.}}<br />{{end}}
+		</div>
+	{{end}}
+{{end}}
+
+{{require transfer/footer.t}}
+{{require admin/footer.t}}
\ No newline at end of file
@@ -0,0 +1,173 @@
+// Package transfer exports the site into an archive and imports archives, from the admin and from the shell:
+//
+//	export
+//	import "export-20120901-120000.zip" `{"options": true, "files": true, "dry_run": false}`
+package transfer
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/opesun/hypecms/api/context"
+	"github.com/opesun/hypecms/api/response"
+	"github.com/opesun/hypecms/model/scut"
+	"github.com/opesun/hypecms/modules/transfer/model"
+	"labix.org/v2/mgo/bson"
+	"net/http"
+	"path/filepath"
+)
+
+type m map[string]interface{}
+
+// Downloads of exports, only for admins: /transfer/download?name={name}
+// The name is in the query, a url ending in ".zip" would be taken for an uploaded file.
+func (h *H) Front() (bool, error) {
+	uni := h.uni
+	if uni.P != "/transfer/download" {
+		return false, nil
+	}
+	if !scut.IsAdmin(uni.Dat["_user"]) {
+		return true, fmt.Errorf("Only admins can download exports.")
+	}
+	path, err := transfer_model.Path(uni.Root, uni.Req.Host, uni.Req.FormValue("name"))
+	if err != nil {
+		return true, err
+	}
+	if rw, ok := uni.W.(*response.Writer); ok {
+		rw.Stream() // Archives can be big, no need to keep them in the memory.
+	}
+	uni.W.Header().Set("Content-Disposition", "attachment; filename="+filepath.Base(path))
+	http.ServeFile(uni.W, uni.Req, path)
+	uni.Dat["_written"] = true
+	return true, nil
+}
+
+// Adds the export and import commands to the shell.
+func (h *H) ShellFunctions(uni *context.Uni, f, d map[string]interface{}) {
+	d["export"] = "Exports the site into an archive, returns the name of the archive."
+	d["import"] = "Imports archive 'a' from the exports, 'b' is a JSON object with the options \"options\", \"files\" and \"dry_run\"."
+	f["export"] = func() map[string]interface{} {
+		name, err := transfer_model.ExportSite(uni.Db, uni.Root, uni.Req.Host)
+		if err != nil {
+			return m{"error": err.Error()}
+		}
+		return m{"name": name}
+	}
+	f["import"] = func(a, b string) map[string]interface{} {
+		var opts struct {
+			Options bool `json:"options"`
+			Files   bool `json:"files"`
+			DryRun  bool `json:"dry_run"`
+		}
+		if len(b) > 0 {
+			if err := json.Unmarshal([]byte(b), &opts); err != nil {
+				return m{"error": err.Error()}
+			}
+		}
+		rep, err := transfer_model.ImportFile(uni.Db, uni.Opt, uni.Root, uni.Req.Host, a, transfer_model.ImportOptions{Options: opts.Options, Files: opts.Files, DryRun: opts.DryRun})
+		if err != nil {
+			return m{"error": err.Error()}
+		}
+		transfer_model.SaveReport(uni.Db, rep)
+		return m{"imported": rep.Imported, "files": rep.Files, "conflicts": rep.Conflicts}
+	}
+}
+
+func (h *H) Install(id bson.ObjectId) error {
+	return transfer_model.Install(h.uni.Db, id)
+}
+
+func (h *H) Uninstall(id bson.ObjectId) error {
+	return transfer_model.Uninstall(h.uni.Db, id)
+}
+
+func (a *A) Export() error {
+	uni := a.uni
+	name, err := transfer_model.ExportSite(uni.Db, uni.Root, uni.Req.Host)
+	if err != nil {
+		return err
+	}
+	uni.Dat["_cont"] = map[string]interface{}{"name": name}
+	return nil
+}
+
+func (a *A) DeleteExport() error {
+	uni := a.uni
+	return transfer_model.DeleteExport(uni.Root, uni.Req.Host, uni.Req.FormValue("name"))
+}
+
+func importOptions(form func(string) string) transfer_model.ImportOptions {
+	return transfer_model.ImportOptions{
+		Options: len(form("options")) > 0,
+		Files:   len(form("files")) > 0,
+		DryRun:  len(form("dry_run")) > 0,
+	}
+}
+
+// Imports either an uploaded archive (multipart form, "archive" field), or one from the exports ("name").
+func (a *A) Import() error {
+	uni := a.uni
+	var rep *transfer_model.Report
+	var err error
+	if name := uni.Req.FormValue("name"); len(name) > 0 {
+		rep, err = transfer_model.ImportFile(uni.Db, uni.Opt, uni.Root, uni.Req.Host, name, importOptions(uni.Req.FormValue))
+	} else {
+		f, fh, ferr := uni.Req.FormFile("archive")
+		if ferr != nil {
+			return fmt.Errorf("No archive was uploaded.")
+		}
+		defer f.Close()
+		size, serr := f.Seek(0, 2)
+		if serr != nil {
+			return serr
+		}
+		rep, err = transfer_model.ImportUpload(uni.Db, uni.Opt, uni.Root, uni.Req.Host, f, size, fh.Filename, importOptions(uni.Req.FormValue))
+	}
+	if err != nil {
+		return err
+	}
+	id, err := transfer_model.SaveReport(uni.Db, rep)
+	if err != nil {
+		return err
+	}
+	uni.Dat["_cont"] = map[string]interface{}{"report": id.Hex(), "conflicts": len(rep.Conflicts)}
+	return nil
+}
+
+func (v *V) Index() error {
+	uni := v.uni
+	exports, err := transfer_model.Exports(uni.Root, uni.Req.Host)
+	if err != nil {
+		return err
+	}
+	uni.Dat["exports"] = exports
+	reports, err := transfer_model.Reports(uni.Db, 10)
+	if err != nil {
+		return err
+	}
+	uni.Dat["reports"] = reports
+	return nil
+}
+
+type A struct {
+	uni *context.Uni
+}
+
+func Actions(uni *context.Uni) *A {
+	return &A{uni}
+}
+
+type H struct {
+	uni *context.Uni
+}
+
+func Hooks(uni *context.Uni) *H {
+	return &H{uni}
+}
+
+type V struct {
+	uni *context.Uni
+}
+
+func Views(uni *context.Uni) *V {
+	return &V{uni}
+}
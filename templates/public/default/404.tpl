@@ -1,3 +1,2 @@
-{{require header.t}}
-<h2>Cant find file: {{.missing_file}}<br /></h2>
-{{require footer.t}}
\ No newline at end of file
+{{extends layout.t}}
+{{slot content}}<h2>Cant find file: {{.missing_file}}<br /></h2>{{endslot}}
\ No newline at end of file
@@ -0,0 +1,2 @@
+{{extends layout.t}}
+{{slot content}}<h2>Something went wrong, sorry. Please try again later.<br /></h2>{{endslot}}
\ No newline at end of file
@@ -15,11 +15,11 @@
 				</div>
 				from 
 				<a href="/user/{{._users_created_by.name}}" rel="nofollow">{{._users_created_by.name}}</a>
-				<a href="/b/content/delete_comment?type={{$con.type}}&content_id={{$con._id}}&comment_id={{.comment_id}}" style="float:right; border-bottom:1px #000;" class="delete"><img src="/template/icon_delete13.gif"></a>
+				<a href="/b/content/delete_comment?type={{$con.type}}&content_id={{$con._id}}&comment_id={{._id}}" style="float:right; border-bottom:1px #000;" class="delete"><img src="/template/icon_delete13.gif"></a>
 				<div class="clear"></div>
 			</dt>
 			<dd class="comment-body" id="Blog1_cmt-2075200508431235064">
-				<p>{{.comment_content}}</p>
+				<p>{{if $.comments_html}}{{safe_html .comment_content}}{{else}}{{.comment_content}}{{end}}</p>
 			</dd>
 			<dd class="comment-footer">
 				<span class="comment-timestamp">
@@ -34,6 +34,10 @@
 			</dd>
 			{{end}}
 		</dl>
+		{{if .comments_navi}}
+			{{$navi := .comments_navi}}
+			{{require admin/navi.t}}
+		{{end}}
 	{{else}}
 		No comments yet.
 	{{end}}
@@ -6,13 +6,29 @@
 				<div class="widget Blog" id="Blog1">
 					<div class="blog-posts hfeed">
 						<div class="post hentry uncustomized-post-template">
+							{{if .breadcrumbs}}
+							<p class="breadcrumbs">
+								{{range .breadcrumbs}}<a href="/{{.path}}">{{.title}}</a> / {{end}}{{.content.title}}
+							</p>
+							{{end}}
 							<h3 class="post-title entry-title">{{.content.title}}</h3>
+							{{if .translations}}
+							<p class="translations">
+								{{range .translations}}{{if .current}}{{.lang}}{{else}}<a href="{{.url}}" hreflang="{{.lang}}">{{.lang}}</a>{{end}} {{end}}
+							</p>
+							{{end}}
 							{{$tags := .content._tags}}
 							{{$user_name := .content._users_created_by.name}}
 							{{$created := .content.created}}
 							{{require post_header.t}}	
 							<div class="post-body entry-content">
-								<p>{{html .content.content}}</p>
+								<p>{{safe_html .content.content}}</p>
+								{{if .content.children}}
+								<ul class="children">
+									{{range .content.children}}<li><a href="/{{.path}}">{{.title}}</a></li>{{end}}
+								</ul>
+								{{end}}
+								<p class="feed"><a href="/rss/comments/{{.content.slug}}">Comments feed</a></p>
 								{{require comment_listing.t}}
 								{{require comment_insert.t}}
 							</div>
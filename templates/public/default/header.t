@@ -4,10 +4,19 @@
 <head>
 	<meta http-equiv="Content-Type" content="text/html; charset=us-ascii" />
 <!--[if IE]> <script> (function() { var html5 = ("abbr,article,aside,audio,canvas,datalist,details," + "figure,footer,header,hgroup,mark,menu,meter,nav,output," + "progress,section,time,video").split(','); for (var i = 0; i < html5.length; i++) { document.createElement(html5[i]); } try { document.execCommand('BackgroundImageCache', false, true); } catch(e) {} })(); </script> <![endif]-->
+	{{if .seo}}
+	{{seo_tags}}
+	{{else}}
 	<title>hypeCMS</title>
+	{{end}}
 	<link type="text/css" rel="stylesheet" href="/template/widget_css_bundle.css" />
 	<link type="text/css" rel="stylesheet" href="/template/authorization.css" />
 	<link type="text/css" rel="stylesheet" href="/template/style.css" />
+	<link rel="alternate" type="application/rss+xml" title="hypeCMS" href="/rss" />
+	<link rel="alternate" type="application/atom+xml" title="hypeCMS" href="/atom" />
+	{{range .translations}}
+	<link rel="alternate" hreflang="{{.lang}}" href="{{.url}}" />
+	{{end}}
 </head>
 <body>
 	<div id="outer-wrapper">
@@ -38,8 +47,9 @@
 											{{if is_admin}}
 												<li class="current"><a href="/admin">Admin</a></li>
 											{{end}}
-											<li><a href="/about-us">About us</a></li>
-											<li><a href="/contact">Contact</a></li>
+											{{range menu}}
+												<li{{if .active}} class="current"{{end}}><a href="/{{.path}}">{{.title}}</a></li>
+											{{end}}
 										</ul>
 										<div class="clear"></div>
 										<span class="widget-item-control">
@@ -0,0 +1,3 @@
+{{require header.t}}
+{{slot content}}{{endslot}}
+{{require footer.t}}
\ No newline at end of file
@@ -1,6 +1,6 @@
 <div class="post-footer">
 	<div class="post-footer-line post-footer-line-1">
-		{{if .comments}}
+		{{if .comment_count}}
 		<!--<span class="date-header">time stamp...</span>-->
 		<span class="post-comment-link"><a class="comment-link" href="/{{.slug}}#comments">{{$comment_count}} comments</a></span>
 		{{else}}
@@ -7,6 +7,16 @@
 				{{if .error}}
 					{{.error}}
 				{{else}} 						
+					{{if .tag}}
+					<h3>
+						{{range .tag._tags_ancestors}}<a href="/tag/{{.slug}}">{{.name}}</a> / {{end}}{{.tag.name}}
+					</h3>
+					{{if .tag_children}}
+					<p>
+						{{range .tag_children}}<a href="/tag/{{.slug}}">{{.name}}</a> {{end}}
+					</p>
+					{{end}}
+					{{end}}
 					<div class="blog-posts hfeed">
 					{{range .content_list}}
 						<div class="post hentry uncustomized-post-template">